@@ -0,0 +1,56 @@
+package main
+
+// 内置 action 的处理函数，由 NewServer 注册，可以用 HandleAction 替换
+func (s *Server) builtinActions() map[string]func(*Client, *Message) {
+	return map[string]func(*Client, *Message){
+		"subscribe":   s.actionSubscribe,
+		"unsubscribe": s.actionUnsubscribe,
+		"unsubscribe_all": func(client *Client, msg *Message) {
+			s.handleUnsubscribeAll(client, msg.RequestID)
+		},
+		"publish": s.handlePublish,
+		"ping":    s.handlePing,
+		"channel_stats": func(client *Client, msg *Message) {
+			s.handleChannelStats(client, msg.Channel, msg.RequestID)
+		},
+		"history": func(client *Client, msg *Message) {
+			s.handleHistory(client, msg.Channel, msg.Since, msg.RequestID)
+		},
+		"ack":          s.handleAck,
+		"set_will":     s.handleSetWill,
+		"set_batching": s.handleSetBatching,
+		"whoami": func(client *Client, msg *Message) {
+			s.handleWhoAmI(client, msg.RequestID)
+		},
+		"history_ack":    s.handleHistoryAck,
+		"history_cancel": s.handleHistoryCancel,
+	}
+}
+
+func (s *Server) actionSubscribe(client *Client, msg *Message) {
+	opts := subscribeOptions{since: msg.Since, sinceSeq: msg.SinceSeq, compress: msg.Compress, requestID: msg.RequestID}
+	if len(msg.Channels) > 0 {
+		s.handleSubscribeMany(client, msg.Channels, opts)
+	} else {
+		s.handleSubscribe(client, msg.Channel, opts)
+	}
+}
+
+func (s *Server) actionUnsubscribe(client *Client, msg *Message) {
+	if len(msg.Channels) > 0 {
+		s.handleUnsubscribeMany(client, msg.Channels, msg.RequestID)
+	} else {
+		s.handleUnsubscribe(client, msg.Channel, msg.RequestID)
+	}
+}
+
+// 注册 action 的处理函数，同名的内置 action（subscribe、publish、ping 等）会被替换；传 nil 取消注册，
+// 之后该 action 按未知操作处理（或交给 Messages）。处理函数在该连接的 readPump 中调用，
+// 此前只读连接、频道校验、维护模式和 SetActionValidator 的检查都已通过。应在启动前调用
+func (s *Server) HandleAction(action string, h func(*Client, *Message)) {
+	if h == nil {
+		delete(s.actionHandlers, action)
+		return
+	}
+	s.actionHandlers[action] = h
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestHandleAction(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.HandleAction("echo", func(client *Client, msg *Message) {
+			s.sendResponse(client, Response{ClientID: client.ID, RequestID: msg.RequestID, Action: "echo", Code: CodeSuccess, Msg: "success", Data: msg.Data})
+		})
+		// 替换内置的 ping
+		s.HandleAction("ping", func(client *Client, msg *Message) {
+			s.sendResponse(client, Response{ClientID: client.ID, RequestID: msg.RequestID, Action: "pong", Code: CodeSuccess, Msg: "custom"})
+		})
+		// 取消注册后按未知操作处理
+		s.HandleAction("whoami", nil)
+	})
+	c := Dial(t, ts, "")
+
+	c.Send(Message{Action: "echo", RequestID: "e1", Data: "hello"})
+	if resp := c.Expect("echo"); resp.Data != "hello" || resp.RequestID != "e1" {
+		t.Fatalf("echo 响应 %+v", resp)
+	}
+	c.Send(Message{Action: "ping"})
+	if resp := c.Expect("pong"); resp.Msg != "custom" || resp.Data != nil {
+		t.Fatalf("ping 应由替换后的处理函数响应, 收到 %+v", resp)
+	}
+	c.Send(Message{Action: "whoami"})
+	if resp := c.Expect("whoami"); resp.Code != CodeUnknownAction {
+		t.Fatalf("取消注册后 whoami 响应 %+v, want %d", resp, CodeUnknownAction)
+	}
+	// 内置的其他 action 不受影响
+	c.Subscribe("room")
+}
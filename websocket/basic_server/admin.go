@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 被脱敏的元数据值
+const redactedValue = "[REDACTED]"
+
+// 服务器状态快照（用于调试）
+type StateSnapshot struct {
+	Time     time.Time      `json:"time"`
+	Clients  []ClientState  `json:"clients"`
+	Channels map[string]int `json:"channels"` // 频道 -> 订阅数
+	Panics   int64          `json:"panics"`   // 事件循环中恢复的panic次数
+
+	BufferedBytes int64 `json:"bufferedBytes"` // 所有发送队列的总字节数
+	Shed          int64 `json:"shed"`          // 因全局缓冲超限丢弃的消息数
+
+	SerializationErrors int64 `json:"serializationErrors"` // 序列化失败次数
+}
+
+// 单个客户端的状态快照
+type ClientState struct {
+	ID          string            `json:"id"`
+	UserID      string            `json:"userId,omitempty"`
+	Channels    []string          `json:"channels"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	QueueDepth  int               `json:"queueDepth"`
+	Overflowed  int               `json:"overflowed,omitempty"`
+	ConnectedAt time.Time         `json:"connectedAt"`
+	LastSeen    time.Time         `json:"lastSeen"`
+	Traffic     TrafficStats      `json:"traffic"`
+	Compression bool              `json:"compression"` // 握手时是否协商出了 permessage-deflate
+}
+
+// 导出当前完整状态为 JSON
+func (s *Server) DumpState(w io.Writer) error {
+	snapshot := s.snapshotState()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snapshot)
+}
+
+// 在读锁内只做拷贝，序列化放到锁外
+func (s *Server) snapshotState() StateSnapshot {
+	redact := make(map[string]bool, len(s.RedactKeys))
+	for _, key := range s.RedactKeys {
+		redact[key] = true
+	}
+
+	s.mu.RLock()
+	snapshot := StateSnapshot{
+		Time:     time.Now(),
+		Clients:  make([]ClientState, 0, len(s.clients)),
+		Channels: s.subscriptions.counts(),
+		Panics:   s.PanicCount(),
+
+		BufferedBytes: s.BufferedBytes(),
+		Shed:          s.ShedCount(),
+
+		SerializationErrors: s.SerializationErrors(),
+	}
+	for client := range s.clients {
+		state := ClientState{
+			ID:          client.ID,
+			Channels:    client.channelList(),
+			UserID:      client.UserID,
+			QueueDepth:  client.QueueLen(),
+			ConnectedAt: client.connectedAt,
+			LastSeen:    time.Unix(0, client.lastSeen.Load()),
+			Traffic:     client.Traffic(),
+			Compression: client.compressionNegotiated,
+		}
+		if len(client.Metadata) > 0 {
+			state.Metadata = make(map[string]string, len(client.Metadata))
+			for key, value := range client.Metadata {
+				if redact[key] {
+					value = redactedValue
+				}
+				state.Metadata[key] = value
+			}
+		}
+		snapshot.Clients = append(snapshot.Clients, state)
+	}
+	s.mu.RUnlock()
+
+	if s.Overflow != nil {
+		for i := range snapshot.Clients {
+			snapshot.Clients[i].Overflowed = s.Overflow.Len(snapshot.Clients[i].ID)
+		}
+	}
+	for i := range snapshot.Clients {
+		sort.Strings(snapshot.Clients[i].Channels)
+	}
+	sort.Slice(snapshot.Clients, func(i, j int) bool {
+		return snapshot.Clients[i].ID < snapshot.Clients[j].ID
+	})
+	return snapshot
+}
+
+// 状态导出接口，需要通过 AdminAuthorizer
+func (s *Server) HandleDumpState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.DumpState(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// 客户端列表接口，包含每个连接的流量计数
+func (s *Server) HandleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.snapshotState().Clients)
+}
+
+// 连接与频道的简要统计
+type StatsSnapshot struct {
+	Connections int            `json:"connections"`
+	Channels    map[string]int `json:"channels"` // 频道 -> 订阅数
+	StartedAt   time.Time      `json:"startedAt"`
+	Uptime      string         `json:"uptime"`
+
+	// 客户端ID -> 最近一次心跳往返时间（毫秒），只包含已测量过的客户端
+	RTTMillis map[string]float64 `json:"rttMillis,omitempty"`
+
+	// 按关闭码类别的断开次数：normal / going_away / error / abnormal / other
+	Disconnects map[string]int64 `json:"disconnects"`
+
+	// 协商出 permessage-deflate 的连接数
+	CompressedConnections int `json:"compressedConnections"`
+
+	BufferedBytes int64 `json:"bufferedBytes"` // 所有发送队列的总字节数
+	Shed          int64 `json:"shed"`          // 因 MaxBufferedBytes 丢弃的消息数
+}
+
+// 统计快照：锁内只拷贝计数，序列化在锁外进行
+func (s *Server) Stats() StatsSnapshot {
+	s.mu.RLock()
+	connections := len(s.clients)
+	rtt := make(map[string]float64)
+	compressed := 0
+	for client := range s.clients {
+		if d := client.LastRTT(); d > 0 {
+			rtt[client.ID] = float64(d) / float64(time.Millisecond)
+		}
+		if client.compressionNegotiated {
+			compressed++
+		}
+	}
+	s.mu.RUnlock()
+
+	return StatsSnapshot{
+		Connections: connections,
+		Channels:    s.subscriptions.counts(),
+		StartedAt:   s.startedAt,
+		Uptime:      time.Since(s.startedAt).Round(time.Second).String(),
+		RTTMillis:   rtt,
+		Disconnects: s.Metrics.disconnects(),
+
+		CompressedConnections: compressed,
+
+		BufferedBytes: s.BufferedBytes(),
+		Shed:          s.ShedCount(),
+	}
+}
+
+// 统计接口，比 /metrics 更便于人工查看
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.Stats())
+}
+
+// 检查管理请求的权限：没有设置 AdminAuthorizer 或它返回 false 时写出 403，返回 false
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.AdminAuthorizer != nil && s.AdminAuthorizer(r) {
+		return true
+	}
+	s.Logger.Warn("管理请求未授权", "event", "admin_denied", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// 按 "Authorization: Bearer <token>" 校验管理请求的 AdminAuthorizer，令牌按常量时间比较
+func BearerTokenAuthorizer(token string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+	}
+}
+
+// 踢出客户端接口：POST {"clientId": "...", "reason": "..."}。
+// 请求必须通过 AdminAuthorizer，未设置时一律拒绝
+func (s *Server) HandleKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		ClientID string `json:"clientId"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "kicked"
+	}
+
+	if err := s.Disconnect(req.ClientID, req.Reason); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Client disconnected"))
+}
+
+// 单个连接的详细信息，供客服等工具按ID查看
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Channels    []string  `json:"channels"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	LastSeen    time.Time `json:"lastSeen"`
+	QueueDepth  int       `json:"queueDepth"`
+	RTTMillis   float64   `json:"rttMillis,omitempty"` // 最近一次心跳往返时间，还没有测量时省略
+	Compression bool      `json:"compression"`         // 握手时是否协商出了 permessage-deflate
+	Priority    int       `json:"priority,omitempty"`  // 投递优先级，默认 0 时省略
+}
+
+// 按ID获取单个连接的快照，客户端不存在时 ok 为 false
+func (s *Server) ClientInfo(id string) (info ClientInfo, ok bool) {
+	s.mu.RLock()
+	client := s.byID[id]
+	if client != nil {
+		info = ClientInfo{
+			ID:          client.ID,
+			UserID:      client.UserID,
+			RemoteAddr:  client.Metadata["remote_addr"],
+			Channels:    client.channelList(),
+			ConnectedAt: client.connectedAt,
+			LastSeen:    time.Unix(0, client.lastSeen.Load()),
+			QueueDepth:  client.QueueLen(),
+			RTTMillis:   float64(client.LastRTT()) / float64(time.Millisecond),
+			Compression: client.compressionNegotiated,
+			Priority:    client.Priority(),
+		}
+	}
+	s.mu.RUnlock()
+
+	if client == nil {
+		return ClientInfo{}, false
+	}
+	sort.Strings(info.Channels)
+	return info, true
+}
+
+// 单个客户端详情接口：GET /clients/{id}，客户端不存在时返回 404，需要通过 AdminAuthorizer
+func (s *Server) HandleClientInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	info, ok := s.ClientInfo(strings.TrimPrefix(r.URL.Path, "/clients/"))
+	if !ok {
+		http.Error(w, ErrClientNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func kickRequest(clientID, token string) *http.Request {
+	r := httptest.NewRequest("POST", "/admin/kick", strings.NewReader(`{"clientId":"`+clientID+`","reason":"spam"}`))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestHandleKickRequiresAdminAuthorizer(t *testing.T) {
+	s, ts := NewTestServer(t)
+	// 终端用户认证通过不代表可以踢人
+	s.Authenticator = func(r *http.Request) (string, error) { return "alice", nil }
+	c := Dial(t, ts, "")
+
+	w := httptest.NewRecorder()
+	s.HandleKick(w, kickRequest(c.ID, ""))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("未设置 AdminAuthorizer: status = %d, want 403", w.Code)
+	}
+
+	s.AdminAuthorizer = BearerTokenAuthorizer("secret")
+	w = httptest.NewRecorder()
+	s.HandleKick(w, kickRequest(c.ID, "wrong"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("令牌错误: status = %d, want 403", w.Code)
+	}
+	if serverClient(s, c.ID) == nil {
+		t.Fatal("未授权的请求不应断开客户端")
+	}
+}
+
+func TestHandleKickDisconnectsClient(t *testing.T) {
+	s, ts := NewTestServer(t)
+	s.AdminAuthorizer = BearerTokenAuthorizer("secret")
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+
+	w := httptest.NewRecorder()
+	s.HandleKick(w, kickRequest(c.ID, "secret"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body.String())
+	}
+	if code, reason := c.ExpectClosed(); code != websocket.ClosePolicyViolation || reason != "spam" {
+		t.Fatalf("关闭帧 = %d %q", code, reason)
+	}
+	waitFor(t, "client removed", func() bool { return serverClient(s, c.ID) == nil })
+	if n := s.ChannelCount("room"); n != 0 {
+		t.Fatalf("被踢出的客户端仍在频道中: %d", n)
+	}
+
+	// 客户端已离开：HTTP 接口返回 404，重复调用 Disconnect 没有副作用
+	w = httptest.NewRecorder()
+	s.HandleKick(w, kickRequest(c.ID, "secret"))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	if err := s.Disconnect(c.ID, "again"); err != ErrClientNotFound {
+		t.Fatalf("err = %v, want ErrClientNotFound", err)
+	}
+}
+
+func TestAdminEndpointsRequireAuthorization(t *testing.T) {
+	s, ts := NewTestServer(t)
+	c := Dial(t, ts, "token=hunter2")
+	waitFor(t, "client registered", func() bool { return serverClient(s, c.ID) != nil })
+
+	endpoints := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/admin/state", s.HandleDumpState},
+		{"/admin/clients", s.HandleClients},
+		{"/clients/" + c.ID, s.HandleClientInfo},
+	}
+	for _, e := range endpoints {
+		w := httptest.NewRecorder()
+		e.handler(w, httptest.NewRequest("GET", e.path, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s 未授权: status = %d, want 403", e.path, w.Code)
+		}
+		if strings.Contains(w.Body.String(), c.ID) {
+			t.Errorf("%s 未授权时泄露了客户端ID", e.path)
+		}
+	}
+
+	s.AdminAuthorizer = BearerTokenAuthorizer("secret")
+	for _, e := range endpoints {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", e.path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		e.handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s 已授权: status = %d, want 200", e.path, w.Code)
+		}
+	}
+}
+
+func TestMetricsAndStatsNeedNoAuthorization(t *testing.T) {
+	s, _ := NewTestServer(t)
+	for path, handler := range map[string]http.HandlerFunc{"/metrics": s.HandleMetrics, "/stats": s.HandleStats} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200（未设置 AdminAuthorizer 时可直接抓取）", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "time"
+
+// 全服公告（如维护通知）：不论订阅情况，发给当前所有连接，action 为 "announcement"。
+// 与频道广播一样经由事件循环投递，缓冲区满的客户端会被断开
+func (s *Server) BroadcastToAll(data interface{}) {
+	s.announce <- data
+}
+
+// 把公告投递给所有连接（只在事件循环中调用）
+func (s *Server) deliverAnnouncement(data interface{}) {
+	response := Response{
+		Action: "announcement",
+		Code:   CodeSuccess,
+		Msg:    "success",
+		Data:   data,
+		SentAt: time.Now().UnixMilli(),
+	}
+	payload, ok := s.marshal(response)
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	delivered := 0
+	var slow []*Client
+	for _, client := range clients {
+		if client.Degraded() {
+			s.Metrics.SlowDrops.Add(1)
+			continue
+		}
+		if s.shouldShed(client, len(payload)) {
+			s.shed.Add(1)
+			continue
+		}
+		frame, ok := s.sharedFrame(client, response, payload)
+		if !ok {
+			continue
+		}
+		if !s.enqueue(client, frame) {
+			if !s.degrade(client) {
+				slow = append(slow, client)
+			}
+			continue
+		}
+		delivered++
+	}
+	for _, client := range slow {
+		s.Metrics.SlowEvictions.Add(1)
+		s.removeClient(client)
+	}
+	s.Logger.Info("发送全服公告", "event", "announcement", "clients", len(clients), "delivered", delivered)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestBroadcastToAll(t *testing.T) {
+	s, ts := NewTestServer(t)
+	// 不论订阅了什么频道（或什么都没订阅）都会收到
+	a := Dial(t, ts, "")
+	b := Dial(t, ts, "")
+	b.Subscribe("room")
+	c := Dial(t, ts, "channels=news")
+
+	s.BroadcastToAll("maintenance at 02:00")
+	for _, client := range []*TestClient{a, b, c} {
+		if msg := client.Expect("announcement"); msg.Data != "maintenance at 02:00" || msg.Channel != "" {
+			t.Fatalf("收到 %+v", msg)
+		}
+	}
+}
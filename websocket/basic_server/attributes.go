@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// 连接上的任意键值属性（如语言、设备类型、用户角色），可在 OnConnect 中写入，
+// 在 OnMessage 等钩子中读取。读写循环和钩子并发运行，所有方法都是并发安全的
+type Attributes struct {
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+// 设置属性，覆盖已有的值
+func (a *Attributes) Set(key string, value interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.values == nil {
+		a.values = make(map[string]interface{})
+	}
+	a.values[key] = value
+}
+
+// 读取属性，ok 为 false 表示未设置
+func (a *Attributes) Get(key string) (value interface{}, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	value, ok = a.values[key]
+	return value, ok
+}
+
+// 删除属性
+func (a *Attributes) Delete(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.values, key)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestAttributesConcurrentAccess(t *testing.T) {
+	var attrs Attributes
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", g)
+			for i := 0; i < 1000; i++ {
+				attrs.Set(key, i)
+				if v, ok := attrs.Get(key); !ok || v.(int) != i {
+					t.Errorf("Get(%s) = %v %v, want %d", key, v, ok, i)
+					return
+				}
+				attrs.Get("shared")
+				if i%10 == 0 {
+					attrs.Delete("shared")
+				} else {
+					attrs.Set("shared", g)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	attrs.Delete("k0")
+	if _, ok := attrs.Get("k0"); ok {
+		t.Fatal("删除后不应还能读到")
+	}
+}
+
+func TestAttributesVisibleToHooks(t *testing.T) {
+	var role interface{}
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.OnConnect = func(client *Client) {
+			client.Attributes.Set("role", client.Metadata["query.role"])
+		}
+		s.OnMessage = func(client *Client, msg *Message) bool {
+			if msg.Action == "ping" {
+				role, _ = client.Attributes.Get("role")
+			}
+			return true
+		}
+	})
+	c := Dial(t, ts, "role=admin")
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+	if role != "admin" {
+		t.Fatalf("OnMessage 读到 role = %v", role)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 多实例之间转发广播的消息总线。设置 Server.Backplane 后，BroadcastToChannel 等频道广播
+// 在本地投递的同时发布到总线，其它实例收到后只投递给自己的本地订阅者，不再转发
+type Backplane interface {
+	Publish(channel string, data []byte) error
+	// 阻塞接收所有实例发布的消息，直到 Close 或连接中断
+	Subscribe(handler func(channel string, data []byte)) error
+	Close() error
+}
+
+// 总线上传输的广播
+type backplaneEnvelope struct {
+	Origin        string          `json:"origin"` // 发布实例，收到自己发布的消息时忽略
+	Data          json.RawMessage `json:"data"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+}
+
+// 把本地发起的广播发布到总线
+func (s *Server) publishBackplane(msg BroadcastMsg) {
+	if s.Backplane == nil {
+		return
+	}
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		s.Logger.Error("总线消息序列化失败", "event", "backplane_error", "channel", msg.Channel, "error", err)
+		return
+	}
+	payload, err := json.Marshal(backplaneEnvelope{Origin: s.nodeID, Data: data, CorrelationID: msg.CorrelationID})
+	if err != nil {
+		s.Logger.Error("总线消息序列化失败", "event", "backplane_error", "channel", msg.Channel, "error", err)
+		return
+	}
+	if err := s.Backplane.Publish(msg.Channel, payload); err != nil {
+		s.Logger.Error("发布到总线失败", "event", "backplane_error", "channel", msg.Channel, "error", err)
+	}
+}
+
+// 总线订阅中断后的重试间隔
+const backplaneRetryDelay = time.Second
+
+// 接收总线消息并投递给本地订阅者（由 Run 在后台启动），订阅中断时重试直到服务器关闭
+func (s *Server) runBackplane() {
+	for {
+		err := s.Backplane.Subscribe(s.deliverBackplane)
+		if s.closing.Load() {
+			return
+		}
+		s.Logger.Error("总线订阅中断，稍后重试", "event", "backplane_error", "error", err)
+		select {
+		case <-time.After(backplaneRetryDelay):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// 投递一条总线消息
+func (s *Server) deliverBackplane(channel string, payload []byte) {
+	var envelope backplaneEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		s.Logger.Warn("总线消息解析失败", "event", "backplane_error", "channel", channel, "error", err)
+		return
+	}
+	// 自己发布的消息已经在本地投递过
+	if envelope.Origin == s.nodeID {
+		return
+	}
+	var data interface{}
+	if err := decodeJSON(envelope.Data, &data); err != nil {
+		s.Logger.Warn("总线消息解析失败", "event", "backplane_error", "channel", channel, "error", err)
+		return
+	}
+
+	select {
+	case s.broadcast <- BroadcastMsg{Channel: channel, Data: data, CorrelationID: envelope.CorrelationID}:
+	case <-s.done:
+	}
+}
+
+// 基于 Redis pub/sub 的总线，每个频道对应 Redis 频道 Prefix+channel
+type RedisBackplane struct {
+	Client *redis.Client
+	Prefix string // 默认 "ws:"
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+}
+
+// 创建 Redis 总线
+func NewRedisBackplane(rdb *redis.Client, prefix string) *RedisBackplane {
+	if prefix == "" {
+		prefix = "ws:"
+	}
+	return &RedisBackplane{Client: rdb, Prefix: prefix}
+}
+
+func (b *RedisBackplane) Publish(channel string, data []byte) error {
+	return b.Client.Publish(context.Background(), b.Prefix+channel, data).Err()
+}
+
+func (b *RedisBackplane) Subscribe(handler func(channel string, data []byte)) error {
+	ctx := context.Background()
+	pubsub := b.Client.PSubscribe(ctx, b.Prefix+"*")
+	b.mu.Lock()
+	b.pubsub = pubsub
+	b.mu.Unlock()
+
+	// 等待订阅确认，连接失败时立即返回错误
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	for message := range pubsub.Channel() {
+		handler(strings.TrimPrefix(message.Channel, b.Prefix), []byte(message.Payload))
+	}
+	return nil
+}
+
+func (b *RedisBackplane) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pubsub == nil {
+		return nil
+	}
+	return b.pubsub.Close()
+}
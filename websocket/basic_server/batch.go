@@ -0,0 +1,133 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端开启或关闭批量模式：data 为 true 时，队列中积压的频道消息在写出时合并为一个 JSON 数组帧
+func (s *Server) handleSetBatching(client *Client, msg *Message) {
+	enabled, ok := msg.Data.(bool)
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: msg.RequestID,
+		Action:    "set_batching",
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+	switch {
+	case !ok:
+		response.Code = CodeBadRequest
+		response.Msg = "data must be a boolean"
+	case enabled && s.BatchSize <= 1:
+		response.Code = CodeForbidden
+		response.Msg = "batching not enabled on server"
+	case enabled && !isJSONCodec(s.codec()):
+		// 批量帧是把多条消息拼成 JSON 数组，其它编解码器无法这样合并
+		response.Code = CodeForbidden
+		response.Msg = "batching requires the JSON codec"
+	default:
+		client.batching.Store(enabled)
+		response.Data = map[string]interface{}{"enabled": enabled, "batchSize": s.BatchSize}
+	}
+	s.sendResponse(client, response)
+}
+
+// 是否按批量模式写出这一帧：只合并频道消息，它们都是 JSON 对象，其它帧（响应、SendToClient 等）单独写出
+func (s *Server) batching(client *Client, message OutboundMessage) bool {
+	return s.BatchSize > 1 && client.batching.Load() && batchable(message)
+}
+
+func batchable(message OutboundMessage) bool {
+	return message.Type == websocket.TextMessage && message.Channel != ""
+}
+
+// 以 first 开头合并写出最多 BatchSize 条频道消息。队列中已有的消息立即取出，
+// 不足时在 FlushInterval 内等待后续消息；遇到不能合并的帧时先写出批量帧再写出它。
+// 发送通道在收集期间被关闭时 closed 为 true，由调用方写出关闭帧
+func (s *Server) writeBatch(client *Client, first OutboundMessage) (closed bool, err error) {
+	batch := []OutboundMessage{first}
+	var next *OutboundMessage
+
+	var deadline <-chan time.Time
+	if s.FlushInterval > 0 {
+		timer := time.NewTimer(s.FlushInterval)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+collect:
+	for len(batch) < s.BatchSize {
+		var message OutboundMessage
+		var ok bool
+		select {
+		case message, ok = <-client.Send:
+		default:
+			if deadline == nil {
+				break collect
+			}
+			select {
+			case message, ok = <-client.Send:
+			case <-deadline:
+				break collect
+			case <-client.ctx.Done():
+				break collect
+			}
+		}
+		if !ok {
+			closed = true
+			break
+		}
+		s.accountDequeue(client, len(message.Payload))
+		if s.expired(message) {
+			continue
+		}
+		if !batchable(message) {
+			next = &message
+			break
+		}
+		batch = append(batch, message)
+	}
+
+	if err := s.writeFrame(client, joinBatch(batch)); err != nil {
+		return false, err
+	}
+	if next != nil {
+		if err := s.writeFrame(client, *next); err != nil {
+			return false, err
+		}
+	}
+	return closed, nil
+}
+
+// 把多条频道消息拼接为一个 JSON 数组帧；只有一条时原样返回。
+// 全部来自同一频道时保留频道，以便按频道的压缩设置生效
+func joinBatch(batch []OutboundMessage) OutboundMessage {
+	if len(batch) == 1 {
+		return batch[0]
+	}
+
+	size := len(batch) + 1
+	for _, message := range batch {
+		size += len(message.Payload)
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, '[')
+	for i, message := range batch {
+		if i > 0 {
+			payload = append(payload, ',')
+		}
+		payload = append(payload, message.Payload...)
+	}
+	payload = append(payload, ']')
+
+	frame := OutboundMessage{Type: websocket.TextMessage, Payload: payload, Channel: batch[0].Channel}
+	for _, message := range batch[1:] {
+		if message.Channel != frame.Channel {
+			frame.Channel = ""
+			break
+		}
+	}
+	return frame
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBatchingJoinsQueuedMessages(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.BatchSize = 8
+	})
+	batched := Dial(t, ts, "")
+	batched.Subscribe("ticks")
+	batched.Send(Message{Action: "set_batching", Data: true})
+	if resp := batched.Expect("set_batching"); resp.Code != CodeSuccess {
+		t.Fatalf("set_batching: code = %d (%s)", resp.Code, resp.Msg)
+	}
+	plain := Dial(t, ts, "")
+	plain.Subscribe("ticks")
+
+	// 暂停期间积压 5 条，恢复后批量客户端收到一个数组帧
+	client := serverClient(s, batched.ID)
+	client.Pause()
+	for i := 0; i < 5; i++ {
+		s.BroadcastToChannelSync("ticks", i)
+	}
+	client.Resume()
+
+	_, payload, err := batched.NextFrame(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame []Response
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		t.Fatalf("应为 JSON 数组: %s", payload)
+	}
+	if len(frame) != 5 {
+		t.Fatalf("数组帧含 %d 条, want 5", len(frame))
+	}
+	for i, msg := range frame {
+		if fmt.Sprint(msg.Data) != fmt.Sprint(i) || msg.Channel != "ticks" {
+			t.Fatalf("第 %d 条 = %+v", i, msg)
+		}
+	}
+
+	// 未开启批量模式的客户端逐条收到
+	for i := 0; i < 5; i++ {
+		if msg := plain.Expect("message"); fmt.Sprint(msg.Data) != fmt.Sprint(i) {
+			t.Fatalf("普通客户端第 %d 条 = %v", i, msg.Data)
+		}
+	}
+}
+
+func TestSetBatchingRequiresBatchSize(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+	c.Send(Message{Action: "set_batching", Data: true})
+	if resp := c.Expect("set_batching"); resp.Code != CodeForbidden {
+		t.Fatalf("code = %d, want %d", resp.Code, CodeForbidden)
+	}
+	c.Send(Message{Action: "set_batching", Data: "yes"})
+	if resp := c.Expect("set_batching"); resp.Code != CodeBadRequest {
+		t.Fatalf("code = %d, want %d", resp.Code, CodeBadRequest)
+	}
+}
+
+// 向繁忙频道连续广播，报告订阅者平均每条消息收到的帧数（frames/msg）
+func BenchmarkBatchingFrames(b *testing.B) {
+	for _, batching := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batching=%v", batching), func(b *testing.B) {
+			s, ts := newTestServer(b, DefaultServerConfig(), func(s *Server) {
+				s.BatchSize = 50
+				s.FlushInterval = 5 * time.Millisecond
+				// 不因缓冲区满断开订阅者，让发布者等待
+				s.SlowClientPolicy = SlowClientBlock
+				s.SlowClientTimeout = time.Minute
+			})
+			conn, _, err := dialRaw(ts, "channels=ticks", nil, websocket.DefaultDialer)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			if batching {
+				conn.WriteJSON(Message{Action: "set_batching", Data: true})
+			}
+			waitFor(b, "subscribed", func() bool { return s.subscriptions.count("ticks") == 1 })
+
+			frames, messages := 0, 0
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for messages < b.N {
+					_, payload, err := conn.ReadMessage()
+					if err != nil {
+						return
+					}
+					if bytes.HasPrefix(payload, []byte("[")) {
+						frames++
+						messages += bytes.Count(payload, []byte(`"action":"message"`))
+					} else if bytes.Contains(payload, []byte(`"action":"message"`)) {
+						frames++
+						messages++
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.BroadcastToChannel("ticks", i)
+			}
+			<-done
+			b.StopTimer()
+			b.ReportMetric(float64(frames)/float64(b.N), "frames/msg")
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// /broadcast 请求体的默认上限
+const defaultMaxBroadcastBodySize = 1 << 20
+
+// 同步广播的投递结果
+type BroadcastResult struct {
+	Channel       string `json:"channel"`
+	CorrelationID string `json:"correlationId"`
+	Delivered     int    `json:"delivered"`   // 本实例上放入发送队列的订阅者数
+	Skipped       int    `json:"skipped"`     // 缓冲区满、降级或全局缓冲超限没有收到的订阅者数
+	Subscribers   int    `json:"subscribers"` // 频道当前的（精确）订阅者数
+}
+
+// HTTP 广播接口：POST {"channel": ..., "data": ..., "correlationId": ...}。
+// 默认放入广播队列后立即返回；带 ?sync=true 时等待本实例投递完成，以 JSON 返回 BroadcastResult，
+// 没有任何订阅者收到时返回 404（消息照常进入历史）
+func (s *Server) HandleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	wait := false
+	if v := r.URL.Query().Get("sync"); v != "" {
+		var err error
+		if wait, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid sync parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := s.MaxBroadcastBodySize
+	if limit <= 0 {
+		limit = defaultMaxBroadcastBodySize
+	}
+	var req struct {
+		Channel       string      `json:"channel"`
+		Data          interface{} `json:"data"`
+		CorrelationID string      `json:"correlationId"`
+	}
+	// 数字保持为 json.Number，大整数原样转发
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.UseNumber()
+	err := dec.Decode(&req)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("request body must contain a single JSON object")
+	}
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Channel == "" {
+		http.Error(w, "channel is required", http.StatusBadRequest)
+		return
+	}
+	channel, err := s.checkChannel(req.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 关联ID：优先取请求头，其次取请求体，都没有则生成一个
+	correlationID := r.Header.Get("X-Correlation-ID")
+	if correlationID == "" {
+		correlationID = req.CorrelationID
+	}
+	if correlationID == "" {
+		correlationID = uuid.New().String()
+	}
+	w.Header().Set("X-Correlation-ID", correlationID)
+
+	msg := BroadcastMsg{Channel: channel, Data: req.Data, CorrelationID: correlationID}
+	if !wait {
+		s.broadcast <- msg
+		s.publishBackplane(msg)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Broadcast sent"))
+		return
+	}
+
+	delivery := s.broadcastSync(msg)
+	result := BroadcastResult{
+		Channel:       channel,
+		CorrelationID: correlationID,
+		Delivered:     delivery.delivered,
+		Skipped:       delivery.skipped,
+		Subscribers:   s.ChannelCount(channel),
+	}
+	status := http.StatusOK
+	// 通配订阅者不计入 Subscribers，但收到了消息就不算没有订阅者
+	if result.Subscribers == 0 && result.Delivered == 0 && result.Skipped == 0 {
+		status = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
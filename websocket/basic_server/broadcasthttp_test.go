@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTTPBroadcastSyncReportsCounts(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxBroadcastBodySize = 256
+	})
+	a := Dial(t, ts, "channels=room")
+	a.Expect("subscribe")
+	b := Dial(t, ts, "channels=room")
+	b.Expect("subscribe")
+
+	post := func(query, body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/broadcast"+query, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post("?sync=true", `{"channel":"room","data":"hi","correlationId":"c1"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("状态码 %d, want 200", resp.StatusCode)
+	}
+	var result BroadcastResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	want := BroadcastResult{Channel: "room", CorrelationID: "c1", Delivered: 2, Skipped: 0, Subscribers: 2}
+	if result != want {
+		t.Fatalf("结果 %+v, want %+v", result, want)
+	}
+	for _, c := range []*TestClient{a, b} {
+		if msg := c.Expect("message"); msg.Data != "hi" {
+			t.Fatalf("收到 %+v", msg)
+		}
+	}
+
+	cases := []struct {
+		name, query, body string
+		status            int
+	}{
+		{"no subscribers", "?sync=true", `{"channel":"empty","data":1}`, http.StatusNotFound},
+		{"async", "", `{"channel":"empty","data":1}`, http.StatusOK},
+		{"missing channel", "?sync=true", `{"data":1}`, http.StatusBadRequest},
+		{"trailing data", "?sync=true", `{"channel":"room"}{}`, http.StatusBadRequest},
+		{"bad sync", "?sync=maybe", `{"channel":"room"}`, http.StatusBadRequest},
+		{"too large", "?sync=true", `{"channel":"room","data":"` + strings.Repeat("x", 300) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		if resp := post(tc.query, tc.body); resp.StatusCode != tc.status {
+			t.Errorf("%s: 状态码 %d, want %d", tc.name, resp.StatusCode, tc.status)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import "time"
+
+// 默认的频道回收扫描间隔
+const defaultChannelSweepInterval = time.Minute
+
+func (s *Server) channelSweepInterval() time.Duration {
+	if s.ChannelSweepInterval > 0 {
+		return s.ChannelSweepInterval
+	}
+	return defaultChannelSweepInterval
+}
+
+// 有服务端状态（序号、历史或消息计数）的频道，包括已经没有订阅者的
+func (s *Server) trackedChannels() []string {
+	seen := make(map[string]bool)
+	s.historyMu.Lock()
+	for channel := range s.channelSeq {
+		seen[channel] = true
+	}
+	for channel := range s.history {
+		seen[channel] = true
+	}
+	s.historyMu.Unlock()
+	for _, channel := range s.channelCounters.channels() {
+		seen[channel] = true
+	}
+
+	channels := make([]string, 0, len(seen))
+	for channel := range seen {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// 回收超过 ChannelTTL 没有活动的频道（只在事件循环中调用，channelBuckets 只在这里访问）
+func (s *Server) sweepChannels() {
+	cutoff := time.Now().Add(-s.ChannelTTL).UnixNano()
+	swept := 0
+	for _, channel := range s.trackedChannels() {
+		if s.channelCounters.lastActive(channel) > cutoff {
+			continue
+		}
+		if s.reclaimChannel(channel) {
+			swept++
+		}
+	}
+	if swept > 0 {
+		s.Metrics.ChannelsSwept.Add(int64(swept))
+		s.Logger.Debug("回收空闲频道", "event", "channel_sweep", "channels", swept)
+	}
+}
+
+// 回收频道的序号、历史、消息计数和限流状态，返回是否回收了任何状态。
+// 仍有订阅者、开启了保留消息或有暂存消息的频道不回收；单独设置了历史条数的频道保留历史和序号
+func (s *Server) reclaimChannel(channel string) bool {
+	// 开启投递 worker 时与该频道的投递互斥，序号不会在分配之后、写入历史之前被删除
+	defer s.lockChannelFanout(channel)()
+	// 在分片写锁内检查并删除，与订阅互斥
+	sh := s.subscriptions.shard(channel)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if len(sh.subs[channel]) > 0 || sh.retain[channel] || len(sh.pending[channel]) > 0 {
+		return false
+	}
+
+	reclaimed := false
+	s.historyMu.Lock()
+	if _, sized := s.channelHistorySizes[channel]; !sized {
+		_, hasHistory := s.history[channel]
+		_, hasSeq := s.channelSeq[channel]
+		reclaimed = hasHistory || hasSeq
+		delete(s.history, channel)
+		delete(s.channelSeq, channel)
+	}
+	s.historyMu.Unlock()
+	if s.channelCounters.remove(channel) {
+		reclaimed = true
+	}
+	delete(s.channelBuckets, channel)
+	return reclaimed
+}
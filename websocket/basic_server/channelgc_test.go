@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdleChannelsReclaimed(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.HistorySize = 10
+		s.ChannelTTL = 100 * time.Millisecond
+		s.ChannelSweepInterval = 20 * time.Millisecond
+	})
+	tracked := func(channel string) bool {
+		for _, c := range s.trackedChannels() {
+			if c == channel {
+				return true
+			}
+		}
+		return false
+	}
+
+	leaver := Dial(t, ts, "channels=temp")
+	leaver.Expect("subscribe")
+	stayer := Dial(t, ts, "channels=kept")
+	stayer.Expect("subscribe")
+	for i := 0; i < 3; i++ {
+		s.BroadcastToChannelSync("temp", i)
+		s.BroadcastToChannelSync("kept", i)
+	}
+	if !tracked("temp") || !tracked("kept") {
+		t.Fatal("广播后两个频道都应有状态")
+	}
+
+	// 最后一个订阅者离开并超过 ChannelTTL 后回收；仍有订阅者的频道保留
+	leaver.Conn.Close()
+	waitFor(t, "idle channel reclaimed", func() bool { return !tracked("temp") })
+	if n := s.Metrics.ChannelsSwept.Load(); n != 1 {
+		t.Fatalf("ChannelsSwept = %d, want 1", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !tracked("kept") {
+		t.Fatal("有订阅者的频道不应被回收")
+	}
+
+	var metrics bytes.Buffer
+	s.WriteMetrics(&metrics)
+	for _, want := range []string{"websocket_channels 1\n", "websocket_channels_swept_total 1\n"} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Fatalf("指标中缺少 %q", want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 消息速率的统计窗口（秒）
+const rateWindowSeconds = 60
+
+// 单个频道的消息计数
+type channelCounter struct {
+	last    int64 // 最后一次广播或最后一个订阅者离开的时间（UnixNano），用于回收空闲频道
+	total   int64
+	buckets [rateWindowSeconds]int64 // 最近每一秒的消息数
+	stamps  [rateWindowSeconds]int64 // 每个桶对应的 Unix 秒
+}
+
+func (c *channelCounter) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % rateWindowSeconds
+	if c.stamps[i] != sec {
+		c.stamps[i] = sec
+		c.buckets[i] = 0
+	}
+	c.buckets[i]++
+	c.total++
+	c.last = now.UnixNano()
+}
+
+// 最近一个窗口内的平均每秒消息数
+func (c *channelCounter) rate(now time.Time) float64 {
+	cutoff := now.Unix() - rateWindowSeconds
+	var sum int64
+	for i, stamp := range c.stamps {
+		if stamp > cutoff {
+			sum += c.buckets[i]
+		}
+	}
+	return float64(sum) / rateWindowSeconds
+}
+
+// 按频道的消息统计
+type channelCounters struct {
+	mu       sync.Mutex
+	counters map[string]*channelCounter
+}
+
+func (c *channelCounters) record(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.counters[channel]
+	if !ok {
+		counter = &channelCounter{}
+		c.counters[channel] = counter
+	}
+	counter.add(time.Now())
+}
+
+// 频道的最后一个订阅者离开时记为一次活动，只更新已有的计数，不为从未广播过的频道新建
+func (c *channelCounters) touch(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok := c.counters[channel]; ok {
+		counter.last = time.Now().UnixNano()
+	}
+}
+
+// 频道最后一次活动的时间（UnixNano），没有计数时为 0
+func (c *channelCounters) lastActive(channel string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok := c.counters[channel]; ok {
+		return counter.last
+	}
+	return 0
+}
+
+// 删除频道的计数，返回之前是否存在
+func (c *channelCounters) remove(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.counters[channel]
+	delete(c.counters, channel)
+	return ok
+}
+
+// 有计数的频道
+func (c *channelCounters) channels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := make([]string, 0, len(c.counters))
+	for channel := range c.counters {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// 返回频道的消息总数和最近一分钟的速率
+func (c *channelCounters) get(channel string) (total int64, rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.counters[channel]
+	if !ok {
+		return 0, 0
+	}
+	return counter.total, counter.rate(time.Now())
+}
+
+// 客户端可见的频道统计
+type ChannelStats struct {
+	Subscribers int     `json:"subscribers"`
+	Messages    int64   `json:"messages"`    // 累计广播消息数
+	MessageRate float64 `json:"messageRate"` // 最近一分钟平均每秒消息数
+	QueueDepth  int     `json:"queueDepth"`  // 请求者自己的发送队列深度
+}
+
+// 处理频道统计查询，只有该频道的订阅者可以查询
+func (s *Server) handleChannelStats(client *Client, channel, requestID string) {
+	subscribed := client.subscribed(channel)
+	subscribers := s.subscriptions.count(channel)
+
+	if !subscribed {
+		response := errorResponse(client, "channel_stats", CodeNotSubscribed, "not subscribed")
+		response.RequestID = requestID
+		response.Channel = channel
+		s.sendResponse(client, response)
+		return
+	}
+
+	total, rate := s.channelCounters.get(channel)
+	s.sendResponse(client, Response{
+		ClientID:  client.ID,
+		RequestID: requestID,
+		Action:    "channel_stats",
+		Channel:   channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data: ChannelStats{
+			Subscribers: subscribers,
+			Messages:    total,
+			MessageRate: rate,
+			QueueDepth:  client.QueueLen(),
+		},
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 服务器统一的编解码器：响应和广播用它序列化，入站消息用它解码（按子协议注册了解码器的连接除外）
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	MessageType() int // 下发帧的类型：websocket.TextMessage 或 websocket.BinaryMessage
+}
+
+// JSON 编解码（默认），使用文本帧
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return decodeJSON(data, v) }
+
+// 与 json.Unmarshal 相同，但数字解码为 json.Number 而不是 float64：
+// 超过 2^53 的整数ID不会丢失精度，转发时也按原样写出（1 不会变成 1.0 或科学计数法）
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// json.Unmarshal 不允许值后面还有其它内容，这里保持一致
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+func (JSONCodec) MessageType() int { return websocket.TextMessage }
+
+// MessagePack 编解码，使用二进制帧。字段名沿用 json 标签，与 JSON 格式的字段一一对应
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 整数按 int64/uint64、浮点数按 float64 解码到 interface{}，便于与 JSON 解码的 Data 一样处理
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
+
+func (MsgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+// 帧类型与编解码器不匹配：文本编解码器收到二进制帧且没有设置 OnBinaryMessage，或二进制编解码器收到文本帧
+type frameTypeError struct {
+	got, want int
+}
+
+func (e frameTypeError) Error() string {
+	return frameTypeName(e.got) + " frames are not supported, expected " + frameTypeName(e.want)
+}
+
+func frameTypeName(messageType int) string {
+	if messageType == websocket.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
+
+// JSON 解码得到的 json.Number（例如 /broadcast 或总线转发的数据）按数值写出，而不是字符串
+func init() {
+	msgpack.Register(json.Number(""), func(enc *msgpack.Encoder, v reflect.Value) error {
+		number := json.Number(v.String())
+		if i, err := number.Int64(); err == nil {
+			return enc.EncodeInt(i)
+		}
+		if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+			return enc.EncodeUint(u)
+		}
+		f, err := number.Float64()
+		if err != nil {
+			return err
+		}
+		return enc.EncodeFloat64(f)
+	}, nil)
+}
+
+// 是否是 JSON 编解码器（Server.Codec 设为 JSONCodec{} 或 &JSONCodec{} 都算）
+func isJSONCodec(codec Codec) bool {
+	switch codec.(type) {
+	case JSONCodec, *JSONCodec:
+		return true
+	}
+	return false
+}
+
+// 当前的编解码器，未设置 Server.Codec 时为 JSON
+func (s *Server) codec() Codec {
+	if s.Codec != nil {
+		return s.Codec
+	}
+	return JSONCodec{}
+}
+
+// 把序列化后的数据包装为一帧，帧类型由编解码器决定
+func (s *Server) frame(data []byte) OutboundMessage {
+	return OutboundMessage{Type: s.codec().MessageType(), Payload: data}
+}
+
+// 入站消息解码器：把一帧数据解码为 Message
+type MessageDecoder func(data []byte, msg *Message) error
+
+// 为子协议注册解码器（例如 "msgpack.v1"）。协商到该子协议的连接，文本帧和二进制帧都用它解码；
+// 没有注册解码器的连接按 Server.Codec 解码。只影响入站消息，下发的消息仍由 Server.Codec 序列化。应在启动前调用
+func (s *Server) RegisterDecoder(subprotocol string, decode MessageDecoder) {
+	s.decoders[subprotocol] = decode
+}
+
+// 按连接协商的子协议解码一帧；handled 为 false 表示这一帧已交给 OnBinaryMessage。
+// 帧类型与编解码器不匹配时返回 frameTypeError
+func (s *Server) decodeFrame(client *Client, messageType int, data []byte, msg *Message) (handled bool, err error) {
+	if decode, ok := s.decoders[client.Subprotocol]; ok {
+		return true, decode(data, msg)
+	}
+
+	// 默认按编解码器解析；文本编解码器下二进制帧交给应用处理
+	codec := s.codec()
+	if messageType != codec.MessageType() {
+		if messageType == websocket.BinaryMessage && s.OnBinaryMessage != nil {
+			s.OnBinaryMessage(client, data)
+			return false, nil
+		}
+		return true, frameTypeError{got: messageType, want: codec.MessageType()}
+	}
+	return true, codec.Unmarshal(data, msg)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIsJSONCodec(t *testing.T) {
+	tests := []struct {
+		codec Codec
+		want  bool
+	}{
+		{JSONCodec{}, true},
+		{&JSONCodec{}, true},
+		{MsgpackCodec{}, false},
+		{&MsgpackCodec{}, false},
+	}
+	for _, tt := range tests {
+		if got := isJSONCodec(tt.codec); got != tt.want {
+			t.Errorf("isJSONCodec(%T) = %v, want %v", tt.codec, got, tt.want)
+		}
+	}
+}
+
+func TestBatchingAllowedWithJSONCodecPointer(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Codec = &JSONCodec{}
+		s.BatchSize = 8
+	})
+	c := Dial(t, ts, "")
+	c.Send(Message{Action: "set_batching", Data: true})
+	if resp := c.Expect("set_batching"); resp.Code != CodeSuccess {
+		t.Fatalf("code = %d (%s), want 200", resp.Code, resp.Msg)
+	}
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Subprotocols = []string{"json.v1", "msgpack.v1"}
+	s, ts := newTestServer(t, config, func(s *Server) {
+		s.RegisterDecoder("msgpack.v1", func(data []byte, msg *Message) error {
+			return MsgpackCodec{}.Unmarshal(data, msg)
+		})
+	})
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{"cbor.v1", "msgpack.v1"}
+	conn, _, err := dialRaw(ts, "", nil, &dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := conn.Subprotocol(); p != "msgpack.v1" {
+		t.Fatalf("协商的子协议 = %q, want msgpack.v1", p)
+	}
+	c := newTestClient(t, conn)
+	c.ID = c.Expect("connect").ClientID
+	if p := serverClient(s, c.ID).Subprotocol; p != "msgpack.v1" {
+		t.Fatalf("Client.Subprotocol = %q", p)
+	}
+
+	// 入站消息按子协议注册的解码器解析
+	frame, err := MsgpackCodec{}.Marshal(Message{Action: "subscribe", Channel: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	if ack := c.Expect("subscribe"); ack.Code != CodeSuccess || ack.Channel != "room" {
+		t.Fatalf("订阅确认 %+v", ack)
+	}
+
+	// 请求的子协议都不支持时返回 400
+	dialer.Subprotocols = []string{"cbor.v1"}
+	_, resp, err := dialRaw(ts, "", nil, &dialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("不支持的子协议应返回 400，err=%v", err)
+	}
+}
+
+func TestLargeIntegersKeepPrecision(t *testing.T) {
+	ids := make(chan interface{}, 1)
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.OnMessage = func(client *Client, msg *Message) bool {
+			if msg.Action == "publish" {
+				ids <- msg.Data.(map[string]interface{})["id"]
+			}
+			return true
+		}
+	})
+	receiver := Dial(t, ts, "channels=orders")
+	receiver.Expect("subscribe")
+	sender := Dial(t, ts, "channels=orders")
+	sender.Expect("subscribe")
+
+	// 2^53+1 用 float64 表示会变成 9007199254740992
+	frame := `{"action":"publish","channel":"orders","data":{"id":9007199254740993,"big":12345678901234567890,"price":1.5}}`
+	if err := sender.Conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 处理函数拿到的是 json.Number
+	id, ok := (<-ids).(json.Number)
+	if !ok {
+		t.Fatalf("Data 中的数字类型 %T, want json.Number", id)
+	}
+	if n, err := id.Int64(); err != nil || n != 9007199254740993 {
+		t.Fatalf("id.Int64() = %d, %v", n, err)
+	}
+
+	// 重新编码后原样送达，不变成浮点数或科学计数法
+	_, payload, err := receiver.NextFrame(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"id":9007199254740993`, `"big":12345678901234567890`, `"price":1.5`} {
+		if !strings.Contains(string(payload), want) {
+			t.Fatalf("收到 %s, 缺少 %s", payload, want)
+		}
+	}
+}
+
+func TestUnsupportedFrameType(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+
+	// JSON 服务器收到二进制帧：明确的 415 错误，连接保持
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, []byte(`{"action":"ping"}`)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.NextMessage(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != CodeBadFrameType || resp.Msg != "binary frames are not supported, expected text" {
+		t.Fatalf("二进制帧的响应 %+v", resp)
+	}
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+}
+
+func TestBinaryFramesToOnBinaryMessage(t *testing.T) {
+	frames := make(chan []byte, 1)
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.OnBinaryMessage = func(client *Client, data []byte) { frames <- data }
+	})
+	c := Dial(t, ts, "")
+
+	// 设置了 OnBinaryMessage 时二进制帧交给应用，不返回错误
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-frames; string(got) != "\x01\x02\x03" {
+		t.Fatalf("OnBinaryMessage 收到 %v", got)
+	}
+	c.ExpectNone(100 * time.Millisecond)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// 服务器写出的握手响应是否接受了 permessage-deflate：只有服务器开启了压缩且客户端请求了，
+// gorilla 才会在响应中带上该扩展
+func deflateAccepted(response []byte) bool {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response)), nil)
+	if err != nil {
+		return false
+	}
+	for _, header := range resp.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name := strings.TrimSpace(strings.SplitN(ext, ";", 2)[0])
+			if strings.EqualFold(name, "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 默认的压缩阈值：小于该字节数的帧不压缩，pong 等短响应压缩后几乎不变小，反而多花 CPU
+const defaultCompressionThreshold = 256
+
+func (s *Server) compressionThreshold() int {
+	if s.CompressionThreshold > 0 {
+		return s.CompressionThreshold
+	}
+	return defaultCompressionThreshold
+}
+
+// 握手时是否协商出了 permessage-deflate
+func (c *Client) CompressionNegotiated() bool {
+	return c.compressionNegotiated
+}
+
+// 记录客户端对某个频道的压缩偏好（订阅时指定）
+func (c *Client) setCompression(channel string, compress *bool) {
+	c.compressMu.Lock()
+	defer c.compressMu.Unlock()
+
+	if compress == nil {
+		delete(c.compressPrefs, channel)
+		return
+	}
+	c.compressPrefs[channel] = *compress
+}
+
+// 该帧是否需要压缩：频道有偏好时按偏好，否则使用连接级设置。
+// 连接没有协商出压缩或帧小于 threshold 时总是 false。控制帧（ping/pong/close）不会被压缩
+func (c *Client) wantsCompression(channel string, size, threshold int) bool {
+	if !c.compressionNegotiated || size < threshold {
+		return false
+	}
+	if channel != "" {
+		c.compressMu.Lock()
+		compress, ok := c.compressPrefs[channel]
+		c.compressMu.Unlock()
+		if ok {
+			return compress
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompressionSkipsSmallFrames(t *testing.T) {
+	config := DefaultServerConfig()
+	config.CompressionEnabled = true
+	var mu sync.Mutex
+	compressed := make(map[string]bool) // action -> 是否压缩
+	s, ts := newTestServer(t, config, func(s *Server) {
+		s.OnFrameWritten = func(client *Client, info FrameInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Channel != "" {
+				compressed["message"] = info.Compressed
+			} else if info.Bytes < 256 {
+				compressed["small"] = info.Compressed
+			}
+		}
+	})
+
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	conn, resp, err := dialRaw(ts, "", nil, &dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("没有协商压缩: %q", ext)
+	}
+	c := newTestClient(t, conn)
+	c.Expect("connect")
+	c.Subscribe("feed")
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+
+	payload := strings.Repeat(`{"symbol":"ABC","price":1.0}`, 100)
+	s.BroadcastToChannel("feed", payload)
+	if msg := c.Expect("message"); msg.Data != payload {
+		t.Fatal("解压后的内容不一致")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !compressed["message"] {
+		t.Error("大消息应被压缩")
+	}
+	if v, ok := compressed["small"]; !ok || v {
+		t.Errorf("小于阈值的响应不应压缩 (seen=%v compressed=%v)", ok, v)
+	}
+}
+
+// 统计从连接上读到的字节数
+type readCountingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c readCountingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// 重复性很强的 JSON 消息在开启和关闭压缩时线路上的字节数（wire-bytes/msg）
+func BenchmarkCompressionWireBytes(b *testing.B) {
+	payload := make([]map[string]interface{}, 20)
+	for i := range payload {
+		payload[i] = map[string]interface{}{"symbol": "ABC", "bid": 101.25, "ask": 101.5, "volume": 1000 + i}
+	}
+	for _, compress := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compression=%v", compress), func(b *testing.B) {
+			config := DefaultServerConfig()
+			config.CompressionEnabled = true
+			s, ts := newTestServer(b, config, func(s *Server) {
+				s.SlowClientPolicy = SlowClientBlock
+				s.SlowClientTimeout = testTimeout
+			})
+
+			var wire atomic.Int64
+			dialer := *websocket.DefaultDialer
+			dialer.EnableCompression = compress
+			dialer.NetDial = func(network, addr string) (net.Conn, error) {
+				conn, err := net.Dial(network, addr)
+				return readCountingConn{Conn: conn, n: &wire}, err
+			}
+			conn, _, err := dialRaw(ts, "channels=ticks", nil, &dialer)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			waitFor(b, "subscribed", func() bool { return s.subscriptions.count("ticks") == 1 })
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for received := 0; received < b.N; {
+					_, data, err := conn.ReadMessage()
+					if err != nil {
+						return
+					}
+					if strings.Contains(string(data), `"action":"message"`) {
+						received++
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			start := wire.Load()
+			for i := 0; i < b.N; i++ {
+				s.BroadcastToChannel("ticks", payload)
+			}
+			<-done
+			b.StopTimer()
+			b.ReportMetric(float64(wire.Load()-start)/float64(b.N), "wire-bytes/msg")
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	config := DefaultServerConfig()
+	config.CompressionEnabled = true
+	frames := make(chan FrameInfo, 16)
+	s, ts := newTestServer(t, config, func(s *Server) {
+		s.CompressionThreshold = 1000
+		s.OnFrameWritten = func(client *Client, info FrameInfo) {
+			if info.Channel != "" {
+				frames <- info
+			}
+		}
+	})
+
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	conn, _, err := dialRaw(ts, "channels=feed", nil, &dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, conn)
+	c.Expect("subscribe")
+
+	for _, size := range []int{500, 999, 1000, 4000} {
+		s.BroadcastToChannel("feed", strings.Repeat("x", size))
+		c.Expect("message")
+		info := <-frames
+		if want := info.Bytes >= 1000; info.Compressed != want {
+			t.Errorf("%d 字节的帧 compressed = %v, want %v", info.Bytes, info.Compressed, want)
+		}
+	}
+}
+
+func TestCompressionNegotiationRecorded(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverEnabled bool
+		clientOffers  bool
+		want          bool
+	}{
+		{"both", true, true, true},
+		{"client does not offer", true, false, false},
+		{"server disabled", false, true, false},
+	}
+	for _, tt := range tests {
+		config := DefaultServerConfig()
+		config.CompressionEnabled = tt.serverEnabled
+		s, ts := newTestServer(t, config, nil)
+
+		dialer := *websocket.DefaultDialer
+		dialer.EnableCompression = tt.clientOffers
+		conn, _, err := dialRaw(ts, "", nil, &dialer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newTestClient(t, conn)
+		c.ID = c.Expect("connect").ClientID
+
+		if got := serverClient(s, c.ID).CompressionNegotiated(); got != tt.want {
+			t.Errorf("%s: CompressionNegotiated = %v, want %v", tt.name, got, tt.want)
+		}
+		if info, _ := s.ClientInfo(c.ID); info.Compression != tt.want {
+			t.Errorf("%s: ClientInfo.Compression = %v, want %v", tt.name, info.Compression, tt.want)
+		}
+		c.Send(Message{Action: "whoami"})
+		if data, _ := c.Expect("whoami").Data.(map[string]interface{}); data["compression"] != tt.want {
+			t.Errorf("%s: whoami compression = %v, want %v", tt.name, data["compression"], tt.want)
+		}
+	}
+}
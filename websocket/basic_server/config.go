@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 服务器配置
+type ServerConfig struct {
+	// 允许升级的 Origin，按 scheme://host[:port] 匹配；"*" 允许任意来源，仅用于开发。
+	// 主机可以用 "*." 前缀匹配所有子域名（如 "https://*.example.com"，不含 example.com 本身），
+	// 省略 scheme（如 "*.example.com"）时不限制 scheme。
+	// 为空时只允许同源。没有 Origin 头的请求（非浏览器客户端）总是允许
+	AllowedOrigins []string
+
+	// 严格模式：TLS 连接（wss）只接受 https 的 Origin，即使它匹配了 AllowedOrigins
+	StrictOriginScheme bool
+
+	// 自定义来源检查（可选），参数为解析后的 Origin。设置后代替 AllowedOrigins，
+	// 无法解析的 Origin 直接拒绝，StrictOriginScheme 仍然生效
+	OriginChecker func(origin *url.URL) bool
+
+	// 读写缓冲区大小（字节），0 使用 gorilla/websocket 的默认值
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// 按连接协商缓冲区大小的范围（字节）。MaxBufferSize 大于 0 时，客户端可以在握手请求中用
+	// X-WS-Buffer 头（"8192" 或 "读,写" 如 "4096,65536"）请求该连接的缓冲区大小；
+	// 没有该头、格式不对或超出 [MinBufferSize, MaxBufferSize] 时使用 ReadBufferSize/WriteBufferSize
+	MinBufferSize int
+	MaxBufferSize int
+
+	// 支持的子协议（按优先级），如 "json.v1"、"msgpack.v1"，写入 Server.Subprotocols。
+	// 配合 Server.RegisterDecoder 决定该连接入站消息的解码方式
+	Subprotocols []string
+
+	// 是否协商 permessage-deflate；只有客户端也请求了压缩的连接才会压缩
+	CompressionEnabled bool
+	// 写压缩级别（flate.BestSpeed 到 flate.BestCompression），0 使用默认级别
+	WriteCompressionLevel int
+
+	// 握手超时：写出 101 响应的期限，0 表示不限制。请求头的读取由 net/http 完成，
+	// 需要同时设置 http.Server.ReadHeaderTimeout（可直接使用这个值）才能防止握手请求迟迟不发完
+	HandshakeTimeout time.Duration
+}
+
+// 默认的握手超时
+const defaultHandshakeTimeout = 10 * time.Second
+
+// 默认配置：只允许同源，读写缓冲区各 1KB，握手超时 10 秒
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadBufferSize:   1024,
+		WriteBufferSize:  1024,
+		HandshakeTimeout: defaultHandshakeTimeout,
+	}
+}
+
+// 根据配置构造升级器
+func newUpgrader(config ServerConfig) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  config.ReadBufferSize,
+		WriteBufferSize: config.WriteBufferSize,
+		CheckOrigin:     originChecker(config),
+
+		HandshakeTimeout: config.HandshakeTimeout,
+
+		EnableCompression: config.CompressionEnabled,
+	}
+}
+
+// 客户端请求缓冲区大小的握手头
+const bufferHeader = "X-WS-Buffer"
+
+// 该连接使用的升级器：请求了范围内的缓冲区大小时返回调整过大小的副本，否则返回默认升级器
+func (s *Server) upgraderFor(r *http.Request) websocket.Upgrader {
+	upgrader := s.upgrader
+	if s.maxBufferSize <= 0 {
+		return upgrader
+	}
+	read, write, ok := parseBufferSizes(r.Header.Get(bufferHeader))
+	if !ok || !s.bufferSizeAllowed(read) || !s.bufferSizeAllowed(write) {
+		return upgrader
+	}
+	upgrader.ReadBufferSize, upgrader.WriteBufferSize = read, write
+	return upgrader
+}
+
+func (s *Server) bufferSizeAllowed(size int) bool {
+	return size >= s.minBufferSize && size <= s.maxBufferSize
+}
+
+// 解析 "8192"（读写相同）或 "4096,65536"（读,写）
+func parseBufferSizes(value string) (read, write int, ok bool) {
+	if value == "" {
+		return 0, 0, false
+	}
+	readValue, writeValue, found := strings.Cut(value, ",")
+	if !found {
+		writeValue = readValue
+	}
+	read, err := strconv.Atoi(strings.TrimSpace(readValue))
+	if err != nil {
+		return 0, 0, false
+	}
+	write, err = strconv.Atoi(strings.TrimSpace(writeValue))
+	if err != nil {
+		return 0, 0, false
+	}
+	return read, write, true
+}
+
+// 一条来源规则
+type originPattern struct {
+	scheme     string // 为空时不限制 scheme
+	host       string // host[:port]，子域名规则不含 "*." 前缀
+	subdomains bool   // 匹配 host 的所有子域名
+}
+
+func parseOriginPattern(pattern string) originPattern {
+	var p originPattern
+	pattern = strings.ToLower(pattern)
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		p.scheme, pattern = scheme, rest
+	}
+	if host, ok := strings.CutPrefix(pattern, "*."); ok {
+		p.host, p.subdomains = host, true
+	} else {
+		p.host = pattern
+	}
+	return p
+}
+
+func (p originPattern) match(origin *url.URL) bool {
+	if p.scheme != "" && !strings.EqualFold(origin.Scheme, p.scheme) {
+		return false
+	}
+	host := strings.ToLower(origin.Host)
+	if p.subdomains {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// 根据配置生成 Origin 检查函数：OriginChecker 优先，其次按 AllowedOrigins 的规则匹配
+func originChecker(config ServerConfig) func(r *http.Request) bool {
+	var patterns []originPattern
+	wildcard := false
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			wildcard = true
+			continue
+		}
+		patterns = append(patterns, parseOriginPattern(origin))
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		if config.StrictOriginScheme && secureRequest(r) && !strings.EqualFold(u.Scheme, "https") {
+			return false
+		}
+
+		switch {
+		case config.OriginChecker != nil:
+			return config.OriginChecker(u)
+		case wildcard:
+			return true
+		case len(patterns) == 0:
+			return strings.EqualFold(u.Host, r.Host)
+		}
+		for _, p := range patterns {
+			if p.match(u) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// 请求是否经由 TLS 到达（直接 TLS，或反向代理标注了 X-Forwarded-Proto: https）
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginChecker(t *testing.T) {
+	patterns := ServerConfig{AllowedOrigins: []string{"https://*.example.com", "app.test"}}
+	strict := patterns
+	strict.StrictOriginScheme = true
+	custom := ServerConfig{OriginChecker: func(origin *url.URL) bool { return origin.Hostname() == "custom.test" }}
+
+	tests := []struct {
+		name   string
+		config ServerConfig
+		origin string
+		tls    bool // 请求经由 wss 到达
+		want   bool
+	}{
+		{"subdomain", patterns, "https://a.example.com", false, true},
+		{"nested subdomain", patterns, "https://a.b.example.com", false, true},
+		{"apex not a subdomain", patterns, "https://example.com", false, false},
+		{"suffix is not a subdomain", patterns, "https://badexample.com", false, false},
+		{"scheme mismatch", patterns, "http://a.example.com", false, false},
+		{"any scheme", patterns, "http://app.test", false, true},
+		{"missing origin", patterns, "", false, true},
+		{"unparsable origin", patterns, "://", false, false},
+		{"same origin by default", DefaultServerConfig(), "http://example.org", false, true},
+		{"cross origin by default", DefaultServerConfig(), "http://other.org", false, false},
+		{"strict rejects http on wss", strict, "http://app.test", true, false},
+		{"strict allows https on wss", strict, "https://app.test", true, true},
+		{"strict ignores plain ws", strict, "http://app.test", false, true},
+		{"custom checker", custom, "https://custom.test", false, true},
+		{"custom checker replaces patterns", custom, "https://a.example.com", false, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://example.org/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.tls {
+			r.Header.Set("X-Forwarded-Proto", "https")
+		}
+		if got := originChecker(tt.config)(r); got != tt.want {
+			t.Errorf("%s: origin %q allowed = %v, want %v", tt.name, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestHandshakeRejectsDisallowedOrigin(t *testing.T) {
+	config := DefaultServerConfig()
+	config.AllowedOrigins = []string{"https://*.example.com"}
+	_, ts := newTestServer(t, config, nil)
+
+	_, resp, err := dialRaw(ts, "", http.Header{"Origin": {"https://evil.test"}}, websocket.DefaultDialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("不允许的来源应返回 403, got %v", err)
+	}
+	c := DialHeader(t, ts, "", http.Header{"Origin": {"https://a.example.com"}})
+	c.Expect("connect")
+}
+
+func TestBufferSizeNegotiation(t *testing.T) {
+	config := DefaultServerConfig()
+	config.MinBufferSize = 512
+	config.MaxBufferSize = 64 * 1024
+	s, ts := newTestServer(t, config, nil)
+
+	tests := []struct {
+		header      string
+		read, write int
+	}{
+		{"", 1024, 1024},
+		{"8192", 8192, 8192},
+		{"4096, 65536", 4096, 65536},
+		{"256", 1024, 1024},          // 低于下限
+		{"1048576", 1024, 1024},      // 超过上限
+		{"4096,1048576", 1024, 1024}, // 任一方向超出范围都使用默认值
+		{"big", 1024, 1024},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.header != "" {
+			header.Set(bufferHeader, tt.header)
+		}
+		c := DialHeader(t, ts, "", header)
+		c.ID = c.Expect("connect").ClientID
+		read, write := serverClient(s, c.ID).BufferSizes()
+		if read != tt.read || write != tt.write {
+			t.Errorf("%s %q: 缓冲区 (%d, %d), want (%d, %d)", bufferHeader, tt.header, read, write, tt.read, tt.write)
+		}
+	}
+
+	// 协商出的小缓冲区不限制消息大小，大消息照常收发
+	a := DialHeader(t, ts, "", http.Header{bufferHeader: {"512"}})
+	a.Expect("connect")
+	a.Subscribe("room")
+	b := Dial(t, ts, "")
+	b.Subscribe("room")
+	payload := strings.Repeat("x", 16*1024)
+	if resp := b.Publish("room", payload); resp.Code != CodeSuccess {
+		t.Fatalf("发布失败: %d %s", resp.Code, resp.Msg)
+	}
+	if msg := a.Expect("message"); msg.Data != payload {
+		t.Fatal("大消息内容不一致")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	if got := DefaultServerConfig().HandshakeTimeout; got != defaultHandshakeTimeout {
+		t.Fatalf("默认握手超时 %v, want %v", got, defaultHandshakeTimeout)
+	}
+
+	config := DefaultServerConfig()
+	config.HandshakeTimeout = 200 * time.Millisecond
+	config.MaxBufferSize = 64 * 1024
+	s := NewServer(config)
+	if got := s.upgrader.HandshakeTimeout; got != config.HandshakeTimeout {
+		t.Fatalf("升级器的握手超时 %v, want %v", got, config.HandshakeTimeout)
+	}
+	// 协商了缓冲区大小的连接使用升级器的副本，超时同样生效
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set(bufferHeader, "8192")
+	if got := s.upgraderFor(r).HandshakeTimeout; got != config.HandshakeTimeout {
+		t.Fatalf("协商缓冲区后的握手超时 %v, want %v", got, config.HandshakeTimeout)
+	}
+}
+
+func TestStalledHandshakeIsClosed(t *testing.T) {
+	config := DefaultServerConfig()
+	config.HandshakeTimeout = 200 * time.Millisecond
+	s, _ := newTestServer(t, config, nil)
+	// 与 main 一样把握手超时用作 ReadHeaderTimeout，请求头迟迟不发完的连接被关闭
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.Config.ReadHeaderTimeout = config.HandshakeTimeout
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: example\r\nUpgrade: websocket\r\n")
+
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	start := time.Now()
+	io.Copy(io.Discard, conn)
+	if elapsed := time.Since(start); elapsed >= testTimeout {
+		t.Fatal("没有发完请求头的连接没有被关闭")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+// 控制频道：有权限的客户端订阅后，向它发布命令即可在同一个 WebSocket 连接上完成管理操作。
+// 默认的频道名校验不允许 "$"，普通客户端无法订阅或发布到同名的业务频道
+const ControlChannel = "$control"
+
+// 控制频道上由命令分发处理的 action
+var controlActions = map[string]bool{"subscribe": true, "unsubscribe": true, "publish": true}
+
+// 控制命令，作为发布到控制频道的消息的 data
+type controlCommand struct {
+	Command  string      `json:"command"`  // list_clients / stats / kick / announce
+	ClientID string      `json:"clientId"` // kick 的目标
+	Reason   string      `json:"reason"`   // kick 的原因，默认 "kicked"
+	Data     interface{} `json:"data"`     // announce 的内容
+}
+
+// 处理控制频道上的 subscribe / unsubscribe / publish。
+// 订阅和每条命令都经过 ControlAuthorizer，未设置时控制频道关闭
+func (s *Server) handleControl(client *Client, msg *Message) {
+	if s.ControlAuthorizer == nil || !s.ControlAuthorizer(client) {
+		s.Logger.Warn("拒绝控制频道操作", "event", "control_rejected", "client_id", client.ID, "action", msg.Action)
+		response := errorResponse(client, msg.Action, CodeForbidden, "control channel not allowed")
+		response.RequestID = msg.RequestID
+		response.Channel = ControlChannel
+		s.sendResponse(client, response)
+		return
+	}
+
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: msg.RequestID,
+		Action:    msg.Action,
+		Channel:   ControlChannel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+	switch msg.Action {
+	case "subscribe":
+		client.control.Store(true)
+	case "unsubscribe":
+		client.control.Store(false)
+	default:
+		if !client.control.Load() {
+			response = errorResponse(client, msg.Action, CodeNotSubscribed, "not subscribed")
+			response.RequestID = msg.RequestID
+			response.Channel = ControlChannel
+			break
+		}
+		response = s.runControlCommand(client, msg)
+	}
+	s.sendResponse(client, response)
+}
+
+// 执行一条控制命令，结果以 action 为 "control" 的 Response 返回
+func (s *Server) runControlCommand(client *Client, msg *Message) Response {
+	cmd, err := DecodeData[controlCommand](msg)
+	if err != nil {
+		return controlError(client, msg, CodeBadRequest, err.Error())
+	}
+	s.Logger.Info("执行控制命令", "event", "control_command", "client_id", client.ID, "command", cmd.Command)
+
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: msg.RequestID,
+		Action:    "control",
+		Channel:   ControlChannel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+	switch cmd.Command {
+	case "list_clients":
+		response.Data = s.snapshotState().Clients
+	case "stats":
+		response.Data = s.Stats()
+	case "kick":
+		if cmd.ClientID == "" {
+			return controlError(client, msg, CodeBadRequest, "clientId is required")
+		}
+		if cmd.Reason == "" {
+			cmd.Reason = "kicked"
+		}
+		if err := s.Disconnect(cmd.ClientID, cmd.Reason); err != nil {
+			return controlError(client, msg, CodeNotFound, err.Error())
+		}
+		response.Data = map[string]string{"clientId": cmd.ClientID}
+	case "announce":
+		if cmd.Data == nil {
+			return controlError(client, msg, CodeBadRequest, "data is required")
+		}
+		s.BroadcastToAll(cmd.Data)
+	default:
+		return controlError(client, msg, CodeBadRequest, "unknown command: "+cmd.Command)
+	}
+	return response
+}
+
+func controlError(client *Client, msg *Message, code int, text string) Response {
+	response := errorResponse(client, "control", code, text)
+	response.RequestID = msg.RequestID
+	response.Channel = ControlChannel
+	return response
+}
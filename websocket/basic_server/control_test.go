@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func controlServer(t *testing.T) (*Server, *TestClient, *TestClient) {
+	t.Helper()
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+		s.ControlAuthorizer = func(client *Client) bool { return client.UserID == "admin" }
+	})
+	return s, Dial(t, ts, "user=admin"), Dial(t, ts, "user=bob")
+}
+
+func TestControlKick(t *testing.T) {
+	s, admin, bob := controlServer(t)
+
+	// 没有订阅控制频道时不能发命令
+	admin.Send(Message{Action: "publish", Channel: ControlChannel, Data: map[string]string{"command": "stats"}})
+	if resp := admin.Expect("publish"); resp.Code != CodeNotSubscribed {
+		t.Fatalf("未订阅时的命令响应 %+v", resp)
+	}
+
+	admin.Subscribe(ControlChannel)
+	admin.Send(Message{Action: "publish", Channel: ControlChannel, RequestID: "k1",
+		Data: map[string]string{"command": "kick", "clientId": bob.ID, "reason": "spam"}})
+	resp := admin.Expect("control")
+	if resp.Code != CodeSuccess || resp.RequestID != "k1" || resp.Channel != ControlChannel {
+		t.Fatalf("kick 的响应 %+v", resp)
+	}
+	if _, reason := bob.ExpectClosed(); reason != "spam" {
+		t.Fatalf("被踢出的原因 %q, want spam", reason)
+	}
+	waitFor(t, "kicked client removed", func() bool { return serverClient(s, bob.ID) == nil })
+
+	// 目标不存在时返回 404
+	admin.Send(Message{Action: "publish", Channel: ControlChannel, Data: map[string]string{"command": "kick", "clientId": bob.ID}})
+	if resp := admin.Expect("control"); resp.Code != CodeNotFound {
+		t.Fatalf("踢出不存在的客户端 %+v", resp)
+	}
+}
+
+func TestControlRejectsUnauthorized(t *testing.T) {
+	s, admin, bob := controlServer(t)
+
+	for _, action := range []string{"subscribe", "publish"} {
+		bob.Send(Message{Action: action, Channel: ControlChannel, Data: map[string]string{"command": "kick", "clientId": admin.ID}})
+		if resp := bob.Expect(action); resp.Code != CodeForbidden || resp.Channel != ControlChannel {
+			t.Fatalf("没有权限的 %s 响应 %+v", action, resp)
+		}
+	}
+	if serverClient(s, admin.ID) == nil {
+		t.Fatal("没有权限的命令不应执行")
+	}
+}
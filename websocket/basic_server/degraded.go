@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端是否处于降级状态：缓冲区曾经满过，宽限期内暂停投递频道广播
+func (c *Client) Degraded() bool {
+	return c.degradedSince.Load() != 0
+}
+
+// 进入降级状态的时间，未降级时为零值
+func (c *Client) DegradedSince() time.Time {
+	since := c.degradedSince.Load()
+	if since == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, since)
+}
+
+// 广播时缓冲区已满：设置了 SlowClientGrace 时把客户端标记为降级并安排到期检查，
+// 返回 false 表示没有宽限期，调用方应立即断开。只在事件循环中调用
+func (s *Server) degrade(client *Client) bool {
+	if s.SlowClientGrace <= 0 {
+		return false
+	}
+	if !client.degradedSince.CompareAndSwap(0, time.Now().UnixNano()) {
+		return true
+	}
+
+	s.Logger.Warn("发送缓冲区已满，客户端降级", "event", "slow_client_degraded", "client_id", client.ID, "grace", s.SlowClientGrace)
+	s.Metrics.Degraded.Add(1)
+	time.AfterFunc(s.SlowClientGrace, func() { s.reapDegraded(client) })
+	return true
+}
+
+// 宽限期结束：writePump 已把队列排空到高水位以下则恢复投递，否则断开连接，由 readPump 随后注销
+func (s *Server) reapDegraded(client *Client) {
+	if client.ctx.Err() != nil {
+		return
+	}
+
+	depth := client.QueueLen()
+	if depth < highWaterMark(cap(client.Send)) {
+		client.degradedSince.Store(0)
+		s.Logger.Info("降级客户端已追上，恢复投递", "event", "slow_client_recovered", "client_id", client.ID, "depth", depth)
+		s.Metrics.Recovered.Add(1)
+		return
+	}
+
+	s.Logger.Warn("宽限期内未追上，断开连接", "event", "slow_client", "client_id", client.ID, "depth", depth)
+	s.Metrics.SlowEvictions.Add(1)
+	s.closeClient(client, websocket.CloseTryAgainLater, "slow client")
+}
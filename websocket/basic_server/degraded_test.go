@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func degradedServer(t *testing.T) (*Server, *TestClient, *Client) {
+	t.Helper()
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 4
+		s.SlowClientGrace = 300 * time.Millisecond
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	client := serverClient(s, c.ID)
+
+	// 卡住时缓冲区被填满，客户端进入降级而不是立即被断开；降级期间的广播直接跳过
+	client.Pause()
+	for i := 0; i < 8; i++ {
+		s.BroadcastToChannelSync("room", i)
+	}
+	if !client.Degraded() || s.Metrics.Degraded.Load() != 1 {
+		t.Fatalf("缓冲区满后应进入降级: degraded=%v count=%d", client.Degraded(), s.Metrics.Degraded.Load())
+	}
+	return s, c, client
+}
+
+func TestDegradedClientRecoversWithinGrace(t *testing.T) {
+	s, c, client := degradedServer(t)
+
+	// 短暂卡顿后恢复，writePump 在宽限期内排空队列
+	client.Resume()
+	waitFor(t, "degraded client recovered", func() bool { return !client.Degraded() })
+	if serverClient(s, c.ID) == nil || s.Metrics.Recovered.Load() != 1 || s.Metrics.SlowEvictions.Load() != 0 {
+		t.Fatalf("追上的客户端不应被断开: recovered=%d evictions=%d", s.Metrics.Recovered.Load(), s.Metrics.SlowEvictions.Load())
+	}
+
+	// 降级前排队的消息按顺序送达（writePump 暂停前可能已取走一条，所以是 4 或 5 条），之后的被跳过
+	s.BroadcastToChannel("room", "after")
+	var got []interface{}
+	for {
+		msg := c.Expect("message")
+		if msg.Data == "after" {
+			break
+		}
+		got = append(got, msg.Data)
+	}
+	if len(got) < 4 || len(got) > 5 {
+		t.Fatalf("恢复后收到 %v, want 4 或 5 条", got)
+	}
+	for i, data := range got {
+		if data != float64(i) {
+			t.Fatalf("恢复后收到 %v, 第 %d 条应为 %d", got, i, i)
+		}
+	}
+}
+
+func TestDegradedClientEvictedAfterGrace(t *testing.T) {
+	s, c, _ := degradedServer(t)
+
+	// 宽限期结束时仍然卡住，断开连接
+	if code, reason := c.ExpectClosed(); code != websocket.CloseTryAgainLater || reason != "slow client" {
+		t.Fatalf("关闭 (%d, %q), want (%d, slow client)", code, reason, websocket.CloseTryAgainLater)
+	}
+	if n := s.Metrics.SlowEvictions.Load(); n != 1 {
+		t.Fatalf("SlowEvictions = %d, want 1", n)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+// 响应码。200 表示成功，其余为错误，客户端可按它区分错误类型，msg 只用于展示和排查
+const (
+	CodeSuccess        = 200  // 成功
+	CodeBadRequest     = 400  // 消息无法解析、没有通过校验或参数不合法
+	CodeUnauthorized   = 401  // 操作要求已认证的连接
+	CodeForbidden      = 403  // 没有权限（只读连接、CanSubscribe/CanPublish 拒绝、超过订阅数上限）或服务器没有开启该功能
+	CodeNotFound       = 404  // 操作的目标不存在，如控制命令指定的客户端
+	CodeResync         = 409  // 可靠频道的客户端落后太多，需要重新同步
+	CodeBadFrameType   = 415  // 帧类型与服务器的编解码器不匹配（如 JSON 服务器收到二进制帧）
+	CodeInvalidData    = 422  // Data 没有通过该 action 的校验器
+	CodeRateLimited    = 429  // 超过消息、发布或新建频道的速率限制
+	CodeMaintenance    = 503  // 服务器处于维护模式，拒绝订阅和发布
+	CodeUnknownAction  = 4001 // 不支持的 action
+	CodeMissingChannel = 4002 // 订阅/取消订阅没有指定频道
+	CodeInvalidChannel = 4003 // 频道名没有通过 ChannelValidator 校验
+	CodeNotSubscribed  = 4004 // 操作要求已订阅该频道
+)
+
+// 构造发给客户端的错误响应，调用方按需补上 RequestID、Channel 等字段后发送
+func errorResponse(client *Client, action string, code int, msg string) Response {
+	return Response{
+		ClientID: client.ID,
+		Action:   action,
+		Code:     code,
+		Msg:      msg,
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestErrorCodes(t *testing.T) {
+	longName := strings.Repeat("x", defaultMaxActionLength+1)
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.CanSubscribe = func(client *Client, channel string) bool { return channel != "private" }
+	})
+	c := Dial(t, ts, "")
+
+	tests := []struct {
+		name        string
+		messageType int
+		frame       string
+		action      string // 错误响应中的 action，无法解析时为空
+		code        int
+	}{
+		{"malformed json", websocket.TextMessage, `{"action":`, "", CodeBadRequest},
+		{"binary frame", websocket.BinaryMessage, `{"action":"ping"}`, "", CodeBadFrameType},
+		{"unknown action", websocket.TextMessage, `{"action":"dance"}`, "dance", CodeUnknownAction},
+		{"missing channel", websocket.TextMessage, `{"action":"subscribe"}`, "subscribe", CodeMissingChannel},
+		{"invalid channel", websocket.TextMessage, `{"action":"subscribe","channel":"a b"}`, "subscribe", CodeInvalidChannel},
+		{"subscribe denied", websocket.TextMessage, `{"action":"subscribe","channel":"private"}`, "subscribe", CodeForbidden},
+		{"publish not subscribed", websocket.TextMessage, `{"action":"publish","channel":"room","data":1}`, "publish", CodeNotSubscribed},
+		{"oversized action", websocket.TextMessage, `{"action":"` + longName + `"}`, longName, CodeBadRequest},
+	}
+	for _, tt := range tests {
+		if err := c.Conn.WriteMessage(tt.messageType, []byte(tt.frame)); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.NextMessage(testTimeout)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.Action != tt.action || resp.Code != tt.code || resp.Msg == "" || resp.ClientID != c.ID {
+			t.Errorf("%s: 响应 action=%q code=%d msg=%q, want action=%q code=%d", tt.name, resp.Action, resp.Code, resp.Msg, tt.action, tt.code)
+		}
+	}
+
+	// 错误之后连接照常可用
+	c.Subscribe("room")
+	if resp := c.Publish("room", 1); resp.Code != CodeSuccess {
+		t.Fatalf("发布失败: %d %s", resp.Code, resp.Msg)
+	}
+}
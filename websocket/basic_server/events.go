@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 服务器内部事件的类型
+type EventType string
+
+const (
+	EventConnect     EventType = "connect"     // 客户端完成注册
+	EventDisconnect  EventType = "disconnect"  // 客户端被注销
+	EventSubscribe   EventType = "subscribe"   // 客户端订阅频道（含通配订阅和会话恢复）
+	EventUnsubscribe EventType = "unsubscribe" // 客户端取消订阅，断开时对每个频道各产生一条
+)
+
+// 服务器内部事件，用于管理面板等观察者；与面向客户端的在线状态事件无关
+type Event struct {
+	Type     EventType
+	ClientID string
+	Channel  string // 连接事件为空
+	Time     time.Time
+}
+
+// 每个观察者的事件缓冲，满了之后新事件被丢弃
+const eventBufferSize = 256
+
+// 事件观察者
+type eventHub struct {
+	mu        sync.Mutex
+	observers map[chan Event]bool
+}
+
+// 订阅服务器事件流。每次调用返回一个新的通道，所有观察者都会收到全部事件；
+// 发送是非阻塞的，观察者处理太慢时事件会被丢弃（计入 Metrics.EventDrops），不会拖慢事件循环。
+// 不再需要时调用 StopEvents
+func (s *Server) Events() <-chan Event {
+	ch := make(chan Event, eventBufferSize)
+	s.events.mu.Lock()
+	s.events.observers[ch] = true
+	s.events.mu.Unlock()
+	return ch
+}
+
+// 取消事件订阅并关闭该通道，重复调用无副作用
+func (s *Server) StopEvents(events <-chan Event) {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	for ch := range s.events.observers {
+		if ch == events {
+			delete(s.events.observers, ch)
+			close(ch)
+			return
+		}
+	}
+}
+
+// 向所有观察者发布事件。可能在持有 s.mu 或分片锁时调用，只做非阻塞发送
+func (s *Server) emit(typ EventType, client *Client, channel string) {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	if len(s.events.observers) == 0 {
+		return
+	}
+
+	event := Event{Type: typ, ClientID: client.ID, Channel: channel, Time: time.Now()}
+	for ch := range s.events.observers {
+		select {
+		case ch <- event:
+		default:
+			s.Metrics.EventDrops.Add(1)
+		}
+	}
+}
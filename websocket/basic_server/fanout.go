@@ -0,0 +1,156 @@
+package main
+
+import "sync"
+
+// 每个投递 worker 默认的队列长度
+const defaultFanoutQueueSize = 256
+
+// 投递 worker 池：每个频道有自己的队列，空闲的 worker 轮流取出有待投递广播的频道，
+// 每次投递一条后把频道放回就绪队列末尾。同一频道同一时刻只在一个 worker 上，保持频道内顺序；
+// 一个慢频道最多占住一个 worker，不会挡住排在它后面的其它频道
+type fanoutPool struct {
+	mu       sync.Mutex
+	channels map[string]*channelFanout // 有待投递或正在投递的频道
+	// 就绪的频道；每个就绪频道至少占一个槽位，容量与 slots 相同，放入时不会阻塞
+	ready chan *channelFanout
+	// 等待投递的广播总数上限，取不到槽位时事件循环等待，形成背压
+	slots chan struct{}
+}
+
+// 一个频道的投递队列
+type channelFanout struct {
+	channel string
+	pending []BroadcastMsg // 由 fanoutPool.mu 保护
+	// 投递期间持有；事件循环投递紧急广播或回收频道时也要先拿到它，同一频道不会有两条广播同时投递
+	mu sync.Mutex
+}
+
+// 启动 FanoutWorkers 个投递 worker（由 Run 调用，0 表示沿用事件循环串行投递）
+func (s *Server) startFanoutWorkers() {
+	if s.FanoutWorkers <= 0 {
+		return
+	}
+	size := s.FanoutQueueSize
+	if size <= 0 {
+		size = defaultFanoutQueueSize
+	}
+	capacity := size * s.FanoutWorkers
+	s.fanoutPool = &fanoutPool{
+		channels: make(map[string]*channelFanout),
+		ready:    make(chan *channelFanout, capacity),
+		slots:    make(chan struct{}, capacity),
+	}
+	for i := 0; i < s.FanoutWorkers; i++ {
+		go s.runFanoutWorker()
+	}
+}
+
+// 把广播放入频道的队列。排队的广播总数达到上限时事件循环在这里等待，形成背压；
+// 服务器已强制关闭时同步调用方直接得到空的投递结果
+func (s *Server) dispatchFanout(msg BroadcastMsg) {
+	p := s.fanoutPool
+	select {
+	case p.slots <- struct{}{}:
+	case <-s.ctx.Done():
+		if msg.result != nil {
+			msg.result <- deliveryResult{}
+		}
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cf := p.channels[msg.Channel]; cf != nil {
+		// 已在就绪队列或正在投递，投递它的 worker 会接着处理
+		cf.pending = append(cf.pending, msg)
+		return
+	}
+	cf := &channelFanout{channel: msg.Channel, pending: []BroadcastMsg{msg}}
+	p.channels[msg.Channel] = cf
+	p.ready <- cf
+}
+
+// 逐个处理就绪的频道，直到服务器强制关闭。关闭后所有频道中剩余的同步广播得到空的投递结果
+func (s *Server) runFanoutWorker() {
+	p := s.fanoutPool
+	for {
+		select {
+		case cf := <-p.ready:
+			s.fanoutNext(cf)
+		case <-s.ctx.Done():
+			p.mu.Lock()
+			for channel, cf := range p.channels {
+				for _, msg := range cf.pending {
+					if msg.result != nil {
+						msg.result <- deliveryResult{}
+					}
+				}
+				cf.pending = nil
+				delete(p.channels, channel)
+			}
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// 投递频道队列中的第一条广播；还有剩余时把频道放回就绪队列末尾，否则移除
+func (s *Server) fanoutNext(cf *channelFanout) {
+	p := s.fanoutPool
+	p.mu.Lock()
+	if len(cf.pending) == 0 {
+		// 关闭时已被清空
+		p.mu.Unlock()
+		return
+	}
+	msg := cf.pending[0]
+	cf.pending[0] = BroadcastMsg{}
+	cf.pending = cf.pending[1:]
+	p.mu.Unlock()
+
+	s.fanoutInWorker(cf, msg)
+	<-p.slots
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(cf.pending) > 0 {
+		p.ready <- cf
+	} else if p.channels[cf.channel] == cf {
+		delete(p.channels, cf.channel)
+	}
+}
+
+// 用户钩子 panic 时与事件循环一样记录并恢复，worker 继续运行
+func (s *Server) fanoutInWorker(cf *channelFanout, msg BroadcastMsg) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			s.panics.Add(1)
+			s.Logger.Error("投递 worker 发生panic，已恢复", "event", "panic", "channel", msg.Channel, "panic", r)
+		}
+	}()
+	s.deliverBroadcast(msg, true)
+}
+
+// 在事件循环中与频道正在进行的 worker 投递互斥，返回解锁函数。
+// 频道没有排队的广播时不会有 worker 在投递它，新的广播也只能由事件循环放入，无需等待
+func (s *Server) lockChannelFanout(channel string) func() {
+	if s.fanoutPool == nil {
+		return func() {}
+	}
+	p := s.fanoutPool
+	p.mu.Lock()
+	cf := p.channels[channel]
+	p.mu.Unlock()
+	if cf == nil {
+		return func() {}
+	}
+	cf.mu.Lock()
+	return cf.mu.Unlock
+}
+
+// 投递紧急广播：开启投递 worker 时等该频道正在进行的投递结束后立即投递，不排在队列中的普通广播之后
+func (s *Server) deliverUrgent(msg BroadcastMsg) {
+	defer s.lockChannelFanout(msg.Channel)()
+	s.deliverBroadcast(msg, false)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFanoutWorkersKeepChannelOrder(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.FanoutWorkers = 4
+	})
+	channels := []string{"a", "b", "c", "d", "e"}
+	c := Dial(t, ts, "")
+	for _, channel := range channels {
+		c.Subscribe(channel)
+	}
+
+	const perChannel = 50
+	var batch []BroadcastMsg
+	for i := 0; i < perChannel; i++ {
+		for _, channel := range channels {
+			batch = append(batch, BroadcastMsg{Channel: channel, Data: i})
+		}
+	}
+	s.BroadcastBatch(batch)
+
+	next := make(map[string]int)
+	for n := 0; n < len(batch); n++ {
+		msg := c.Expect("message")
+		if got := fmt.Sprint(msg.Data); got != fmt.Sprint(next[msg.Channel]) {
+			t.Fatalf("频道 %s 收到 %s, want %d", msg.Channel, got, next[msg.Channel])
+		}
+		next[msg.Channel]++
+	}
+}
+
+func TestFanoutWorkersSyncResult(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.FanoutWorkers = 2
+	})
+	for i := 0; i < 3; i++ {
+		Dial(t, ts, "").Subscribe("room")
+	}
+	if delivered, skipped := s.BroadcastToChannelSync("room", "hi"); delivered != 3 || skipped != 0 {
+		t.Fatalf("delivered=%d skipped=%d, want 3 0", delivered, skipped)
+	}
+}
+
+func TestFanoutSlowChannelDoesNotBlockOthers(t *testing.T) {
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.FanoutWorkers = 2
+		s.MessageFilter = func(client *Client, channel string, data interface{}) (interface{}, bool) {
+			if channel == "slow" {
+				select {
+				case blocked <- struct{}{}:
+				default:
+				}
+				<-release
+			}
+			return data, true
+		}
+	})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+
+	c := Dial(t, ts, "")
+	channels := []string{"slow", "a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, channel := range channels {
+		c.Subscribe(channel)
+	}
+
+	// "slow" 卡在一个 worker 上，后面还排着一条；其它频道不论原来会分到哪个 worker 都照常投递
+	s.BroadcastToChannel("slow", 0)
+	s.BroadcastToChannel("slow", 1)
+	select {
+	case <-blocked:
+	case <-time.After(testTimeout):
+		t.Fatal("slow 的投递没有开始")
+	}
+	for _, channel := range channels[1:] {
+		done := make(chan int, 1)
+		go func() {
+			delivered, _ := s.BroadcastToChannelSync(channel, "hi")
+			done <- delivered
+		}()
+		select {
+		case delivered := <-done:
+			if delivered != 1 {
+				t.Fatalf("频道 %s delivered=%d, want 1", channel, delivered)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("频道 %s 被 slow 阻塞", channel)
+		}
+		if msg := c.Expect("message"); msg.Channel != channel {
+			t.Fatalf("收到频道 %s, want %s", msg.Channel, channel)
+		}
+	}
+
+	unblock()
+	for i := 0; i < 2; i++ {
+		if msg := c.Expect("message"); msg.Channel != "slow" || fmt.Sprint(msg.Data) != fmt.Sprint(i) {
+			t.Fatalf("收到 %s %v, want slow %d", msg.Channel, msg.Data, i)
+		}
+	}
+}
+
+// 一个有 300 个订阅者的频道正在广播（MessageFilter 对每个订阅者阻塞约 20µs，模拟查询外部服务）时，
+// 测量另一个只有一个订阅者的频道上 BroadcastToChannelSync 的耗时
+func BenchmarkSmallChannelDuringLargeBroadcast(b *testing.B) {
+	for _, workers := range []int{0, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			var bigStarted atomic.Int64
+			s, ts := newTestServer(b, DefaultServerConfig(), func(s *Server) {
+				s.FanoutWorkers = workers
+				s.MessageFilter = func(client *Client, channel string, data interface{}) (interface{}, bool) {
+					if channel == "big" {
+						bigStarted.Add(1)
+						time.Sleep(20 * time.Microsecond)
+					}
+					return data, true
+				}
+			})
+			for i := 0; i < 300; i++ {
+				dialDiscard(b, ts, "channels=big")
+			}
+			dialDiscard(b, ts, "channels=small")
+			waitFor(b, "subscriptions", func() bool {
+				return s.subscriptions.count("big") == 300 && s.subscriptions.count("small") == 1
+			})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				started := bigStarted.Load()
+				done := make(chan struct{})
+				go func() {
+					s.BroadcastToChannelSync("big", "tick")
+					close(done)
+				}()
+				// 等大频道的投递真正开始后再计时
+				for bigStarted.Load() == started {
+					runtime.Gosched()
+				}
+				b.StartTimer()
+				s.BroadcastToChannelSync("small", i)
+				b.StopTimer()
+				<-done
+			}
+		})
+	}
+}
+
+// 只读取并丢弃消息的连接，用作基准测试中的大量订阅者
+func dialDiscard(tb testing.TB, ts *httptest.Server, query string) {
+	tb.Helper()
+	conn, _, err := dialRaw(ts, query, nil, websocket.DefaultDialer)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+}
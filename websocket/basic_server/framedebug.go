@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/hex"
+
+	"github.com/gorilla/websocket"
+)
+
+// FrameDebugMaxLen 未设置时每帧最多记录的字节数
+const defaultFrameDebugMaxLen = 256
+
+// 记录一帧的原始内容，direction 为 in 或 out。调用方先检查 FrameDebug，关闭时不产生任何开销
+func (s *Server) logFrame(client *Client, direction string, messageType int, payload []byte) {
+	limit := s.FrameDebugMaxLen
+	if limit <= 0 {
+		limit = defaultFrameDebugMaxLen
+	}
+	shown := payload
+	if len(shown) > limit {
+		shown = shown[:limit]
+	}
+
+	attrs := []interface{}{"event", "frame", "client_id", client.ID, "direction", direction, "bytes", len(payload), "truncated", len(payload) > limit}
+	if messageType == websocket.BinaryMessage {
+		attrs = append(attrs, "type", "binary")
+		if s.FrameDebugHex {
+			attrs = append(attrs, "hex", hex.EncodeToString(shown))
+		}
+	} else {
+		attrs = append(attrs, "type", "text", "payload", string(shown))
+	}
+	s.Logger.Info("原始帧", attrs...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// 并发安全的日志缓冲，readPump 和 writePump 同时写入
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// 取出 event=frame 的日志记录
+func (b *logBuffer) frames(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var frames []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["event"] == "frame" {
+			frames = append(frames, record)
+		}
+	}
+	return frames
+}
+
+func frameDebugServer(t *testing.T, enabled bool) (*logBuffer, *TestClient) {
+	logs := &logBuffer{}
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+		s.FrameDebug = enabled
+		s.FrameDebugMaxLen = 16
+		s.FrameDebugHex = true
+	})
+	return logs, Dial(t, ts, "")
+}
+
+func TestFrameDebugLogsFrames(t *testing.T) {
+	logs, c := frameDebugServer(t, true)
+	c.Send(Message{Action: "ping", RequestID: "p1"})
+	c.Expect("pong")
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, []byte{0xca, 0xfe}); err != nil {
+		t.Fatal(err)
+	}
+
+	var in, out, binary map[string]interface{}
+	waitFor(t, "frames logged", func() bool {
+		in, out, binary = nil, nil, nil
+		for _, frame := range logs.frames(t) {
+			switch {
+			case frame["type"] == "binary":
+				binary = frame
+			case frame["direction"] == "in":
+				in = frame
+			case frame["direction"] == "out":
+				out = frame
+			}
+		}
+		return in != nil && out != nil && binary != nil
+	})
+	for _, frame := range []map[string]interface{}{in, out, binary} {
+		if frame["client_id"] != c.ID {
+			t.Fatalf("日志缺少客户端ID: %v", frame)
+		}
+	}
+	// 文本帧截断到 FrameDebugMaxLen，bytes 记录原始长度
+	if p := in["payload"].(string); len(p) != 16 || in["truncated"] != true || in["bytes"].(float64) <= 16 {
+		t.Fatalf("入站帧 %v", in)
+	}
+	if binary["direction"] != "in" || binary["hex"] != "cafe" || binary["bytes"] != float64(2) {
+		t.Fatalf("二进制帧 %v", binary)
+	}
+}
+
+func TestFrameDebugDisabled(t *testing.T) {
+	logs, c := frameDebugServer(t, false)
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+	if frames := logs.frames(t); len(frames) != 0 {
+		t.Fatalf("关闭时记录了 %d 帧: %v", len(frames), frames)
+	}
+}
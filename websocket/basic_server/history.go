@@ -0,0 +1,227 @@
+package main
+
+import (
+	"time"
+)
+
+// 订阅选项
+type subscribeOptions struct {
+	since     int64  // 回放 SentAt 晚于该时间（Unix 毫秒）的历史消息，0 表示不回放
+	sinceSeq  uint64 // 回放序号大于它的历史消息，优先于 since
+	compress  *bool  // 该频道消息是否压缩，nil 表示使用连接级设置
+	requestID string // 带回确认中的请求ID
+}
+
+// 单个频道的历史消息环形缓冲
+type historyRing struct {
+	entries []Response
+	next    int
+	full    bool
+}
+
+func (h *historyRing) add(resp Response, size int) {
+	if len(h.entries) < size && !h.full {
+		h.entries = append(h.entries, resp)
+		if len(h.entries) == size {
+			h.full = true
+		}
+		return
+	}
+	h.entries[h.next] = resp
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// 按时间顺序返回所有历史消息
+func (h *historyRing) ordered() []Response {
+	if !h.full {
+		return append([]Response(nil), h.entries...)
+	}
+	out := make([]Response, 0, len(h.entries))
+	out = append(out, h.entries[h.next:]...)
+	return append(out, h.entries[:h.next]...)
+}
+
+// 只保留最近 size 条
+func (h *historyRing) resize(size int) {
+	entries := h.ordered()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	h.entries, h.next, h.full = entries, 0, len(entries) == size
+}
+
+// 无订阅者的频道在该时长后释放历史（HistoryRetention 未设置时使用）
+const defaultHistoryReclaimDelay = 5 * time.Minute
+
+// 为单个频道设置历史条数，覆盖全局的 HistorySize（0 表示该频道不保留历史）；
+// 传负数恢复使用全局配置。设置了覆盖的频道没有订阅者时也不会释放历史
+func (s *Server) SetChannelHistorySize(channel string, size int) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	if size < 0 {
+		delete(s.channelHistorySizes, channel)
+		size = s.HistorySize
+	} else {
+		s.channelHistorySizes[channel] = size
+	}
+
+	if ring, ok := s.history[channel]; ok {
+		if size <= 0 {
+			delete(s.history, channel)
+		} else {
+			ring.resize(size)
+		}
+	}
+}
+
+// 频道的历史条数（调用方需持有 historyMu）
+func (s *Server) historySize(channel string) int {
+	if size, ok := s.channelHistorySizes[channel]; ok {
+		return size
+	}
+	return s.HistorySize
+}
+
+// 记录一条广播到频道历史（调用方需持有该频道分片的锁，与订阅互斥，保证回放与实时消息之间不重不漏）
+func (s *Server) recordHistory(resp Response) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	size := s.historySize(resp.Channel)
+	if size <= 0 {
+		return
+	}
+	ring, ok := s.history[resp.Channel]
+	if !ok {
+		ring = &historyRing{}
+		s.history[resp.Channel] = ring
+	}
+	ring.add(resp, size)
+}
+
+// 频道失去最后一个订阅者后，延迟释放它的历史：到期时仍无订阅者、且没有单独设置历史条数则删除。
+// 延迟取 HistoryRetention（此后的历史本来也无法回放），未设置时为 5 分钟，给断线重连留出回放窗口
+func (s *Server) scheduleHistoryReclaim(channel string) {
+	s.historyMu.Lock()
+	_, ok := s.history[channel]
+	s.historyMu.Unlock()
+	if !ok {
+		return
+	}
+
+	delay := s.HistoryRetention
+	if delay <= 0 {
+		delay = defaultHistoryReclaimDelay
+	}
+	time.AfterFunc(delay, func() {
+		if s.subscriptions.count(channel) > 0 {
+			return
+		}
+		s.historyMu.Lock()
+		if _, ok := s.channelHistorySizes[channel]; !ok {
+			delete(s.history, channel)
+		}
+		s.historyMu.Unlock()
+	})
+}
+
+// 返回频道中 SentAt 晚于 since 且仍在保留窗口内的历史消息
+func (s *Server) historySince(channel string, since int64) []Response {
+	s.historyMu.Lock()
+	ring, ok := s.history[channel]
+	var entries []Response
+	if ok {
+		entries = ring.ordered()
+	}
+	s.historyMu.Unlock()
+
+	if s.HistoryRetention > 0 {
+		if cutoff := time.Now().Add(-s.HistoryRetention).UnixMilli(); since < cutoff {
+			since = cutoff
+		}
+	}
+
+	out := entries[:0]
+	for _, entry := range entries {
+		if entry.SentAt > since {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// 处理历史查询：把频道的历史消息（可用 since 限定起点）作为一条 history 响应返回，只有订阅者可以查询
+func (s *Server) handleHistory(client *Client, channel string, since int64, requestID string) {
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: requestID,
+		Action:    "history",
+		Channel:   channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+	if !client.subscribed(channel) {
+		response.Code = CodeNotSubscribed
+		response.Msg = "not subscribed"
+		s.sendResponse(client, response)
+		return
+	}
+
+	entries := s.historySince(channel, since)
+	if entries == nil {
+		entries = []Response{}
+	}
+	if s.HistoryBatchSize > 0 {
+		s.startHistoryReplay(client, channel, requestID, entries)
+		return
+	}
+	response.Data = entries
+	s.sendResponse(client, response)
+}
+
+// 订阅时回放序号大于 seq 的历史消息（调用方需持有该频道分片的写锁）。
+// 记录历史和复制订阅列表在同一把分片锁内完成，回放期间到达的广播要等订阅完成后才投递，
+// 因此回放与实时消息之间既不会缺也不会重复。历史已经不完整（被淘汰或服务器重启后序号重置）时，
+// 先发送一条 resync 通知，再回放仍在的部分
+func (s *Server) replayHistorySeq(client *Client, channel string, seq uint64) {
+	current := s.currentSeq(channel)
+	if seq < current {
+		// 回放的消息还没有确认，断线后恢复会话时要从这里补发
+		client.setResumeSeq(channel, seq)
+	}
+	if seq > current || uint64(len(s.historyAfter(channel, seq))) < current-seq {
+		response := Response{
+			ClientID: client.ID,
+			Action:   "resync",
+			Channel:  channel,
+			Code:     CodeResync,
+			Msg:      "history truncated",
+			Data:     map[string]uint64{"since": seq, "current": current},
+			SentAt:   time.Now().UnixMilli(),
+			Seq:      current,
+		}
+		s.sendResponse(client, response)
+		s.Logger.Info("历史不完整，要求重新同步", "event", "resync", "client_id", client.ID, "channel", channel, "since", seq, "current", current)
+	}
+	s.replayAfter(client, channel, seq)
+}
+
+// 订阅时回放历史消息（调用方需持有该频道分片的写锁）
+func (s *Server) replayHistory(client *Client, channel string, since int64) {
+	entries := s.historySince(channel, since)
+	for _, entry := range entries {
+		entry.ClientID = client.ID
+		data, ok := s.marshalFor(client, entry)
+		if !ok {
+			continue
+		}
+		if !s.trySend(client, data) {
+			s.Logger.Warn("缓冲区已满，历史回放中断", "event", "history_replay_aborted", "client_id", client.ID, "channel", channel)
+			return
+		}
+	}
+	if len(entries) > 0 {
+		s.Logger.Debug("回放历史消息", "event", "history_replay", "client_id", client.ID, "channel", channel, "count", len(entries))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeSinceSeqHandsOffToLive(t *testing.T) {
+	const total = 500
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.HistorySize = total
+		s.SendBufferSize = 2 * total
+	})
+	for i := 1; i <= 100; i++ {
+		s.BroadcastToChannelSync("feed", i)
+	}
+
+	// 订阅与后续发布并发进行：回放 50 之后的历史，再无缝接上实时消息，边界处不丢不重
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 101; i <= total; i++ {
+			s.BroadcastToChannel("feed", i)
+		}
+	}()
+	c := Dial(t, ts, "")
+	c.Send(Message{Action: "subscribe", Channel: "feed", SinceSeq: 50})
+	if ack := c.Expect("subscribe"); ack.Code != CodeSuccess {
+		t.Fatalf("订阅失败: %d %s", ack.Code, ack.Msg)
+	}
+	<-published
+
+	for want := uint64(51); want <= total; want++ {
+		msg := c.Expect("message")
+		if msg.Seq != want || msg.Data != float64(want) {
+			t.Fatalf("收到 seq=%d data=%v, want seq=%d", msg.Seq, msg.Data, want)
+		}
+		if msg.SentAt == 0 {
+			t.Fatalf("seq %d 没有时间戳", msg.Seq)
+		}
+	}
+	c.ExpectNone(100 * time.Millisecond)
+}
+
+func TestHistoryReplayInBatches(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.HistorySize = 10
+		s.HistoryBatchSize = 4
+	})
+	for i := 1; i <= 10; i++ {
+		s.BroadcastToChannelSync("feed", i)
+	}
+	c := Dial(t, ts, "")
+	c.Subscribe("feed")
+
+	// 每批之后等客户端确认，再发下一批；最后一批之后是 history_complete
+	c.Send(Message{Action: "history", Channel: "feed", RequestID: "h1"})
+	next := 1
+	for _, size := range []int{4, 4, 2} {
+		batch := c.Expect("history")
+		entries, _ := batch.Data.([]interface{})
+		if batch.RequestID != "h1" || len(entries) != size {
+			t.Fatalf("收到 %+v, want %d 条", batch, size)
+		}
+		for _, entry := range entries {
+			if data := entry.(map[string]interface{})["data"]; data != float64(next) {
+				t.Fatalf("历史消息 %v, want %d", data, next)
+			}
+			next++
+		}
+		if size == 4 {
+			c.ExpectNone(200 * time.Millisecond)
+		}
+		c.Send(Message{Action: "history_ack", Channel: "feed"})
+	}
+	done := c.Expect("history_complete")
+	if done.RequestID != "h1" || done.Msg != "success" || done.Data.(map[string]interface{})["count"] != float64(10) {
+		t.Fatalf("history_complete %+v", done)
+	}
+	c.ExpectNone(200 * time.Millisecond)
+
+	// 中途取消：确认 cancelled，之后的 history_ack 被忽略
+	c.Send(Message{Action: "history", Channel: "feed", RequestID: "h2"})
+	c.Expect("history")
+	c.Send(Message{Action: "history_cancel", Channel: "feed"})
+	done = c.Expect("history_complete")
+	if done.RequestID != "h2" || done.Msg != "cancelled" || done.Data.(map[string]interface{})["count"] != float64(4) {
+		t.Fatalf("取消后 history_complete %+v", done)
+	}
+	c.Send(Message{Action: "history_ack", Channel: "feed"})
+	c.ExpectNone(200 * time.Millisecond)
+}
@@ -0,0 +1,100 @@
+package main
+
+// 进行中的分批历史查询
+type historyReplay struct {
+	requestID string
+	remaining []Response
+	sent      int
+}
+
+// 开始分批返回历史查询的结果，同一频道上一次未完成的回放被替换
+func (s *Server) startHistoryReplay(client *Client, channel, requestID string, entries []Response) {
+	replay := &historyReplay{requestID: requestID, remaining: entries}
+	client.replayMu.Lock()
+	if client.historyReplays == nil {
+		client.historyReplays = make(map[string]*historyReplay)
+	}
+	client.historyReplays[channel] = replay
+	client.replayMu.Unlock()
+	s.sendHistoryBatch(client, channel, replay)
+}
+
+// 发送下一批历史消息，最后一批之后紧接着发送 history_complete
+func (s *Server) sendHistoryBatch(client *Client, channel string, replay *historyReplay) {
+	client.replayMu.Lock()
+	n := min(s.HistoryBatchSize, len(replay.remaining))
+	batch := replay.remaining[:n:n]
+	replay.remaining = replay.remaining[n:]
+	replay.sent += n
+	done := len(replay.remaining) == 0
+	if done {
+		delete(client.historyReplays, channel)
+	}
+	client.replayMu.Unlock()
+
+	s.sendResponse(client, Response{
+		ClientID:  client.ID,
+		RequestID: replay.requestID,
+		Action:    "history",
+		Channel:   channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data:      batch,
+	})
+	if done {
+		s.sendHistoryComplete(client, channel, replay, "success")
+	}
+}
+
+func (s *Server) sendHistoryComplete(client *Client, channel string, replay *historyReplay, msg string) {
+	s.sendResponse(client, Response{
+		ClientID:  client.ID,
+		RequestID: replay.requestID,
+		Action:    "history_complete",
+		Channel:   channel,
+		Code:      CodeSuccess,
+		Msg:       msg,
+		Data:      map[string]int{"count": replay.sent},
+	})
+}
+
+// 取出频道进行中的回放，没有时返回 nil
+func (c *Client) historyReplay(channel string) *historyReplay {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	return c.historyReplays[channel]
+}
+
+// 客户端确认收到一批历史消息，继续发送下一批。没有进行中的回放时忽略
+// （最后一批之后的确认可能与 history_complete 交错，不算错误）
+func (s *Server) handleHistoryAck(client *Client, msg *Message) {
+	replay := client.historyReplay(msg.Channel)
+	if replay == nil {
+		return
+	}
+	if !client.subscribed(msg.Channel) {
+		s.dropHistoryReplay(client, msg.Channel)
+		response := errorResponse(client, msg.Action, CodeNotSubscribed, "not subscribed")
+		response.RequestID = replay.requestID
+		response.Channel = msg.Channel
+		s.sendResponse(client, response)
+		return
+	}
+	s.sendHistoryBatch(client, msg.Channel, replay)
+}
+
+// 客户端取消进行中的回放，以 msg 为 cancelled 的 history_complete 确认
+func (s *Server) handleHistoryCancel(client *Client, msg *Message) {
+	replay := client.historyReplay(msg.Channel)
+	if replay == nil {
+		return
+	}
+	s.dropHistoryReplay(client, msg.Channel)
+	s.sendHistoryComplete(client, msg.Channel, replay, "cancelled")
+}
+
+func (s *Server) dropHistoryReplay(client *Client, channel string) {
+	client.replayMu.Lock()
+	delete(client.historyReplays, channel)
+	client.replayMu.Unlock()
+}
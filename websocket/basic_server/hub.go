@@ -0,0 +1,27 @@
+package main
+
+// 业务代码运行期间使用的服务器能力：广播、定向发送、查询和断开连接。
+// 依赖 Hub 而不是 *Server 的处理函数可以在测试中换成假实现；
+// 启动配置（Set*/Enable*）、HTTP 处理函数和 Run/Shutdown 等生命周期方法不在其中，仍通过 *Server 装配
+type Hub interface {
+	BroadcastToChannel(channel string, data interface{})
+	BroadcastToChannelSync(channel string, data interface{}) (delivered int, skipped int)
+	BroadcastToChannelExcept(channel string, data interface{}, exceptClientID string)
+	BroadcastWithCorrelation(channel string, data interface{}, correlationID string)
+	BroadcastUrgent(channel string, data interface{})
+	BroadcastBatch(msgs []BroadcastMsg)
+	BroadcastToAll(data interface{})
+
+	SendToClient(clientID string, data interface{}) error
+	SendBinaryToClient(clientID string, payload []byte) error
+	SendToUser(userID string, data interface{}) (delivered int)
+	Disconnect(clientID string, reason string) error
+
+	ChannelSubscribers(channel string) []string
+	ChannelCount(channel string) int
+	Channels() []string
+	ClientInfo(id string) (info ClientInfo, ok bool)
+	Stats() StatsSnapshot
+}
+
+var _ Hub = (*Server)(nil)
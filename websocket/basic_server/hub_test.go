@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// 只实现用到的方法，其余方法由嵌入的 Hub 提供（调用会 panic）
+type mockHub struct {
+	Hub
+	sent map[string][]interface{}
+}
+
+func (m *mockHub) BroadcastToChannel(channel string, data interface{}) {
+	m.sent[channel] = append(m.sent[channel], data)
+}
+
+func (m *mockHub) ChannelCount(channel string) int {
+	return 3
+}
+
+// 业务代码只依赖 Hub
+func notifyOrderFilled(hub Hub, orderID string) int {
+	hub.BroadcastToChannel("orders", map[string]string{"filled": orderID})
+	return hub.ChannelCount("orders")
+}
+
+func ExampleHub() {
+	hub := &mockHub{sent: map[string][]interface{}{}}
+	n := notifyOrderFilled(hub, "42")
+	fmt.Println(hub.sent["orders"], n)
+	// Output: [map[filled:42]] 3
+}
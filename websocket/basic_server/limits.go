@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 超过连接数上限时默认建议客户端等待的时间
+const defaultCapacityRetryAfter = 5 * time.Second
+
+// 连接数限制的计数。槽位在升级前预留、注销时释放，检查和注册之间不会超发
+type connLimits struct {
+	total int
+	perIP map[string]int
+}
+
+// 请求的客户端IP（RemoteAddr 的主机部分）。同一 NAT 或代理后的客户端共用一个IP，
+// 部署在反向代理之后时 MaxConnectionsPerIP 应设置得足够宽松
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 为一个新连接预留槽位，超过 MaxConnections 或 MaxConnectionsPerIP 时返回 false
+func (s *Server) reserveConnection(ip string) bool {
+	if s.MaxConnections <= 0 && s.MaxConnectionsPerIP <= 0 {
+		return true
+	}
+
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+
+	if s.MaxConnections > 0 && s.limits.total >= s.MaxConnections {
+		return false
+	}
+	if s.MaxConnectionsPerIP > 0 && s.limits.perIP[ip] >= s.MaxConnectionsPerIP {
+		return false
+	}
+	s.limits.total++
+	s.limits.perIP[ip]++
+	return true
+}
+
+// 超过连接数上限：升级前返回 503 和 Retry-After，让客户端退避后再重连，并记录日志和指标
+func (s *Server) rejectAtCapacity(w http.ResponseWriter, ip string) {
+	retryAfter := s.CapacityRetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultCapacityRetryAfter
+	}
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+
+	s.Logger.Warn("连接数超过上限", "event", "connection_limit", "ip", ip, "retry_after", seconds)
+	s.Metrics.CapacityRejections.Add(1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+}
+
+// 释放 reserveConnection 预留的槽位
+func (s *Server) releaseConnection(ip string) {
+	if s.MaxConnections <= 0 && s.MaxConnectionsPerIP <= 0 {
+		return
+	}
+
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+
+	if s.limits.total > 0 {
+		s.limits.total--
+	}
+	if s.limits.perIP[ip] <= 1 {
+		delete(s.limits.perIP, ip)
+	} else {
+		s.limits.perIP[ip]--
+	}
+}
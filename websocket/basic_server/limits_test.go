@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 期望握手被拒绝并返回 503
+func expectRejected(t *testing.T, ts *httptest.Server) {
+	t.Helper()
+	_, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+	if err == nil {
+		t.Fatal("超过上限的连接应被拒绝")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("应返回 503 和 Retry-After，实际 %v", resp)
+	}
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxConnectionsPerIP = 2
+	})
+	first := Dial(t, ts, "")
+	Dial(t, ts, "")
+	expectRejected(t, ts)
+	if n := s.Metrics.CapacityRejections.Load(); n != 1 {
+		t.Fatalf("CapacityRejections = %d, want 1", n)
+	}
+
+	// 断开后释放槽位
+	first.Conn.Close()
+	waitFor(t, "slot released", func() bool { return serverClient(s, first.ID) == nil })
+	Dial(t, ts, "")
+	expectRejected(t, ts)
+}
+
+func TestMaxConnections(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxConnections = 3
+	})
+	for i := 0; i < 3; i++ {
+		Dial(t, ts, "")
+	}
+	expectRejected(t, ts)
+}
+
+func TestRemoteIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.RemoteAddr = "10.0.0.7:52311"
+	if ip := remoteIP(r); ip != "10.0.0.7" {
+		t.Fatalf("remoteIP = %q", ip)
+	}
+	r.RemoteAddr = "[::1]:8080"
+	if ip := remoteIP(r); ip != "::1" {
+		t.Fatalf("remoteIP = %q", ip)
+	}
+}
+
+func TestCapacityRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{0, strconv.Itoa(int(defaultCapacityRetryAfter / time.Second))},
+		{30 * time.Second, "30"},
+		{1500 * time.Millisecond, "2"}, // 不足一秒的部分向上取整
+	}
+	for _, tt := range tests {
+		_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.MaxConnections = 1
+			s.CapacityRetryAfter = tt.retryAfter
+		})
+		Dial(t, ts, "")
+		_, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("超过上限的连接应返回 503: %v", err)
+		}
+		if got := resp.Header.Get("Retry-After"); got != tt.want {
+			t.Errorf("CapacityRetryAfter %v: Retry-After = %q, want %q", tt.retryAfter, got, tt.want)
+		}
+	}
+}
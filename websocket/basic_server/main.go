@@ -1,350 +1,2809 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
 )
 
-// WebSocket升级器
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 允许所有来源（生产环境应该限制）
-	},
+// 消息类型
+type Message struct {
+	Action  string `json:"action"`
+	Channel string `json:"channel"`
+	// 一次订阅/取消订阅多个频道，非空时忽略 Channel
+	Channels []string    `json:"channels,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Since    int64       `json:"since,omitempty"` // 订阅时回放该时间（Unix 毫秒）之后的历史消息
+	Seq      uint64      `json:"seq,omitempty"`   // ack 确认处理到的频道序号
+	// 订阅时回放该频道序号之后的历史消息，优先于 Since；用于断线重连时从最后收到的 seq 无缝续上
+	SinceSeq uint64 `json:"sinceSeq,omitempty"`
+	// 订阅时指定该频道的消息是否压缩（连接协商了压缩时生效），省略则使用连接级设置
+	Compress *bool `json:"compress,omitempty"`
+	// 客户端生成的请求ID，服务器在对这条消息的响应中原样带回，用于匹配并发请求的响应
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type Response struct {
+	ClientID  string      `json:"clientId"`
+	RequestID string      `json:"requestId,omitempty"` // 对应请求消息的 RequestID
+	Action    string      `json:"action"`
+	Channel   string      `json:"channel"`
+	Code      int         `json:"code"`
+	Msg       string      `json:"msg"`
+	Data      interface{} `json:"data,omitempty"`
+	// 来自 /broadcast 的关联ID，用于把 HTTP 请求和下发的消息关联起来
+	CorrelationID string `json:"correlationId,omitempty"`
+	// 服务器发出频道消息的时间（Unix 毫秒）
+	SentAt int64 `json:"sentAt,omitempty"`
+	// 频道内单调递增的消息序号，长轮询用它作为 cursor
+	Seq uint64 `json:"seq,omitempty"`
+	// 订阅时补发的保留消息（不是实时广播）
+	Retained bool `json:"retained,omitempty"`
+	// 批量订阅确认中每个频道的结果（开启 BatchAcks 时）
+	Results []ChannelResult `json:"results,omitempty"`
+}
+
+// 批量确认中单个频道的订阅结果，Code/Msg 与单频道订阅的确认相同
+type ChannelResult struct {
+	Channel string `json:"channel"`
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+// 自定义关闭码（4000-4999 为应用保留）
+const (
+	CloseRotate  = 4000 // 连接达到最长存活时间，客户端应重新连接
+	CloseMigrate = 4001 // 服务器要求客户端迁移到 redirect 消息给出的地址
+	CloseIdle    = 4002 // 超过 IdleTimeout 没有发送任何消息
+)
+
+// 关闭帧的写入超时
+const closeWriteWait = time.Second
+
+// 普通消息与 ping 的默认写入超时
+const defaultWriteTimeout = 5 * time.Second
+
+// 默认单条入站消息的最大字节数
+const defaultMaxMessageSize = 32 << 10
+
+// 心跳默认值：每 30 秒发一次 ping，40 秒内收不到任何数据视为连接已断开
+const (
+	defaultPingInterval = 30 * time.Second
+	defaultPongWait     = 40 * time.Second
+)
+
+// 默认每个客户端的发送缓冲区大小（消息条数）
+const defaultSendBufferSize = 256
+
+// 发出 redirect 消息后等待多久再关闭连接，留时间让客户端读到目标地址
+const redirectGrace = 2 * time.Second
+
+// 等待事件循环接收新连接的最长时间，超时说明事件循环过于繁忙，以 1013 断开让客户端稍后重试
+const registerTimeout = 5 * time.Second
+
+// 找不到指定ID的客户端
+var ErrClientNotFound = errors.New("client not found")
+
+// 订阅被拒绝的原因
+var (
+	errChannelCreateLimited = errors.New("channel creation rate limited")
+	errTooManyChannels      = errors.New("channel limit reached")
+	errTooManyPatterns      = errors.New("pattern limit reached")
+)
+
+// 客户端发送缓冲区已满，已被断开
+var ErrClientSlow = errors.New("client send buffer full")
+
+// 消息被 OutboundInterceptor 拦截，没有发送
+var ErrMessageBlocked = errors.New("message blocked by OutboundInterceptor")
+
+// 只读连接允许的操作
+var readOnlyActions = map[string]bool{
+	"subscribe":       true,
+	"unsubscribe":     true,
+	"unsubscribe_all": true,
+	"ping":            true,
+	"channel_stats":   true,
+	"history":         true,
+	"ack":             true,
+	"set_batching":    true,
+	"whoami":          true,
+	"history_ack":     true,
+	"history_cancel":  true,
+}
+
+// 发送队列中的一帧
+type OutboundMessage struct {
+	Type    int // websocket.TextMessage 或 websocket.BinaryMessage
+	Payload []byte
+	Channel string // 频道消息所属的频道，其它消息为空
+
+	queuedAt time.Time // 放入发送队列的时间，只在设置了 MessageTTL 时记录
+}
+
+// 一帧写出后的信息
+type FrameInfo struct {
+	Bytes       int    // 负载字节数
+	MessageType int    // 帧类型
+	Channel     string // 所属频道（如有）
+	Compressed  bool   // 是否启用了写压缩
+}
+
+// 客户端连接
+type Client struct {
+	ID       string
+	Conn     *websocket.Conn
+	Send     chan OutboundMessage
+	Channels map[string]bool   // 订阅的频道，由 channelsMu 保护
+	Metadata map[string]string // 连接时记录的元数据（只读）
+	ReadOnly bool              // 只读连接：只能订阅和接收，不能发布或修改状态（由 Authorizer 或 ?readonly 设置）
+	UserID   string            // Authenticator 解析出的用户ID（未认证时为空）
+
+	Subprotocol string // 协商得到的子协议（未协商时为空）
+
+	// 应用自定义的属性（可读写），通常在 OnConnect 中根据 UserID 等写入
+	Attributes Attributes
+
+	channelsMu sync.Mutex
+
+	connectedAt time.Time
+	lastSeen    atomic.Int64 // 最后一次收到消息的时间（UnixNano）
+	lastRTT     atomic.Int64 // 最近一次心跳的往返时间（纳秒）
+
+	awaitingPong atomic.Bool  // 上一次 ping 之后还没有收到 pong 或任何消息
+	missedPongs  atomic.Int32 // 连续没有得到回应的 ping 数
+
+	createLimiter   *tokenBucket            // 新建频道限流（nil 表示不限制）
+	messageLimiter  *tokenBucket            // 入站消息限流（nil 表示不限制）
+	throttled       int                     // 连续被限流的消息数，只在 readPump 中访问
+	publishLimiters map[string]*tokenBucket // 每个频道的发布限流，由 channelsMu 保护，退订时删除
+	counters        *connCounters
+	lifetimeTimer   *time.Timer // 最长存活时间到期后强制轮换
+	closeMessage    []byte      // 发送通道关闭后写出的关闭帧（为空则不带关闭码）
+	idleTimer       *time.Timer // 空闲超时，每收到一条消息重置
+	remoteIP        string      // 连接数限制按它计数
+	queue           queueAccount
+	highWater       atomic.Bool  // 发送队列是否处于高水位以上
+	degradedSince   atomic.Int64 // 进入降级状态的时间（UnixNano），0 表示未降级
+	batching        atomic.Bool  // 客户端是否开启了批量模式
+	priority        atomic.Int32 // 投递优先级，见 SetPriority
+	overflowMu      sync.Mutex   // 保证溢出存储的写入与取回顺序
+
+	paused  atomic.Bool   // 暂停投递，见 Pause
+	resumed chan struct{} // Resume 时通知 writePump
+	removed chan struct{} // removeClient 关闭 Send 后关闭，暂停中的 writePump 据此退出
+
+	registered chan struct{} // 事件循环处理完注册（含会话恢复和自动订阅）后关闭
+
+	readBufferSize  int // 升级时使用的读写缓冲区大小（字节）
+	writeBufferSize int
+
+	compressionNegotiated bool // 握手时是否协商了 permessage-deflate
+	compressMu            sync.Mutex
+	compressPrefs         map[string]bool // 频道 -> 是否压缩
+
+	reliable reliableTracker // 可靠频道的投递进度
+
+	willMu sync.Mutex
+	will   *lastWill // 断开时代为发布的遗嘱消息
+
+	replayMu       sync.Mutex
+	historyReplays map[string]*historyReplay // 频道 -> 进行中的分批历史查询
+
+	sessionToken string            // 会话令牌，未开启会话恢复时为空
+	resumeSeqs   map[string]uint64 // 频道 -> 恢复起点序号，由 channelsMu 保护
+	resume       *session          // 待恢复的会话，由事件循环在注册时恢复
+
+	autoSubscribe []string // 连接参数 channels 请求的频道，由事件循环在注册时订阅
+
+	closeStatus atomic.Pointer[closeStatus] // 第一个记录的关闭码和原因
+
+	control atomic.Bool // 是否订阅了控制频道
+
+	poll *pollTransport // 长轮询传输的状态，WebSocket 连接为 nil（此时 Conn 为 nil）
+
+	// 连接的生命周期：继承握手请求的值，在 readPump 退出或服务器强制关闭时取消，取消后读写循环都会退出
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// 关闭底层连接而不发送关闭帧；长轮询客户端改为取消 context
+func (c *Client) closeConn() {
+	if c.poll != nil {
+		c.cancel()
+		return
+	}
+	c.Conn.Close()
+}
+
+// 连接的 context，连接断开后被取消。钩子中发起的请求可以用它随连接一起取消
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// WebSocket服务器
+type Server struct {
+	clients       map[*Client]bool      // 所有连接的客户端
+	byID          map[string]*Client    // 客户端ID -> 客户端
+	subscriptions *subscriptionRegistry // 频道 -> 客户端映射（分片加锁）
+	patterns      *patternRegistry      // 通配订阅
+	register      chan *Client          // 注册新客户端
+	unregister    chan *Client          // 注销客户端
+	broadcast     chan BroadcastMsg     // 广播消息
+	urgent        chan BroadcastMsg     // 紧急广播，优先于普通广播处理
+	batch         chan []BroadcastMsg   // 批量广播，整批连续处理
+	announce      chan interface{}      // 全服公告，发给所有连接
+	mu            sync.RWMutex          // 读写锁
+	upgrader      websocket.Upgrader    // 按 ServerConfig 构造的升级器
+
+	// 用户ID -> 该用户的所有连接（未认证的连接不在其中），与 clients 一起在 s.mu 下维护
+	byUser map[string]map[*Client]bool
+
+	// 写压缩级别，由 ServerConfig.WriteCompressionLevel 设置，0 为默认
+	writeCompressionLevel int
+
+	// 客户端可协商的缓冲区大小范围，由 ServerConfig.MinBufferSize/MaxBufferSize 设置
+	minBufferSize int
+	maxBufferSize int
+
+	// 压缩阈值：协商了 permessage-deflate 的连接上，小于该字节数的帧不压缩，0 表示默认的 256。
+	// 设为 1 可压缩所有帧
+	CompressionThreshold int
+
+	// 溢出存储（可选）：开启溢出的频道在客户端缓冲区满时暂存消息
+	Overflow         OverflowStore
+	overflowChannels map[string]bool
+
+	// 开启了在线状态事件的频道
+	presenceChannels map[string]bool
+
+	// 开启了聚合在线状态的频道：订阅数的变化每隔 PresenceInterval（0 表示默认的 1 秒）合并推送一次，
+	// 代替逐条的 join/leave，适合订阅者很多的大频道
+	aggregatePresence map[string]bool
+	PresenceInterval  time.Duration
+	presenceCounts    presenceCounts
+
+	// 开启了可靠投递的频道，以及允许的最多未确认消息数（0 表示只在缓冲区满时要求 resync）
+	reliableChannels map[string]bool
+	ReliableMaxLag   uint64
+
+	// 导出状态时需要脱敏的元数据字段
+	RedactKeys []string
+
+	// 每个客户端新建频道的速率限制（每秒个数，0 表示不限制）
+	// 只在订阅一个尚不存在的频道时计数，与订阅数量上限无关
+	ChannelCreateRate  float64
+	ChannelCreateBurst int
+
+	// 每个客户端最多订阅的频道数（含通配订阅，0 表示不限制），超出的订阅返回 403
+	MaxChannelsPerClient int
+
+	// 每个客户端最多持有的通配订阅数（0 表示不限制），超出的订阅返回 403。
+	// 第一段为通配的模式（如 "*.temp"）每次广播都要比较，应保持较小的上限
+	MaxPatternsPerClient int
+
+	// 合并订阅确认：一次订阅多个频道的确认在 results 中列出每个频道的结果，
+	// 连接参数 channels 自动订阅的频道也合并为一条确认，而不是每个频道一条
+	BatchAcks bool
+
+	// 每个客户端的入站消息速率（每秒条数，0 表示不限制）。超限的消息被丢弃并返回 429；
+	// 连续超限 MessageAbuseLimit 条后以 1008 断开连接（0 表示只丢弃不断开）
+	MessageRate       float64
+	MessageBurst      int
+	MessageAbuseLimit int
+
+	// 广播个性化（可选）：为每个订阅者生成不同的 Data，例如按 Metadata 中的语言翻译。
+	// 它在广播循环中对每个订阅者各调用一次并各序列化一次，必须足够快；
+	// 为 nil 时整条广播只序列化一次
+	Personalizer func(client *Client, data interface{}) interface{}
+
+	// 投递时鉴权（可选）：权限可能在会话中途变化，返回 false 的订阅者不会收到这条消息。
+	// 它对每条消息的每个订阅者都调用一次，比订阅时鉴权昂贵得多，只在确实需要时开启
+	DeliveryAuthorizer func(client *Client, channel string, data interface{}) bool
+
+	// 按订阅者过滤（可选）：返回 false 时该订阅者不会收到这条消息，否则收到返回的 data（例如按角色去掉敏感字段）。
+	// data 被所有订阅者共享，需要修改时返回副本。设置后每个订阅者各序列化一次，在 Personalizer 之前调用
+	MessageFilter func(client *Client, channel string, data interface{}) (interface{}, bool)
+
+	panics atomic.Int64 // 事件循环中恢复的panic次数
+
+	// 订阅数阈值：频道订阅数向上或向下穿越阈值时调用 OnChannelThreshold，
+	// 可用于为热门频道提前扩容上游资源。SetChannelThresholds 可按频道覆盖
+	ChannelThresholds  []int
+	OnChannelThreshold func(channel string, count int, rising bool)
+	channelThresholds  map[string][]int
+
+	// 广播到没有订阅者的频道时的处理方式，默认记录日志后丢弃。
+	// EmptyChannelPersist 下每个频道最多暂存 PendingLimit 条（默认 100）
+	EmptyChannelPolicy EmptyChannelPolicy
+	OnUndeliverable    func(msg BroadcastMsg)
+	PendingLimit       int
+
+	// 广播没能投递给某个订阅者时调用（可选）：缓冲区满（可能随后被断开）、降级、全局缓冲超限或可靠频道要求重新同步，
+	// 可用于审计或转存后重试。在事件循环（开启 FanoutWorkers 时在投递 worker）中、释放锁之后调用，应尽快返回
+	OnUndelivered func(clientID string, channel string, data interface{})
+
+	// 发送缓冲区已满时的处理方式，默认断开客户端。SlowClientBlock 最多等待 SlowClientTimeout；
+	// 广播在事件循环中投递，阻塞会拖慢所有广播，超时应设置得很短
+	SlowClientPolicy  SlowClientPolicy
+	SlowClientTimeout time.Duration
+
+	// 广播时缓冲区已满的宽限期（0 表示按 SlowClientPolicy 立即处理）。设置后客户端先被标记为降级，
+	// 期间跳过发给它的频道广播，writePump 继续排空队列；到期后仍在高水位以上才断开，
+	// 短暂的网络抖动不会导致断线。降级期间跳过的消息不会补发
+	SlowClientGrace time.Duration
+
+	// 频道消息在发送队列中的最长存活时间（0 表示不过期）。客户端卡顿恢复后，
+	// writePump 丢弃排队超过该时长的频道消息（如过时的行情），响应等其它消息不受影响
+	MessageTTL time.Duration
+
+	// 每个客户端发送缓冲区的大小（消息条数），0 表示默认的 256，只影响之后建立的连接
+	SendBufferSize int
+
+	// 发送队列越过高水位（缓冲区的 80%）时调用（可选），用于在断开之前发现消费慢的客户端。
+	// 每次越过只触发一次，队列回落到高水位以下后才会再次触发；在单独的 goroutine 中调用
+	OnSlowClient func(client *Client, depth int)
+
+	// 连接最长存活时间（0 表示不限制）。到期后以 CloseRotate 关闭连接，
+	// 客户端应重新连接（可能连到其它实例），用于扩容后重新均衡长连接
+	MaxConnectionLifetime time.Duration
+
+	// 服务器主动 ping 的间隔，以及等待 pong（或任意消息）的超时。
+	// PongWait 应大于 PingInterval，静默断开的连接会在一个心跳周期左右被回收
+	PingInterval time.Duration
+	PongWait     time.Duration
+
+	// 连续多少个 ping 没有得到回应才断开（0 或 1 表示超过 PongWait 即断开）。设置后读超时相应放宽为
+	// PongWait + MaxMissedPongs*PingInterval，收到 pong 或任何消息都清零计数，网络差的移动端不会因为一次丢包被断开
+	MaxMissedPongs int
+
+	// 每次写出消息或 ping 的超时，0 表示默认的 5 秒。客户端不再读取、TCP 窗口写满时，
+	// 写入在超时后失败并断开连接（记为 write timeout），writePump 不会被无限期阻塞
+	WriteTimeout time.Duration
+
+	// 连接数上限（0 表示不限制）：全局总数和单个IP的连接数，超出时升级前返回 503。
+	// 启动后不应再修改
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	limitsMu            sync.Mutex
+	limits              connLimits
+
+	// 超过连接数上限时 503 响应中 Retry-After 建议的等待时间（按秒向上取整），0 表示默认的 5 秒
+	CapacityRetryAfter time.Duration
+
+	// 空闲超时（0 表示不限制）：超过该时长没有收到客户端的任何消息时以 CloseIdle 断开。
+	// 只统计应用消息，pong 不算活动——连接是否存活由 PongWait 负责
+	IdleTimeout time.Duration
+
+	// 单条入站消息的最大字节数，超过时以 CloseMessageTooBig 断开（默认 32KB）
+	MaxMessageSize int64
+
+	// HandleBroadcast 请求体的最大字节数，超过时返回 413（默认 1MB）
+	MaxBroadcastBodySize int64
+
+	// 按客户端ID排序后再投递广播，使多客户端测试中的投递顺序可复现。
+	// 仅用于测试，默认按 map 顺序投递以避免排序开销
+	DeterministicFanout bool
+
+	// 所有客户端发送队列的总字节数上限（0 表示不限制），防止内存耗尽的最后一道保护。
+	// 超限后广播不再发给已有积压的客户端（计入 ShedCount）
+	MaxBufferedBytes int64
+	bufferedBytes    atomic.Int64
+	shed             atomic.Int64
+
+	// 服务器创建时间，用于计算运行时长
+	startedAt time.Time
+
+	// 多实例广播总线（可选），在 Run 之前设置。nodeID 标识本实例，用于忽略自己发布的消息。
+	// 只有 BroadcastToChannel、BroadcastWithCorrelation、BroadcastToChannelExcept 和客户端发布会经过总线，
+	// 紧急、批量、抽样广播和全服公告只在本实例投递
+	Backplane Backplane
+	nodeID    string
+
+	// 生成客户端ID，默认为 UUID。多实例部署时可以把节点信息编码进ID（如 "node3-42"）便于路由，
+	// 测试中可以注入计数器得到确定的ID。ID 必须唯一（由生成函数保证），并且可以安全地用作文件名（溢出存储）
+	IDGenerator func() string
+
+	// 结构化日志，默认为 slog.Default()。连接、断开等事件为 Info，解析失败、订阅等高频事件为 Debug，
+	// 每条日志带 event 字段以及相关的 client_id、channel
+	Logger *slog.Logger
+
+	// 生命周期钩子（均可选）。钩子运行在该连接自己的 goroutine 中（OnConnect 在握手处理中，
+	// 其余在 readPump 中），会阻塞该连接的读取，耗时操作应另起 goroutine 处理。
+	// OnConnect 在注册成功后调用；OnDisconnect 在连接断开、readPump 退出时调用，带上关闭码和原因（见 Client.CloseStatus）；
+	// OnMessage 在处理每条解析后的消息前调用，返回 false 时跳过默认处理
+	OnConnect    func(client *Client)
+	OnDisconnect func(client *Client, code int, reason string)
+	OnMessage    func(client *Client, msg *Message) bool
+
+	// 连接的读写循环或钩子发生 panic 时调用（可选），用于上报错误。panic 总会被恢复并记录日志，
+	// 出错的连接以 1011 关闭，服务器和其它连接不受影响
+	OnPanic func(client *Client, recovered interface{})
+
+	// 连接认证（可选）：升级前调用，返回错误时响应 401 且不升级，
+	// 返回的用户ID记录在 Client.UserID 上。未设置时不做认证
+	Authenticator func(r *http.Request) (userID string, err error)
+
+	// 连接授权（可选）：认证通过后、升级前调用，由服务器决定连接的权限（如把监控账号的连接设为只读）。
+	// 返回错误时响应 403 且不升级。长轮询恢复会话时同样调用
+	Authorizer func(r *http.Request, userID string) (ConnectionGrant, error)
+
+	// 管理接口授权（可选）：每个管理请求都会调用，返回 false 时响应 403。
+	// 它与 Authenticator 无关——通过了终端用户认证不代表可以管理其他连接。未设置时管理接口一律拒绝
+	AdminAuthorizer func(r *http.Request) bool
+
+	// Handler 包在 WebSocket 端点外的中间件，由 Use 添加
+	middleware []Middleware
+
+	// 支持的子协议（按优先级）。客户端请求了子协议但都不支持时调用
+	// OnUnsupportedSubprotocol，未设置则返回 400 及支持的协议列表
+	Subprotocols             []string
+	OnUnsupportedSubprotocol func(w http.ResponseWriter, r *http.Request, requested []string)
+
+	// 每个发布者在单个频道上的发布速率（每秒条数，0 表示不限制），
+	// 可用 SetChannelPublishRate 按频道覆盖。超限的发布返回 429
+	PublishRate         float64
+	PublishBurst        int
+	channelPublishRates map[string]rateConfig
+
+	// 单个频道每秒最多广播的消息数（0 表示不限制），与发布者是谁无关，保护订阅者不被失控的频道刷屏。
+	// 可用 SetChannelRate 按频道覆盖。超限的广播在事件循环中丢弃并计入 ChannelRateDrops，紧急广播不受限制
+	ChannelRate    float64
+	ChannelBurst   int
+	channelRates   map[string]rateConfig
+	channelBuckets map[string]*tokenBucket // 只在事件循环中访问
+
+	// 投递 worker 数（0 表示在事件循环中串行投递）。开启后每个频道有自己的队列，由空闲的 worker 轮流投递，
+	// 大频道或慢频道的扇出不再阻塞其它频道；注册表仍只由事件循环修改。
+	// 排队等待投递的广播总数上限为 FanoutWorkers × FanoutQueueSize（FanoutQueueSize 默认 256）
+	FanoutWorkers   int
+	FanoutQueueSize int
+	fanoutPool      *fanoutPool // Run 启动时创建
+
+	// 发布授权（可选）：返回 false 时拒绝客户端向该频道发布（403）。
+	// 运行在发布者的 readPump 中
+	CanPublish func(client *Client, channel string) bool
+
+	// 命名空间分隔符（如 ":"），为空时不区分命名空间。开启后通配订阅只匹配同一命名空间的频道，
+	// 可用 SetNamespace 为命名空间设置频道数、订阅数上限和授权
+	NamespaceSeparator string
+	namespaces         namespaceRegistry
+
+	// 订阅授权（可选）：返回 false 时拒绝客户端订阅该频道（403），未设置时允许所有订阅。
+	// 在获取锁之前调用，可结合 UserID 和 Attributes 实现按角色的访问控制；通配订阅以模式本身传入，
+	// 广播时再对模式匹配到的具体频道逐个调用，未通过的通配订阅者收不到该频道的消息。
+	// 恢复会话时在事件循环中调用，不应阻塞
+	CanSubscribe func(client *Client, channel string) bool
+
+	// 控制频道授权（可选）：返回 true 的客户端可以订阅 ControlChannel 并发布管理命令，
+	// 订阅和每条命令都会调用。未设置时控制频道关闭，所有操作返回 403
+	ControlAuthorizer func(client *Client) bool
+
+	// 订阅快照（可选）：客户端订阅成功后调用，返回 true 时把频道当前状态作为 action 为 "snapshot" 的消息，
+	// 紧跟在订阅确认之后、任何后续广播之前发送。调用时持有该频道的分片锁，
+	// 期间该频道的广播会等待，应尽快返回，不能在其中订阅或广播
+	SnapshotProvider func(channel string, client *Client) (interface{}, bool)
+
+	// 消息吞吐计数，由 /metrics 导出
+	Metrics Metrics
+
+	// /metrics 中按该元数据键（如 "query.region"）分组导出连接数，
+	// 不同取值最多 MetricsLabelLimit 个（默认 20），其余归入 "other"
+	MetricsLabelKey   string
+	MetricsLabelLimit int
+	metricsMu         sync.Mutex
+	labelValues       map[string]bool
+
+	// 频道历史：每个频道保留最近 HistorySize 条广播（0 表示关闭，可用 SetChannelHistorySize 按频道覆盖），
+	// 订阅时可用 since 回放或用 history 操作查询，回放范围不超过 HistoryRetention（0 表示只受条数限制）
+	HistorySize         int
+	HistoryRetention    time.Duration
+	historyMu           sync.Mutex
+	history             map[string]*historyRing
+	channelHistorySizes map[string]int
+	channelSeq          map[string]uint64
+
+	// history 查询每批返回的条数（0 表示一次返回全部）。开启后客户端每收到一批发送 history_ack 取下一批，
+	// 全部发完后收到 history_complete
+	HistoryBatchSize int
+
+	// 空闲频道回收（0 表示关闭）：没有订阅者的频道在最后一次广播或最后一个订阅者离开 ChannelTTL 之后，
+	// 由事件循环每隔 ChannelSweepInterval（默认 1 分钟）回收序号、历史、消息计数和限流状态
+	ChannelTTL           time.Duration
+	ChannelSweepInterval time.Duration
+	sweepTick            <-chan time.Time // Run 启动时创建，只在事件循环中读取
+
+	// 长轮询等待者：频道 -> 等待中的请求
+	pollWaiters map[string]map[chan Response]bool
+
+	// 会话令牌 -> 通过长轮询传输恢复的客户端，由 mu 保护。
+	// 两次轮询之间超过 PollIdleTimeout 没有请求时断开并重新保存会话，0 表示使用默认值（30s）
+	pollClients     map[string]*Client
+	PollIdleTimeout time.Duration
+
+	// 会话保留时长（0 表示关闭会话恢复）。开启后连接确认中带有 sessionToken，
+	// 断开后会话保留 SessionTTL，期间携带 ?session=<token> 重连可恢复订阅并补发错过的消息
+	SessionTTL time.Duration
+	sessions   sessionStore
+
+	// 入站消息校验限制，0 表示使用默认值（64 / 256 / 32）
+	MaxActionLength  int
+	MaxChannelLength int
+	MaxDataDepth     int
+
+	// 客户端给出的频道名先经 ChannelNormalizer 规范化（可选，如去掉空白、转小写），
+	// 再经 ChannelValidator 校验，返回错误时以 CodeInvalidChannel 拒绝。
+	// 未设置 ChannelValidator 时只允许字母、数字和 "-_.:@/*"，长度不超过 MaxChannelLength
+	ChannelValidator  func(name string) error
+	ChannelNormalizer func(name string) string
+
+	// 排空模式：拒绝新连接（503），已有连接继续服务。
+	// draining 由本实例设置（SetDraining、AnnounceShutdown），clusterDraining 来自集群排空标志，两者互不覆盖
+	draining        atomic.Bool
+	clusterDraining atomic.Bool
+
+	// 维护模式（SetMaintenance）：拒绝订阅和发布，已有连接继续服务。
+	// MaintenanceRejectConnections 为 true 时同时拒绝新连接，MaintenanceAnnounce 为 true 时切换时发送全服公告
+	maintenance                  atomic.Pointer[maintenanceState]
+	MaintenanceRejectConnections bool
+	MaintenanceAnnounce          bool
+
+	// 优雅关闭时关闭帧中的原因（可为空）
+	ShutdownReason string
+	closing        atomic.Bool    // 已开始关闭，拒绝新连接
+	running        atomic.Bool    // Run 已启动，之前的连接请求会被拒绝
+	shutdown       chan []byte    // 关闭请求，携带关闭帧
+	done           chan struct{}  // 事件循环已退出
+	closed         []*Client      // 关闭时注销的客户端，超时后强制断开
+	writers        sync.WaitGroup // 运行中的 writePump
+
+	// 所有连接 context 的父级，强制关闭时取消
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	// 抽样广播的随机种子，非 0 时按客户端ID排序后用固定种子抽样，结果可复现（用于测试）
+	SampleSeed int64
+	rng        *rand.Rand
+	rngMu      sync.Mutex // 开启投递 worker 时抽样可能并发进行
+
+	// 每成功写出一帧后调用（可选），用于构建按帧统计的自定义指标。
+	// 运行在该连接的 writePump 中，耗时操作会直接拖慢该连接的发送
+	OnFrameWritten func(client *Client, info FrameInfo)
+
+	// 调试协议问题时记录每一条收到和写出的数据帧（Info 级别，带客户端ID和方向），默认关闭。
+	// 每帧最多记录 FrameDebugMaxLen 字节（默认 256）；二进制帧只记录长度，FrameDebugHex 为 true 时附带十六进制内容
+	FrameDebug       bool
+	FrameDebugMaxLen int
+	FrameDebugHex    bool
+
+	// 批量模式：开启了 set_batching 的客户端，writePump 把积压的频道消息最多 BatchSize 条合并为一个 JSON 数组帧，
+	// 不足时最多等待 FlushInterval 凑批（0 表示只合并已在队列中的）。BatchSize 不大于 1 时关闭
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// 收到二进制帧时调用（可选），data 由应用自行解码（如 protobuf/msgpack）。
+	// 未设置时二进制帧收到 415 错误响应。运行在该连接的 readPump 中
+	OnBinaryMessage func(client *Client, data []byte)
+
+	// 原始帧钩子（可选），在解码之前对每个文本/二进制帧调用，用于自定义协议（如带长度前缀的二进制控制帧）。
+	// 返回 true 表示已处理，跳过默认的解码、限流和消息处理；返回 false 时照常处理。运行在该连接的 readPump 中
+	RawMessageHandler func(client *Client, messageType int, payload []byte) bool
+
+	// 按子协议注册的入站消息解码器，见 RegisterDecoder
+	decoders map[string]MessageDecoder
+
+	// 响应和广播的编解码器，也用于解码入站消息，默认 JSONCodec（文本帧）。
+	// 设为 MsgpackCodec 时所有下发消息都是 MessagePack 二进制帧。应在启动前设置
+	Codec Codec
+
+	// 服务器内部事件的观察者，见 Events
+	events eventHub
+
+	// 频道监听者，见 Tap
+	taps tapHub
+
+	// 交给应用处理的入站消息流，见 Messages。RouteAllMessages 为 true 时内置处理的消息也送一份
+	messageStream       messageStream
+	MessageStreamBuffer int
+	RouteAllMessages    bool
+
+	// 按 action 注册的 Data 校验器，见 SetActionValidator
+	actionValidators map[string]func(data interface{}) error
+
+	// 按 action 注册的处理函数，见 HandleAction
+	actionHandlers map[string]func(*Client, *Message)
+
+	// 序列化失败时调用（可选）。Data 中含有无法序列化的类型（channel、func 等）时会触发，
+	// 失败的消息不会发送，同时记录日志并计入 SerializationErrors
+	OnSerializationError func(err error, v interface{})
+	serializationErrors  atomic.Int64
+
+	// 出站拦截（可选）：每条发给客户端的响应（广播、回复、回放、私信等）序列化之前调用，可以改写 resp，
+	// 返回 false 时这条消息不发给该客户端（计入 Metrics.OutboundBlocked）。按消息、按客户端调用，必须很快；
+	// 设置后广播不再只序列化一次。resp.Data 可能被多个客户端共享，改写时应替换而不是原地修改
+	OutboundInterceptor func(client *Client, resp *Response) bool
+
+	channelCounters channelCounters // 按频道的消息计数
+}
+
+type BroadcastMsg struct {
+	Channel       string
+	Data          interface{}
+	CorrelationID string // 关联ID，随每个投递的 Response 下发，便于端到端追踪
+	Except        string // 不投递给该客户端ID（通常是发布者自己），为空则发给全部订阅者
+
+	sample float64             // 抽样比例，0 表示发给全部订阅者
+	result chan deliveryResult // 同步广播的结果
+}
+
+// 一次广播在本实例上的投递结果
+type deliveryResult struct {
+	delivered int // 成功放入发送队列的订阅者数
+	skipped   int // 因缓冲区满、降级、全局缓冲超限等没有收到的订阅者数
+}
+
+// Authorizer 给出的连接权限
+type ConnectionGrant struct {
+	ReadOnly bool // 只读连接，客户端的 ?readonly 参数只能收紧、不能解除
+}
+
+// 创建新服务器
+func NewServer(config ServerConfig) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		ctx:       ctx,
+		cancelCtx: cancel,
+
+		upgrader:              newUpgrader(config),
+		writeCompressionLevel: config.WriteCompressionLevel,
+		minBufferSize:         config.MinBufferSize,
+		maxBufferSize:         config.MaxBufferSize,
+
+		clients:       make(map[*Client]bool),
+		byID:          make(map[string]*Client),
+		byUser:        make(map[string]map[*Client]bool),
+		subscriptions: newSubscriptionRegistry(),
+		patterns:      newPatternRegistry(),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		broadcast:     make(chan BroadcastMsg),
+		urgent:        make(chan BroadcastMsg),
+		batch:         make(chan []BroadcastMsg),
+		announce:      make(chan interface{}),
+		shutdown:      make(chan []byte),
+		done:          make(chan struct{}),
+
+		overflowChannels:  make(map[string]bool),
+		presenceChannels:  make(map[string]bool),
+		aggregatePresence: make(map[string]bool),
+		reliableChannels:  make(map[string]bool),
+		channelThresholds: make(map[string][]int),
+
+		channelPublishRates: make(map[string]rateConfig),
+		channelRates:        make(map[string]rateConfig),
+		channelBuckets:      make(map[string]*tokenBucket),
+		labelValues:         make(map[string]bool),
+		history:             make(map[string]*historyRing),
+		channelHistorySizes: make(map[string]int),
+		channelSeq:          make(map[string]uint64),
+		pollWaiters:         make(map[string]map[chan Response]bool),
+		pollClients:         make(map[string]*Client),
+		sessions:            sessionStore{sessions: make(map[string]*session)},
+		events:              eventHub{observers: make(map[chan Event]bool)},
+		taps:                tapHub{taps: make(map[string]map[chan Response]bool)},
+
+		channelCounters: channelCounters{counters: make(map[string]*channelCounter)},
+		limits:          connLimits{perIP: make(map[string]int)},
+		decoders:        make(map[string]MessageDecoder),
+		Subprotocols:    config.Subprotocols,
+
+		actionValidators: make(map[string]func(data interface{}) error),
+
+		startedAt:      time.Now(),
+		nodeID:         uuid.New().String(),
+		IDGenerator:    func() string { return uuid.New().String() },
+		Logger:         slog.Default(),
+		PingInterval:   defaultPingInterval,
+		PongWait:       defaultPongWait,
+		MaxMessageSize: defaultMaxMessageSize,
+	}
+	s.actionHandlers = s.builtinActions()
+	return s
+}
+
+// 运行服务器
+func (s *Server) Run() {
+	s.running.Store(true)
+	if s.Backplane != nil {
+		go s.runBackplane()
+	}
+	go s.runAggregatePresence()
+	s.startFanoutWorkers()
+	if s.ChannelTTL > 0 {
+		ticker := time.NewTicker(s.channelSweepInterval())
+		defer ticker.Stop()
+		s.sweepTick = ticker.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		s.runOnce()
+	}
+}
+
+// 注销客户端并关闭其发送通道。所有断开（包括广播时踢掉慢客户端）都走这里，
+// 重复调用是安全的，Send 只会被关闭一次。只能在事件循环中调用
+func (s *Server) removeClient(client *Client) {
+	var crossings []thresholdCrossing
+	s.mu.Lock()
+	if _, ok := s.clients[client]; !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.clients, client)
+	delete(s.byID, client.ID)
+	if client.poll != nil && s.pollClients[client.sessionToken] == client {
+		delete(s.pollClients, client.sessionToken)
+	}
+	if conns := s.byUser[client.UserID]; conns != nil {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(s.byUser, client.UserID)
+		}
+	}
+	s.releaseConnection(client.remoteIP)
+	close(client.Send)
+	close(client.removed)
+	s.releaseAccount(client)
+	if client.lifetimeTimer != nil {
+		client.lifetimeTimer.Stop()
+	}
+	if client.idleTimer != nil {
+		client.idleTimer.Stop()
+	}
+	// 保存会话后再移除订阅
+	s.saveSession(client)
+	for _, channel := range client.channelList() {
+		changed, _ := s.removeSubscription(client, channel)
+		crossings = append(crossings, changed...)
+	}
+	if s.Overflow != nil {
+		s.Overflow.Remove(client.ID)
+	}
+	s.mu.Unlock()
+	s.fireThresholds(crossings)
+	code, reason := client.CloseStatus()
+	s.Logger.Info("客户端已断开", "event", "disconnect", "client_id", client.ID, "connections", len(s.clients), "close_code", code, "close_reason", reason)
+	s.emit(EventDisconnect, client, "")
+}
+
+// 处理一个事件；用户钩子 panic 时记录并恢复，避免整个事件循环退出
+func (s *Server) runOnce() {
+	defer func() {
+		if r := recover(); r != nil {
+			s.panics.Add(1)
+			s.Logger.Error("事件循环发生panic，已恢复", "event", "panic", "panic", r)
+		}
+	}()
+
+	// 紧急广播优先于所有其它事件
+	select {
+	case msg := <-s.urgent:
+		s.deliverUrgent(msg)
+		return
+	default:
+	}
+
+	select {
+	case client := <-s.register:
+		defer close(client.registered)
+		s.mu.Lock()
+		s.clients[client] = true
+		s.byID[client.ID] = client
+		if client.UserID != "" {
+			if s.byUser[client.UserID] == nil {
+				s.byUser[client.UserID] = make(map[*Client]bool)
+			}
+			s.byUser[client.UserID][client] = true
+		}
+		s.mu.Unlock()
+		if s.MaxConnectionLifetime > 0 {
+			client.lifetimeTimer = time.AfterFunc(s.MaxConnectionLifetime, func() {
+				s.Logger.Info("客户端达到最长存活时间，关闭连接", "event", "rotate", "client_id", client.ID)
+				s.closeClient(client, CloseRotate, "rotate")
+			})
+		}
+		s.Logger.Info("客户端已连接", "event", "connect", "client_id", client.ID, "connections", len(s.clients), "compression", client.compressionNegotiated)
+		s.emit(EventConnect, client, "")
+		if client.resume != nil {
+			s.restoreSession(client, client.resume)
+			client.resume = nil
+		}
+		// 与客户端发送的 subscribe 一样经过授权和频道数上限检查，每个频道一条确认（BatchAcks 时合并为一条）
+		switch {
+		case len(client.autoSubscribe) == 0:
+		case s.rejectInMaintenance(client, "subscribe", "", ""):
+			// 维护模式下自动订阅整体拒绝，只回复一次
+		case s.BatchAcks:
+			s.autoSubscribeChannels(client, client.autoSubscribe)
+		default:
+			for _, channel := range client.autoSubscribe {
+				s.autoSubscribeChannel(client, channel)
+			}
+		}
+		client.autoSubscribe = nil
+
+	case client := <-s.unregister:
+		s.removeClient(client)
+
+	case msg := <-s.urgent:
+		s.deliverUrgent(msg)
+
+	case msg := <-s.broadcast:
+		s.deliverLimited(msg)
+
+	case msgs := <-s.batch:
+		for _, msg := range msgs {
+			s.deliverLimited(msg)
+		}
+
+	case data := <-s.announce:
+		s.deliverAnnouncement(data)
+
+	case message := <-s.shutdown:
+		s.shutdownClients(message)
+
+	case <-s.sweepTick:
+		s.sweepChannels()
+	}
+}
+
+// 把广播消息投递给频道的所有订阅者，inWorker 表示运行在投递 worker 而不是事件循环中
+func (s *Server) deliverBroadcast(msg BroadcastMsg, inWorker bool) {
+	var result deliveryResult
+	// 同步调用方等待结果；即使投递过程中 panic 也要回复，避免调用方永久阻塞
+	if msg.result != nil {
+		defer func() { msg.result <- result }()
+	}
+	s.Metrics.MessagesBroadcast.Add(1)
+	result = s.fanout(msg, inWorker)
+}
+
+// 按频道限流后投递广播：超过 ChannelRate 的消息直接丢弃，同步调用方得到空的投递结果。
+// 开启投递 worker 时放入频道的投递队列
+func (s *Server) deliverLimited(msg BroadcastMsg) {
+	if !s.allowBroadcast(msg.Channel) {
+		s.Metrics.ChannelRateDrops.Add(1)
+		s.Logger.Debug("频道广播超过速率限制，已丢弃", "event", "channel_rate_drop", "channel", msg.Channel)
+		if msg.result != nil {
+			msg.result <- deliveryResult{}
+		}
+		return
+	}
+	if s.fanoutPool != nil {
+		s.dispatchFanout(msg)
+		return
+	}
+	s.deliverBroadcast(msg, false)
+}
+
+// 投递广播，返回成功放入发送队列和没能放入的订阅者数量。
+// 被 Except 排除、DeliveryAuthorizer 拒绝或 MessageFilter 过滤掉的订阅者两者都不计
+func (s *Server) fanout(msg BroadcastMsg, inWorker bool) deliveryResult {
+	sampled := msg.sample > 0
+	response := Response{
+		Action:        "message",
+		Channel:       msg.Channel,
+		Code:          CodeSuccess,
+		Msg:           "success",
+		Data:          msg.Data,
+		CorrelationID: msg.CorrelationID,
+		SentAt:        time.Now().UnixMilli(),
+	}
+	// 抽样广播只发给部分订阅者，不进入频道序号、历史和长轮询
+	if !sampled {
+		response.Seq = s.nextSeq(msg.Channel)
+		s.channelCounters.record(msg.Channel)
+	}
+
+	s.notifyTaps(response)
+	s.mu.RLock()
+	if !sampled {
+		s.notifyPollers(response)
+	}
+	// 复制订阅列表，避免长时间持有锁；抽样广播不记录历史和保留消息
+	var exact []*Client
+	if sampled {
+		exact = s.subscriptions.snapshot(msg.Channel)
+	} else {
+		exact = s.recordAndSnapshot(msg.Channel, response)
+	}
+	matched := s.patterns.match(msg.Channel, s.NamespaceSeparator)
+	overflow := s.overflowEnabled(msg.Channel)
+	reliable := !sampled && s.reliableChannels[msg.Channel]
+	s.mu.RUnlock()
+	clients := mergeSubscribers(exact, s.authorizeMatched(matched, msg.Channel))
+
+	if len(clients) == 0 {
+		if sampled {
+			return deliveryResult{}
+		}
+		if clients = s.handleEmptyChannel(msg); len(clients) == 0 {
+			return deliveryResult{}
+		}
+	}
+
+	if s.DeterministicFanout {
+		sortClientsByID(clients)
+	}
+	if sampled {
+		clients = s.sampleClients(clients, msg.sample)
+	}
+	sortClientsByPriority(clients)
+
+	// 发送消息给所有订阅者，缓冲区满的慢客户端记下来统一断开
+	delivered := 0
+	var slow, undelivered []*Client
+	data, ok := s.marshal(response)
+	if !ok {
+		return deliveryResult{}
+	}
+	for _, client := range clients {
+		if msg.Except != "" && client.ID == msg.Except {
+			continue
+		}
+		// 投递时重新鉴权，未通过的订阅者跳过这条消息
+		if s.DeliveryAuthorizer != nil && !s.DeliveryAuthorizer(client, msg.Channel, msg.Data) {
+			continue
+		}
+		// 过滤、个性化和出站拦截：每个订阅者单独生成并序列化消息，都没有设置时沿用上面只序列化一次的结果
+		if s.MessageFilter != nil || s.Personalizer != nil || s.OutboundInterceptor != nil {
+			payload := msg.Data
+			if s.MessageFilter != nil {
+				filtered, keep := s.MessageFilter(client, msg.Channel, msg.Data)
+				if !keep {
+					continue
+				}
+				payload = filtered
+			}
+			if s.Personalizer != nil {
+				payload = s.Personalizer(client, payload)
+			}
+			outgoing := response
+			outgoing.Data = payload
+			if !s.intercept(client, &outgoing) {
+				continue
+			}
+			if data, ok = s.marshal(outgoing); !ok {
+				undelivered = append(undelivered, client)
+				continue
+			}
+		}
+		// 降级客户端在宽限期内不再投递，让它先排空队列
+		if client.Degraded() {
+			s.Metrics.SlowDrops.Add(1)
+			undelivered = append(undelivered, client)
+			continue
+		}
+		// 全局缓冲超限，丢弃发给积压客户端的消息
+		if s.shouldShed(client, len(data)) {
+			s.shed.Add(1)
+			undelivered = append(undelivered, client)
+			continue
+		}
+		frame := s.frame(data)
+		frame.Channel = msg.Channel
+		var sent, evict bool
+		if inWorker {
+			// 不在事件循环中时持有读锁并确认客户端仍已注册，保证 Send 没有被关闭
+			s.mu.RLock()
+			if !s.clients[client] {
+				s.mu.RUnlock()
+				continue
+			}
+			sent, evict = s.sendBroadcastFrame(client, frame, response.Seq, reliable, overflow)
+			s.mu.RUnlock()
+		} else {
+			sent, evict = s.sendBroadcastFrame(client, frame, response.Seq, reliable, overflow)
+		}
+		if sent {
+			delivered++
+			continue
+		}
+		if evict {
+			slow = append(slow, client)
+		}
+		undelivered = append(undelivered, client)
+	}
+	for _, client := range slow {
+		s.Metrics.SlowEvictions.Add(1)
+		if inWorker {
+			// worker 不修改注册表，关闭连接后由连接自己的 pump 注销
+			client.closeConn()
+			continue
+		}
+		// fanout 运行在事件循环中，不能再向 s.unregister 发送，直接注销
+		s.removeClient(client)
+	}
+	// 投递失败的订阅者交给 OnUndelivered，此时已不持有任何锁
+	if s.OnUndelivered != nil {
+		for _, client := range undelivered {
+			s.OnUndelivered(client.ID, msg.Channel, msg.Data)
+		}
+	}
+	s.Logger.Debug("广播消息", "event", "broadcast", "channel", msg.Channel, "subscribers", len(clients), "correlation_id", msg.CorrelationID)
+	return deliveryResult{delivered: delivered, skipped: len(undelivered)}
+}
+
+// 把一帧广播放入订阅者的发送队列。没有放入时 evict 表示应断开该订阅者
+func (s *Server) sendBroadcastFrame(client *Client, frame OutboundMessage, seq uint64, reliable, overflow bool) (sent, evict bool) {
+	// 可靠频道不断开慢客户端，改为要求重新同步
+	if reliable {
+		return s.sendReliable(client, frame.Channel, seq, frame), false
+	}
+	if overflow {
+		sent = s.sendOrSpill(client, frame)
+		return sent, !sent
+	}
+	if s.enqueue(client, frame) {
+		return true, false
+	}
+	// 发送失败：有宽限期时降级，否则投递结束后断开
+	return false, !s.degrade(client)
+}
+
+func sortClientsByID(clients []*Client) {
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+}
+
+// 用部分 Fisher-Yates 洗牌选出 round(fraction*n) 个订阅者
+func (s *Server) sampleClients(clients []*Client, fraction float64) []*Client {
+	s.rngMu.Lock()
+	defer s.rngMu.Unlock()
+	if s.rng == nil {
+		seed := s.SampleSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		s.rng = rand.New(rand.NewSource(seed))
+	}
+	if s.SampleSeed != 0 {
+		sortClientsByID(clients)
+	}
+
+	k := int(math.Round(fraction * float64(len(clients))))
+	for i := 0; i < k; i++ {
+		j := i + s.rng.Intn(len(clients)-i)
+		clients[i], clients[j] = clients[j], clients[i]
+	}
+	return clients[:k]
+}
+
+// 发送关闭帧并关闭底层连接，readPump 随后退出并注销客户端。
+// 长轮询客户端没有连接可关，取消它的 context，由 pollPump 注销
+func (s *Server) closeClient(client *Client, code int, reason string) {
+	client.recordClose(code, reason)
+	if client.poll != nil {
+		client.cancel()
+		return
+	}
+	message := websocket.FormatCloseMessage(code, reason)
+	client.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeWriteWait))
+	client.Conn.Close()
+}
+
+// 进入或退出排空模式
+func (s *Server) SetDraining(on bool) {
+	s.draining.Store(on)
+}
+
+// 是否处于排空模式（本实例设置的或集群排空标志）
+func (s *Server) Draining() bool {
+	return s.draining.Load() || s.clusterDraining.Load()
+}
+
+// 按 Server.Codec 序列化消息；失败时记录日志、计数并调用 OnSerializationError
+func (s *Server) marshal(v interface{}) ([]byte, bool) {
+	data, err := s.codec().Marshal(v)
+	if err != nil {
+		s.serializationErrors.Add(1)
+		s.Logger.Error("消息序列化失败", "event", "serialize_error", "error", err)
+		if s.OnSerializationError != nil {
+			s.OnSerializationError(err, v)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+// 交给 OutboundInterceptor 检查并改写，返回 false 表示不发送
+func (s *Server) intercept(client *Client, response *Response) bool {
+	if s.OutboundInterceptor == nil || s.OutboundInterceptor(client, response) {
+		return true
+	}
+	s.Metrics.OutboundBlocked.Add(1)
+	return false
+}
+
+// 序列化发给单个客户端的响应，被 OutboundInterceptor 拦截或序列化失败时 ok 为 false
+func (s *Server) marshalFor(client *Client, response Response) ([]byte, bool) {
+	if !s.intercept(client, &response) {
+		return nil, false
+	}
+	return s.marshal(response)
+}
+
+// 发给多个客户端的同一条响应：没有 OutboundInterceptor 时直接沿用已序列化的 data，否则为该客户端单独序列化
+func (s *Server) sharedFrame(client *Client, response Response, data []byte) (OutboundMessage, bool) {
+	if s.OutboundInterceptor == nil {
+		return s.frame(data), true
+	}
+	data, ok := s.marshalFor(client, response)
+	return s.frame(data), ok
+}
+
+// 序列化并发送响应给客户端。持有 s.mu 时也可以调用（SlowClientBlock 下最多阻塞 SlowClientTimeout）：
+// 按 SlowClientPolicy 处理后仍放不进缓冲区，说明客户端消费太慢，直接关闭连接，由 readPump 随后注销
+func (s *Server) sendResponse(client *Client, response Response) {
+	data, ok := s.marshalFor(client, response)
+	if !ok {
+		return
+	}
+	if !s.enqueue(client, s.frame(data)) {
+		s.Logger.Warn("发送缓冲区已满，断开连接", "event", "slow_client", "client_id", client.ID)
+		s.Metrics.SlowEvictions.Add(1)
+		client.closeConn()
+	}
+}
+
+// 序列化失败的次数
+func (s *Server) SerializationErrors() int64 {
+	return s.serializationErrors.Load()
+}
+
+// 事件循环中恢复的panic次数
+func (s *Server) PanicCount() int64 {
+	return s.panics.Load()
+}
+
+// 客户端流量统计
+func (c *Client) Traffic() TrafficStats {
+	return c.counters.snapshot()
+}
+
+// 事件循环还没有启动时注册永远不会完成，升级前以 503 拒绝，而不是升级后等到注册超时
+func (s *Server) rejectNotRunning(w http.ResponseWriter) bool {
+	if s.running.Load() {
+		return false
+	}
+	s.Logger.Error("事件循环没有运行，拒绝连接", "event", "not_running")
+	http.Error(w, "Server is not running", http.StatusServiceUnavailable)
+	return true
+}
+
+// 处理WebSocket连接
+func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// 排空模式或正在关闭时不接受新连接
+	if s.Draining() || s.closing.Load() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+	if s.rejectNotRunning(w) {
+		return
+	}
+	if s.rejectConnectionInMaintenance(w) {
+		return
+	}
+
+	// 来源不在白名单中，升级前拒绝
+	if !s.upgrader.CheckOrigin(r) {
+		s.Logger.Warn("拒绝来源不在白名单中的连接", "event", "origin_rejected", "origin", r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// 认证和授权
+	userID, grant, ok := s.authorizeConnection(w, r)
+	if !ok {
+		return
+	}
+
+	// 子协议协商
+	requested := websocket.Subprotocols(r)
+	protocol, ok := s.negotiateSubprotocol(requested)
+	if !ok {
+		if s.OnUnsupportedSubprotocol != nil {
+			s.OnUnsupportedSubprotocol(w, r, requested)
+		} else {
+			s.rejectSubprotocol(w, requested)
+		}
+		s.Logger.Warn("不支持的子协议", "event", "subprotocol_rejected", "requested", requested)
+		return
+	}
+	var header http.Header
+	if protocol != "" {
+		header = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+
+	// 预留连接槽位，注销时释放
+	ip := remoteIP(r)
+	if !s.reserveConnection(ip) {
+		s.rejectAtCapacity(w, ip)
+		return
+	}
+
+	// 升级HTTP连接为WebSocket，缓冲区大小可由客户端在允许的范围内指定
+	// 握手响应中的 Sec-WebSocket-Extensions 说明实际是否协商出了压缩
+	counters := &connCounters{}
+	upgrader := s.upgraderFor(r)
+	var compressed bool
+	writer := &countingResponseWriter{
+		ResponseWriter: w,
+		counters:       counters,
+		handshake:      func(response []byte) { compressed = deflateAccepted(response) },
+	}
+	conn, err := upgrader.Upgrade(writer, r, header)
+	if err != nil {
+		s.releaseConnection(ip)
+		s.Logger.Warn("WebSocket升级失败", "event", "upgrade_failed", "error", err)
+		return
+	}
+
+	// 创建客户端
+	client := s.newClient(r, userID, grant, ip, counters)
+	client.Conn = conn
+	client.Subprotocol = protocol
+	client.readBufferSize = upgrader.ReadBufferSize
+	client.writeBufferSize = upgrader.WriteBufferSize
+	client.compressionNegotiated = compressed
+
+	// 会话恢复：令牌有效时沿用它，否则（未携带、已过期或属于其他用户）按新连接处理并发放新令牌
+	if s.SessionTTL > 0 {
+		token := r.URL.Query().Get("session")
+		if token != "" {
+			client.resume = s.takeSession(token, userID)
+		}
+		if client.resume != nil {
+			client.sessionToken = token
+		} else {
+			client.sessionToken = newSessionToken()
+		}
+	}
+
+	// 自动订阅：?channels=room1,room2，省去连接后再逐个发送 subscribe
+	client.autoSubscribe = parseChannelList(r.URL.Query().Get("channels"))
+
+	if !s.admit(client) {
+		return
+	}
+
+	// 启动goroutine处理读写
+	go s.writePump(client)
+	go s.readPump(client)
+}
+
+// 创建客户端并初始化与传输方式无关的状态；Conn、子协议等握手结果由调用方填写。
+// 服务器授予的只读不能被客户端的 ?readonly 参数解除
+func (s *Server) newClient(r *http.Request, userID string, grant ConnectionGrant, ip string, counters *connCounters) *Client {
+	client := &Client{
+		ID:       s.IDGenerator(),
+		Send:     make(chan OutboundMessage, s.sendBufferSize()),
+		Channels: make(map[string]bool),
+		Metadata: connectionMetadata(r),
+		ReadOnly: grant.ReadOnly || isTruthy(r.URL.Query().Get("readonly")),
+		UserID:   userID,
+
+		remoteIP: ip,
+
+		connectedAt:     time.Now(),
+		counters:        counters,
+		publishLimiters: make(map[string]*tokenBucket),
+		resumed:         make(chan struct{}, 1),
+		removed:         make(chan struct{}),
+		registered:      make(chan struct{}),
+
+		compressPrefs: make(map[string]bool),
+		reliable:      reliableTracker{channels: make(map[string]*reliableState)},
+		resumeSeqs:    make(map[string]uint64),
+	}
+	client.lastSeen.Store(client.connectedAt.UnixNano())
+
+	// 请求的 context 在 HandleWebSocket 返回时就会被取消，这里只继承它的值，取消由连接自己和服务器控制
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	stop := context.AfterFunc(s.ctx, cancel)
+	client.ctx = ctx
+	client.cancel = func() {
+		stop()
+		cancel()
+	}
+	if s.ChannelCreateRate > 0 {
+		client.createLimiter = newTokenBucket(s.ChannelCreateRate, s.ChannelCreateBurst)
+	}
+	if s.MessageRate > 0 {
+		client.messageLimiter = newTokenBucket(s.MessageRate, s.MessageBurst)
+	}
+	if s.IdleTimeout > 0 {
+		client.idleTimer = time.AfterFunc(s.IdleTimeout, func() {
+			s.Logger.Info("客户端空闲超时，关闭连接", "event", "idle_timeout", "client_id", client.ID, "timeout", s.IdleTimeout)
+			s.closeClient(client, CloseIdle, "idle timeout")
+		})
+	}
+	return client
+}
+
+// 发送连接确认并把客户端交给事件循环注册，随后调用 OnConnect。
+// 注册失败（服务器正在关闭或过于繁忙）时已撤销资源并断开，返回 false
+func (s *Server) admit(client *Client) bool {
+	// 连接确认在注册前放入发送队列：此时队列为空且别处还拿不到该客户端，
+	// 入队不会阻塞，并且确认总是客户端收到的第一条消息（早于 OnConnect 或其他连接发来的消息）
+	response := Response{
+		ClientID: client.ID,
+		Action:   "connect",
+		Code:     CodeSuccess,
+		Msg:      "success",
+	}
+	data := make(map[string]interface{})
+	if client.UserID != "" {
+		data["userId"] = client.UserID
+	}
+	if client.sessionToken != "" {
+		data["sessionToken"] = client.sessionToken
+		data["resumed"] = client.resume != nil
+	}
+	if len(data) > 0 {
+		response.Data = data
+	}
+	s.sendResponse(client, response)
+
+	// 注册客户端，最多等待 registerTimeout；事件循环已退出或过于繁忙时断开。
+	// writers 在注册前计数，保证 Shutdown 等待时不会漏掉已注册的连接
+	s.writers.Add(1)
+	timer := time.NewTimer(registerTimeout)
+	defer timer.Stop()
+	select {
+	case s.register <- client:
+	case <-s.done:
+		s.abortRegister(client, websocket.CloseGoingAway, "server shutting down")
+		return false
+	case <-timer.C:
+		s.Logger.Warn("注册超时，断开连接", "event", "register_timeout", "client_id", client.ID)
+		s.abortRegister(client, websocket.CloseTryAgainLater, "server busy")
+		return false
+	}
+	// 等事件循环处理完注册再返回：长轮询的 POST 紧接着就会处理消息，此时客户端必须已在注册表中
+	select {
+	case <-client.registered:
+	case <-s.done:
+	}
+
+	if s.OnConnect != nil {
+		func() {
+			defer s.recoverClient(client, "OnConnect")
+			s.OnConnect(client)
+		}()
+	}
+	return true
+}
+
+// 认证并授权连接请求，失败时已写出 401/403 响应，ok 为 false
+func (s *Server) authorizeConnection(w http.ResponseWriter, r *http.Request) (userID string, grant ConnectionGrant, ok bool) {
+	if s.Authenticator != nil {
+		var err error
+		if userID, err = s.Authenticator(r); err != nil {
+			s.Logger.Warn("连接认证失败", "event", "auth_failed", "remote_addr", r.RemoteAddr, "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return "", ConnectionGrant{}, false
+		}
+	}
+	if s.Authorizer != nil {
+		var err error
+		if grant, err = s.Authorizer(r, userID); err != nil {
+			s.Logger.Warn("连接授权失败", "event", "authz_failed", "remote_addr", r.RemoteAddr, "user_id", userID, "error", err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return "", ConnectionGrant{}, false
+		}
+	}
+	return userID, grant, true
+}
+
+// 解析逗号分隔的频道列表，去掉空白、空项和重复项，保留原有顺序
+func parseChannelList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var channels []string
+	for _, channel := range strings.Split(value, ",") {
+		channel = strings.TrimSpace(channel)
+		if channel == "" || seen[channel] {
+			continue
+		}
+		seen[channel] = true
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// 注册失败时撤销握手阶段分配的资源并关闭连接（客户端从未进入 clients）
+func (s *Server) abortRegister(client *Client, code int, reason string) {
+	client.cancel()
+	s.writers.Done()
+	s.releaseConnection(client.remoteIP)
+	s.releaseAccount(client)
+	if client.idleTimer != nil {
+		client.idleTimer.Stop()
+	}
+	s.closeClient(client, code, reason)
+}
+
+// 按客户端请求的顺序选出第一个支持的子协议。
+// 服务器未配置子协议或客户端未请求时不协商；ok 为 false 表示请求的协议都不支持
+func (s *Server) negotiateSubprotocol(requested []string) (protocol string, ok bool) {
+	if len(s.Subprotocols) == 0 || len(requested) == 0 {
+		return "", true
+	}
+	for _, p := range requested {
+		for _, supported := range s.Subprotocols {
+			if p == supported {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// 默认的子协议不支持响应
+func (s *Server) rejectSubprotocol(w http.ResponseWriter, requested []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     "unsupported subprotocol",
+		"requested": requested,
+		"supported": s.Subprotocols,
+	})
+}
+
+// 从握手请求中提取连接元数据，查询参数以 "query." 为前缀
+func connectionMetadata(r *http.Request) map[string]string {
+	metadata := map[string]string{
+		"remote_addr": r.RemoteAddr,
+		"user_agent":  r.UserAgent(),
+		"origin":      r.Header.Get("Origin"),
+	}
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			metadata["query."+key] = values[0]
+		}
+	}
+	return metadata
+}
+
+func isTruthy(value string) bool {
+	switch value {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// 读取消息
+func (s *Server) readPump(client *Client) {
+	defer func() {
+		// 前面没有记录关闭状态（客户端没有发关闭帧，服务器也没有主动关闭）时视为异常断开
+		client.recordClose(websocket.CloseAbnormalClosure, "")
+		code, reason := client.CloseStatus()
+		s.Metrics.countDisconnect(code)
+
+		s.publishWill(client)
+		select {
+		case s.unregister <- client:
+		case <-s.done:
+		}
+		client.cancel()
+		client.Conn.Close()
+		if s.OnDisconnect != nil {
+			func() {
+				defer s.recoverClient(client, "OnDisconnect")
+				s.OnDisconnect(client, code, reason)
+			}()
+		}
+	}()
+	// 消息处理（包括其中调用的钩子）panic 时只关闭这个连接，随后由上面的清理照常注销
+	defer s.recoverClient(client, "readPump")
+
+	client.Conn.SetReadLimit(s.MaxMessageSize)
+
+	// 每收到 pong 或消息都延长读超时
+	client.Conn.SetReadDeadline(time.Now().Add(s.readWait()))
+	client.Conn.SetPongHandler(func(appData string) error {
+		now := time.Now()
+		client.lastSeen.Store(now.UnixNano())
+		client.heard()
+		// ping 的负载是发出时间，pong 原样带回
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			client.lastRTT.Store(now.UnixNano() - sent)
+		}
+		return client.Conn.SetReadDeadline(time.Now().Add(s.readWait()))
+	})
+
+	for {
+		messageType, message, err := client.Conn.ReadMessage()
+		if err != nil {
+			// 超限时 gorilla 已经写出了 CloseMessageTooBig 关闭帧
+			if errors.Is(err, websocket.ErrReadLimit) {
+				s.Logger.Warn("消息超过大小限制，断开连接", "event", "message_too_big", "client_id", client.ID, "limit", s.MaxMessageSize)
+				client.recordClose(websocket.CloseMessageTooBig, "message too big")
+				break
+			}
+			// 客户端发来的关闭帧
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				client.recordClose(closeErr.Code, closeErr.Text)
+			}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				s.Logger.Warn("读取错误", "event", "read_error", "client_id", client.ID, "error", err)
+			}
+			break
+		}
+		client.Conn.SetReadDeadline(time.Now().Add(s.readWait()))
+		if s.FrameDebug {
+			s.logFrame(client, "in", messageType, message)
+		}
+		if !s.processFrame(client, messageType, message) {
+			break
+		}
+	}
+}
+
+// 处理客户端发来的一帧：解码、校验、限流后交给 handleMessage。
+// 返回 false 表示连接已因持续超限被关闭，调用方应停止读取
+func (s *Server) processFrame(client *Client, messageType int, message []byte) bool {
+	client.lastSeen.Store(time.Now().UnixNano())
+	client.heard()
+	if client.idleTimer != nil {
+		client.idleTimer.Reset(s.IdleTimeout)
+	}
+	client.counters.received(len(message))
+	s.Metrics.MessagesReceived.Add(1)
+
+	if s.RawMessageHandler != nil && s.RawMessageHandler(client, messageType, message) {
+		return true
+	}
+
+	// 按协商的子协议解析消息
+	var msg Message
+	handled, err := s.decodeFrame(client, messageType, message, &msg)
+	if !handled {
+		return true
+	}
+	var frameErr frameTypeError
+	if errors.As(err, &frameErr) {
+		// 帧类型不对时内容无从解析，明确告诉客户端应该发送哪种帧
+		s.Logger.Debug("帧类型不受支持", "event", "unsupported_frame", "client_id", client.ID, "error", err)
+		s.sendResponse(client, errorResponse(client, "", CodeBadFrameType, err.Error()))
+		return true
+	}
+	if err != nil {
+		// 解析失败时读不到 action，只能回一个通用的错误帧
+		s.Logger.Debug("消息解析失败", "event", "parse_error", "client_id", client.ID, "error", err)
+		response := errorResponse(client, "", CodeBadRequest, "invalid message: "+err.Error())
+		s.sendResponse(client, response)
+		return true
+	}
+
+	// 校验消息；只有文本帧要求是 UTF-8
+	raw := message
+	if messageType != websocket.TextMessage {
+		raw = nil
+	}
+	if err := s.validateMessage(raw, &msg); err != nil {
+		s.Logger.Debug("消息校验失败", "event", "invalid_message", "client_id", client.ID, "action", msg.Action, "error", err)
+		response := errorResponse(client, msg.Action, CodeBadRequest, err.Error())
+		response.RequestID = msg.RequestID
+		s.sendResponse(client, response)
+		return true
+	}
+
+	// 入站限流：超限的消息直接丢弃，持续超限则断开
+	if !s.allowMessage(client, &msg) {
+		if s.MessageAbuseLimit > 0 && client.throttled >= s.MessageAbuseLimit {
+			s.Logger.Warn("持续超出消息速率，断开连接", "event", "rate_abuse", "client_id", client.ID)
+			s.closeClient(client, websocket.ClosePolicyViolation, "message rate exceeded")
+			return false
+		}
+		return true
+	}
+
+	// 处理消息
+	s.handleMessage(client, &msg)
+	return true
+}
+
+// 写入消息
+func (s *Server) writePump(client *Client) {
+	ticker := time.NewTicker(s.PingInterval)
+	defer func() {
+		ticker.Stop()
+		client.Conn.Close()
+		s.writers.Done()
+	}()
+	defer s.recoverClient(client, "writePump")
+
+	if client.compressionNegotiated && s.writeCompressionLevel != 0 {
+		if err := client.Conn.SetCompressionLevel(s.writeCompressionLevel); err != nil {
+			s.Logger.Warn("设置压缩级别失败", "event", "compression_error", "client_id", client.ID, "error", err)
+		}
+	}
+
+	var held *OutboundMessage // 取出时客户端已被暂停的消息，恢复后最先写出
+	removed := client.removed // 收到注销信号后置为 nil，避免反复触发
+	for {
+		queue := client.sendQueue()
+		if held != nil {
+			queue = nil
+		}
+		select {
+		case message, ok := <-queue:
+			if !ok {
+				// 通道已关闭
+				writeCloseFrame(client)
+				return
+			}
+			s.accountDequeue(client, len(message.Payload))
+			if client.Paused() && !client.isRemoved() {
+				held = &message
+				continue
+			}
+			if !s.writeQueued(client, message) {
+				return
+			}
+
+		case <-removed:
+			// 已注销：不再理会暂停，写出暂存的消息后继续排空发送队列，读到通道关闭时退出
+			removed = nil
+			if held != nil {
+				message := *held
+				held = nil
+				if !s.writeQueued(client, message) {
+					return
+				}
+			}
+
+		case <-client.resumed:
+			// 恢复投递，下一轮重新从发送队列中取消息
+			if held != nil && !client.Paused() {
+				message := *held
+				held = nil
+				if !s.writeQueued(client, message) {
+					return
+				}
+			}
+
+		case <-client.ctx.Done():
+			// 连接被取消：关闭底层连接，readPump 随之退出
+			return
+
+		case <-ticker.C:
+			if s.missedTooManyPongs(client) {
+				s.Logger.Info("连续多次没有收到 pong，断开连接", "event", "missed_pongs", "client_id", client.ID, "missed", client.missedPongs.Load())
+				s.Metrics.MissedPongEvictions.Add(1)
+				client.recordClose(websocket.CloseAbnormalClosure, "missed pongs")
+				return
+			}
+			// 写入失败或超时说明连接已断开
+			client.Conn.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+			ping := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, ping); err != nil {
+				s.writeFailed(client, "心跳失败", "ping_failed", err)
+				return
+			}
+		}
+	}
+}
+
+// 写出从发送队列取出的一条消息（批量模式下连同后续消息），之后取回溢出消息。
+// 返回 false 表示写入失败或发送通道已关闭，writePump 应退出
+func (s *Server) writeQueued(client *Client, message OutboundMessage) bool {
+	if s.expired(message) {
+		return true
+	}
+
+	var closed bool
+	var err error
+	if s.batching(client, message) {
+		closed, err = s.writeBatch(client, message)
+	} else {
+		err = s.writeFrame(client, message)
+	}
+	if err != nil {
+		s.writeFailed(client, "写入错误", "write_error", err)
+		return false
+	}
+	if closed {
+		writeCloseFrame(client)
+		return false
+	}
+
+	// 发送缓冲区清空后取回溢出消息
+	if err := s.drainOverflow(client); err != nil {
+		s.writeFailed(client, "写入错误", "write_error", err)
+		return false
+	}
+	return true
+}
+
+// writePump 写入失败后记录原因。超时说明客户端长时间没有读取，单独记录日志和指标，
+// 并把关闭原因记为 write timeout，OnDisconnect 和断开统计中可以看到
+func (s *Server) writeFailed(client *Client, msg, event string, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		s.Logger.Warn("写入超时，断开连接", "event", "write_timeout", "client_id", client.ID, "timeout", s.writeTimeout())
+		s.Metrics.WriteTimeouts.Add(1)
+		client.recordClose(websocket.CloseAbnormalClosure, "write timeout")
+		return
+	}
+	s.Logger.Debug(msg, "event", event, "client_id", client.ID, "error", err)
+}
+
+// 读超时：允许连续错过 MaxMissedPongs 个 pong 时放宽，保证由 writePump 按计数断开，而不是先触发读超时
+func (s *Server) readWait() time.Duration {
+	if s.MaxMissedPongs <= 1 {
+		return s.PongWait
+	}
+	return s.PongWait + time.Duration(s.MaxMissedPongs)*s.PingInterval
+}
+
+// 发送下一个 ping 之前检查上一个是否得到了回应，连续错过 MaxMissedPongs 个时返回 true（只在 writePump 中调用）
+func (s *Server) missedTooManyPongs(client *Client) bool {
+	if s.MaxMissedPongs <= 1 {
+		return false
+	}
+	if !client.awaitingPong.Swap(true) {
+		return false
+	}
+	missed := client.missedPongs.Add(1)
+	s.Logger.Debug("没有收到 pong", "event", "missed_pong", "client_id", client.ID, "missed", missed)
+	return int(missed) >= s.MaxMissedPongs
+}
+
+// 收到 pong 或任何消息，清零未回应的 ping 计数
+func (c *Client) heard() {
+	c.awaitingPong.Store(false)
+	c.missedPongs.Store(0)
+}
+
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout > 0 {
+		return s.WriteTimeout
+	}
+	return defaultWriteTimeout
+}
+
+// 发送通道关闭后写出关闭帧
+func writeCloseFrame(client *Client) {
+	client.Conn.SetWriteDeadline(time.Now().Add(closeWriteWait))
+	client.Conn.WriteMessage(websocket.CloseMessage, client.closeMessage)
+}
+
+// 写出一帧并更新统计
+func (s *Server) writeFrame(client *Client, message OutboundMessage) error {
+	compress := client.wantsCompression(message.Channel, len(message.Payload), s.compressionThreshold())
+	client.Conn.EnableWriteCompression(compress)
+	client.Conn.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+	if s.FrameDebug {
+		s.logFrame(client, "out", message.Type, message.Payload)
+	}
+	if err := client.Conn.WriteMessage(message.Type, message.Payload); err != nil {
+		return err
+	}
+	client.counters.sent(len(message.Payload))
+
+	if s.OnFrameWritten != nil {
+		s.OnFrameWritten(client, FrameInfo{
+			Bytes:       len(message.Payload),
+			MessageType: message.Type,
+			Channel:     message.Channel,
+			Compressed:  compress,
+		})
+	}
+	return nil
+}
+
+// 处理消息
+func (s *Server) handleMessage(client *Client, msg *Message) {
+	if s.OnMessage != nil && !s.OnMessage(client, msg) {
+		return
+	}
+
+	// 只读连接拒绝所有修改状态的操作
+	if client.ReadOnly && !readOnlyActions[msg.Action] {
+		response := errorResponse(client, msg.Action, CodeForbidden, "read-only connection")
+		response.RequestID = msg.RequestID
+		response.Channel = msg.Channel
+		s.sendResponse(client, response)
+		return
+	}
+
+	// 控制频道上的订阅和发布交给命令分发，不经过频道校验和维护模式（维护期间正需要管理操作）
+	if msg.Channel == ControlChannel && controlActions[msg.Action] {
+		s.handleControl(client, msg)
+		return
+	}
+
+	// 维护模式拒绝新的订阅和发布
+	if s.rejectInMaintenance(client, msg.Action, msg.Channel, msg.RequestID) {
+		return
+	}
+
+	// 规范化并校验频道名，之后的处理和订阅表中都只使用规范化后的名字
+	if channel, err := s.checkMessageChannels(msg); err != nil {
+		response := errorResponse(client, msg.Action, CodeInvalidChannel, err.Error())
+		response.RequestID = msg.RequestID
+		response.Channel = channel
+		s.sendResponse(client, response)
+		return
+	}
+
+	// 订阅和取消订阅必须指定频道
+	if (msg.Action == "subscribe" || msg.Action == "unsubscribe") && msg.Channel == "" && len(msg.Channels) == 0 {
+		response := errorResponse(client, msg.Action, CodeMissingChannel, "channel is required")
+		response.RequestID = msg.RequestID
+		s.sendResponse(client, response)
+		return
+	}
+
+	if validate := s.actionValidators[msg.Action]; validate != nil {
+		if err := validate(msg.Data); err != nil {
+			response := errorResponse(client, msg.Action, CodeInvalidData, err.Error())
+			response.RequestID = msg.RequestID
+			response.Channel = msg.Channel
+			s.sendResponse(client, response)
+			return
+		}
+	}
+
+	routed := false
+	if s.RouteAllMessages {
+		routed = s.routeMessage(client, msg)
+	}
+
+	if handler := s.actionHandlers[msg.Action]; handler != nil {
+		handler(client, msg)
+		return
+	}
+
+	// 应用通过 Messages 处理自定义 action
+	if !s.RouteAllMessages {
+		routed = s.routeMessage(client, msg)
+	}
+	if routed {
+		return
+	}
+	s.Logger.Debug("未知操作", "event", "unknown_action", "client_id", client.ID, "action", msg.Action)
+	response := errorResponse(client, msg.Action, CodeUnknownAction, "unknown action: "+msg.Action)
+	response.RequestID = msg.RequestID
+	s.sendResponse(client, response)
+}
+
+// 是否允许客户端订阅频道
+func (s *Server) canSubscribe(client *Client, channel string) bool {
+	if s.CanSubscribe != nil && !s.CanSubscribe(client, channel) {
+		return false
+	}
+	return s.namespaceAllowsSubscribe(client, channel)
+}
+
+// 是否允许客户端向频道发布
+func (s *Server) canPublish(client *Client, channel string) bool {
+	if s.CanPublish != nil && !s.CanPublish(client, channel) {
+		return false
+	}
+	return s.namespaceAllowsPublish(client, channel)
+}
+
+// 过滤通过模式匹配到的订阅者：订阅时只对模式做了授权，这里按具体频道再检查一次，
+// 否则被拒绝订阅某频道的客户端可以通过 "*" 收到它的消息
+func (s *Server) authorizeMatched(clients []*Client, channel string) []*Client {
+	if len(clients) == 0 || (s.CanSubscribe == nil && s.NamespaceSeparator == "") {
+		return clients
+	}
+	allowed := clients[:0]
+	for _, client := range clients {
+		if s.canSubscribe(client, channel) {
+			allowed = append(allowed, client)
+		}
+	}
+	return allowed
+}
+
+// 处理订阅
+func (s *Server) handleSubscribe(client *Client, channel string, opts subscribeOptions) {
+	if !s.canSubscribe(client, channel) {
+		s.Logger.Debug("订阅被拒绝", "event", "subscribe_denied", "client_id", client.ID, "channel", channel)
+		response := errorResponse(client, "subscribe", CodeForbidden, "subscribe not allowed")
+		response.RequestID = opts.requestID
+		response.Channel = channel
+		s.sendResponse(client, response)
+		return
+	}
+
+	// 阈值回调在释放锁之后触发
+	var crossings []thresholdCrossing
+	defer func() { s.fireThresholds(crossings) }()
+
+	// 全局读锁保证客户端在订阅期间不会被注销；不同频道只在各自的分片上互斥
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.clients[client] {
+		return
+	}
+	unlock := s.lockSubscriptions([]string{channel})
+	defer unlock()
+
+	crossings, err := s.addSubscription(client, channel, opts)
+	if err != nil {
+		response := errorResponse(client, "subscribe", CodeRateLimited, err.Error())
+		response.RequestID = opts.requestID
+		response.Channel = channel
+		if err != errChannelCreateLimited {
+			response.Code = CodeForbidden
+		}
+		s.sendResponse(client, response)
+		return
+	}
+
+	// 发送订阅确认。持有分片锁（通配订阅还有模式表的锁）期间广播取不到新的订阅列表，确认总是先于实时消息
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: opts.requestID,
+		Action:    "subscribe",
+		Channel:   channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+	s.sendResponse(client, response)
+
+	s.replaySubscription(client, channel, opts)
+	s.Logger.Debug("订阅频道", "event", "subscribe", "client_id", client.ID, "channel", channel)
+}
+
+// 一次订阅多个频道，只发送一条汇总确认，data.channels 列出订阅成功的频道
+func (s *Server) handleSubscribeMany(client *Client, channels []string, opts subscribeOptions) {
+	s.subscribeMany(client, channels, opts, nil)
+}
+
+// 订阅多个频道并发送一条汇总确认。开启 BatchAcks 时 results 按请求顺序列出每个频道的结果，
+// rejected 是调用方已经拒绝的频道（如没有通过校验），排在最前面
+func (s *Server) subscribeMany(client *Client, channels []string, opts subscribeOptions, rejected []ChannelResult) {
+	var crossings []thresholdCrossing
+	defer func() { s.fireThresholds(crossings) }()
+
+	requested := channels
+
+	// 授权在加锁之前检查，被拒绝的频道不进入订阅
+	allowed := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if s.canSubscribe(client, channel) {
+			allowed = append(allowed, channel)
+		}
+	}
+	denied := len(allowed) < len(channels)
+	channels = allowed
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.clients[client] {
+		return
+	}
+	unlock := s.lockSubscriptions(channels)
+	defer unlock()
+
+	succeeded := make([]string, 0, len(channels))
+	failures := make(map[string]error)
+	var failed error
+	for _, channel := range channels {
+		added, err := s.addSubscription(client, channel, opts)
+		if err != nil {
+			failures[channel] = err
+			failed = err
+			continue
+		}
+		crossings = append(crossings, added...)
+		succeeded = append(succeeded, channel)
+	}
+
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: opts.requestID,
+		Action:    "subscribe",
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data:      map[string][]string{"channels": succeeded},
+	}
+	switch {
+	case failed == errChannelCreateLimited:
+		response.Msg = "partially rate limited"
+	case failed != nil:
+		response.Msg = "partially rejected: " + failed.Error()
+	case denied:
+		response.Msg = "partially rejected: subscribe not allowed"
+	case len(rejected) > 0:
+		response.Msg = "partially rejected: " + rejected[0].Msg
+	}
+	if s.BatchAcks {
+		response.Results = subscribeResults(requested, allowed, failures, rejected)
+	}
+	s.sendResponse(client, response)
+
+	// 确认之后再回放，保证客户端先收到确认
+	for _, channel := range succeeded {
+		s.replaySubscription(client, channel, opts)
+	}
+	s.Logger.Debug("批量订阅频道", "event", "subscribe", "client_id", client.ID, "channels", succeeded)
+}
+
+// 按请求顺序列出每个频道的订阅结果，状态码与单频道订阅的确认一致
+func subscribeResults(requested, allowed []string, failures map[string]error, rejected []ChannelResult) []ChannelResult {
+	permitted := make(map[string]bool, len(allowed))
+	for _, channel := range allowed {
+		permitted[channel] = true
+	}
+
+	results := append(make([]ChannelResult, 0, len(rejected)+len(requested)), rejected...)
+	for _, channel := range requested {
+		result := ChannelResult{Channel: channel, Code: CodeSuccess, Msg: "success"}
+		switch err := failures[channel]; {
+		case !permitted[channel]:
+			result.Code, result.Msg = CodeForbidden, "subscribe not allowed"
+		case err == errChannelCreateLimited:
+			result.Code, result.Msg = CodeRateLimited, err.Error()
+		case err != nil:
+			result.Code, result.Msg = CodeForbidden, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
 }
 
-// 消息类型
-type Message struct {
-	Action  string      `json:"action"`
-	Channel string      `json:"channel"`
-	Data    interface{} `json:"data,omitempty"`
-}
+// 把客户端加入频道，返回阈值变化；新建频道被限流或超过订阅数上限时返回对应的错误。
+// 调用方需持有 s.mu 读锁，并通过 lockSubscriptions 锁住该频道
+func (s *Server) addSubscription(client *Client, channel string, opts subscribeOptions) ([]thresholdCrossing, error) {
+	// 通配订阅单独存放，不参与限流、阈值和在线状态，但计入订阅数上限
+	if isPattern(channel) {
+		if err := client.addPattern(channel, s.MaxChannelsPerClient, s.MaxPatternsPerClient); err != nil {
+			return nil, err
+		}
+		if s.patterns.add(client, channel) {
+			s.emit(EventSubscribe, client, channel)
+		}
+		return nil, nil
+	}
 
-type Response struct {
-	ClientID string      `json:"clientId"`
-	Action   string      `json:"action"`
-	Channel  string      `json:"channel"`
-	Code     int         `json:"code"`
-	Msg      string      `json:"msg"`
-	Data     interface{} `json:"data,omitempty"`
-}
+	sh := s.subscriptions.shard(channel)
 
-// 客户端连接
-type Client struct {
-	ID       string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	Channels map[string]bool // 订阅的频道
+	// 新建频道需要经过限流
+	if sh.subs[channel] == nil && client.createLimiter != nil && !client.createLimiter.Allow() {
+		s.Logger.Debug("新建频道被限流", "event", "channel_create_limited", "client_id", client.ID, "channel", channel)
+		return nil, errChannelCreateLimited
+	}
+
+	// 命名空间的频道数和订阅数上限，重复订阅不再占用名额
+	subscribed := sh.subs[channel][client]
+	if !subscribed {
+		if err := s.reserveNamespace(channel, len(sh.subs[channel]) == 0, true); err != nil {
+			s.Logger.Debug("超过命名空间上限", "event", "namespace_limit", "client_id", client.ID, "channel", channel, "error", err)
+			return nil, err
+		}
+	}
+
+	// 添加到客户端的订阅列表
+	if !client.addChannel(channel, s.MaxChannelsPerClient) {
+		s.Logger.Debug("超过订阅数上限", "event", "channel_limit", "client_id", client.ID, "channel", channel)
+		if !subscribed {
+			s.releaseNamespace(channel, len(sh.subs[channel]) == 0)
+		}
+		return nil, errTooManyChannels
+	}
+	client.setCompression(channel, opts.compress)
+	client.resetReliable(channel)
+	client.setResumeSeq(channel, s.currentSeq(channel))
+
+	// 添加到频道的订阅列表
+	if sh.subs[channel] == nil {
+		sh.subs[channel] = make(map[*Client]bool)
+	}
+	before := len(sh.subs[channel])
+	sh.subs[channel][client] = true
+	crossings := s.thresholdCrossings(channel, before, len(sh.subs[channel]))
+	if len(sh.subs[channel]) > before {
+		s.notifyPresence(channel, "join", client)
+		s.emit(EventSubscribe, client, channel)
+	}
+	return crossings, nil
 }
 
-// WebSocket服务器
-type Server struct {
-	clients       map[*Client]bool            // 所有连接的客户端
-	subscriptions map[string]map[*Client]bool // 频道 -> 客户端映射
-	register      chan *Client                // 注册新客户端
-	unregister    chan *Client                // 注销客户端
-	broadcast     chan BroadcastMsg           // 广播消息
-	mu            sync.RWMutex                // 读写锁
+// 订阅确认之后的回放（调用方需持有该频道分片的写锁）
+func (s *Server) replaySubscription(client *Client, channel string, opts subscribeOptions) {
+	s.sendSnapshot(client, channel)
+	// 暂存和历史都按具体频道保存，通配订阅没有可回放的内容
+	if isPattern(channel) {
+		return
+	}
+	// 先发保留消息，再回放频道在无人订阅期间暂存的消息
+	s.replayRetained(client, channel)
+	s.replayPending(client, channel)
+	switch {
+	case opts.sinceSeq > 0:
+		s.replayHistorySeq(client, channel, opts.sinceSeq)
+	case opts.since > 0:
+		s.replayHistory(client, channel, opts.since)
+	}
 }
 
-type BroadcastMsg struct {
-	Channel string
-	Data    interface{}
+// 发送 SnapshotProvider 给出的频道快照（调用方需持有该频道分片的写锁，快照因此早于之后的广播入队）
+func (s *Server) sendSnapshot(client *Client, channel string) {
+	if s.SnapshotProvider == nil {
+		return
+	}
+	data, ok := s.SnapshotProvider(channel, client)
+	if !ok {
+		return
+	}
+	response := Response{
+		ClientID: client.ID,
+		Action:   "snapshot",
+		Channel:  channel,
+		Code:     CodeSuccess,
+		Msg:      "success",
+		Data:     data,
+	}
+	s.sendResponse(client, response)
 }
 
-// 创建新服务器
-func NewServer() *Server {
-	return &Server{
-		clients:       make(map[*Client]bool),
-		subscriptions: make(map[string]map[*Client]bool),
-		register:      make(chan *Client),
-		unregister:    make(chan *Client),
-		broadcast:     make(chan BroadcastMsg),
+// 处理取消订阅
+func (s *Server) handleUnsubscribe(client *Client, channel, requestID string) {
+	// 阈值回调在释放锁之后触发
+	var crossings []thresholdCrossing
+	defer func() { s.fireThresholds(crossings) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.clients[client] {
+		return
+	}
+
+	crossings, _ = s.removeSubscription(client, channel)
+
+	// 发送取消订阅确认
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: requestID,
+		Action:    "unsubscribe",
+		Channel:   channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
 	}
+	s.sendResponse(client, response)
+
+	s.Logger.Debug("取消订阅频道", "event", "unsubscribe", "client_id", client.ID, "channel", channel)
 }
 
-// 运行服务器
-func (s *Server) Run() {
-	for {
-		select {
-		case client := <-s.register:
-			s.mu.Lock()
-			s.clients[client] = true
-			s.mu.Unlock()
-			log.Printf("客户端 %s 已连接，当前连接数: %d", client.ID, len(s.clients))
-
-		case client := <-s.unregister:
-			s.mu.Lock()
-			if _, ok := s.clients[client]; ok {
-				delete(s.clients, client)
-				close(client.Send)
-				// 从所有订阅中移除
-				for channel := range client.Channels {
-					if subs, ok := s.subscriptions[channel]; ok {
-						delete(subs, client)
-						if len(subs) == 0 {
-							delete(s.subscriptions, channel)
-						}
-					}
-				}
-			}
-			s.mu.Unlock()
-			log.Printf("客户端 %s 已断开，当前连接数: %d", client.ID, len(s.clients))
+// 一次取消订阅多个频道，只发送一条汇总确认
+func (s *Server) handleUnsubscribeMany(client *Client, channels []string, requestID string) {
+	var crossings []thresholdCrossing
+	defer func() { s.fireThresholds(crossings) }()
 
-		case msg := <-s.broadcast:
-			s.mu.RLock()
-			subs, ok := s.subscriptions[msg.Channel]
-			if !ok {
-				s.mu.RUnlock()
-				log.Printf("频道 %s 没有订阅者", msg.Channel)
-				continue
-			}
-			// 复制订阅列表，避免长时间持有锁
-			clients := make([]*Client, 0, len(subs))
-			for client := range subs {
-				clients = append(clients, client)
-			}
-			s.mu.RUnlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.clients[client] {
+		return
+	}
 
-			// 发送消息给所有订阅者
-			response := Response{
-				Action:  "message",
-				Channel: msg.Channel,
-				Code:    200,
-				Msg:     "success",
-				Data:    msg.Data,
-			}
-			data, _ := json.Marshal(response)
-			for _, client := range clients {
-				select {
-				case client.Send <- data:
-				default:
-					// 发送失败，关闭连接
-					close(client.Send)
-					s.unregister <- client
-				}
-			}
-			log.Printf("向频道 %s 的 %d 个订阅者广播消息", msg.Channel, len(clients))
+	// 确认中只列出确实离开的频道，没有订阅过的不算
+	left := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		changed, removed := s.removeSubscription(client, channel)
+		crossings = append(crossings, changed...)
+		if removed {
+			left = append(left, channel)
 		}
 	}
+
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: requestID,
+		Action:    "unsubscribe",
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data:      map[string][]string{"channels": left},
+	}
+	s.sendResponse(client, response)
+
+	s.Logger.Debug("批量取消订阅频道", "event", "unsubscribe", "client_id", client.ID, "channels", left)
 }
 
-// 处理WebSocket连接
-func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// 升级HTTP连接为WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket升级失败: %v", err)
+// 取消客户端的全部订阅，确认中列出离开的频道（已排序）
+func (s *Server) handleUnsubscribeAll(client *Client, requestID string) {
+	var crossings []thresholdCrossing
+	defer func() { s.fireThresholds(crossings) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.clients[client] {
 		return
 	}
 
-	// 创建客户端
-	client := &Client{
-		ID:       uuid.New().String(),
-		Conn:     conn,
-		Send:     make(chan []byte, 256),
-		Channels: make(map[string]bool),
+	// 订阅只来自该客户端自己的 readPump，这里取到的快照就是全部订阅
+	channels := client.channelList()
+	sort.Strings(channels)
+	for _, channel := range channels {
+		changed, _ := s.removeSubscription(client, channel)
+		crossings = append(crossings, changed...)
 	}
 
-	// 注册客户端
-	s.register <- client
-
-	// 发送连接确认消息
 	response := Response{
-		ClientID: client.ID,
-		Action:   "connect",
-		Code:     200,
-		Msg:      "success",
+		ClientID:  client.ID,
+		RequestID: requestID,
+		Action:    "unsubscribe_all",
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data:      map[string][]string{"channels": channels},
 	}
-	data, _ := json.Marshal(response)
-	client.Send <- data
+	s.sendResponse(client, response)
 
-	// 启动goroutine处理读写
-	go s.writePump(client)
-	go s.readPump(client)
+	s.Logger.Debug("取消全部订阅", "event", "unsubscribe", "client_id", client.ID, "channels", channels)
 }
 
-// 读取消息
-func (s *Server) readPump(client *Client) {
-	defer func() {
-		s.unregister <- client
-		client.Conn.Close()
-	}()
+// 把客户端移出频道，返回阈值变化和客户端之前是否订阅了该频道（调用方需持有 s.mu，读锁即可；分片锁在这里获取）
+func (s *Server) removeSubscription(client *Client, channel string) (crossings []thresholdCrossing, removed bool) {
+	// 从客户端订阅列表移除
+	client.channelsMu.Lock()
+	removed = client.Channels[channel]
+	delete(client.Channels, channel)
+	delete(client.publishLimiters, channel)
+	delete(client.resumeSeqs, channel)
+	client.channelsMu.Unlock()
+	client.setCompression(channel, nil)
+	client.resetReliable(channel)
 
-	for {
-		_, message, err := client.Conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("读取错误: %v", err)
-			}
-			break
+	if isPattern(channel) {
+		if s.patterns.remove(client, channel) {
+			s.emit(EventUnsubscribe, client, channel)
 		}
+		return nil, removed
+	}
 
-		// 解析消息
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("消息解析失败: %v", err)
-			continue
-		}
+	// 从频道订阅列表移除
+	sh := s.subscriptions.shard(channel)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-		// 处理消息
-		s.handleMessage(client, &msg)
+	subs, ok := sh.subs[channel]
+	if !ok {
+		return nil, removed
+	}
+	before := len(subs)
+	delete(subs, client)
+	crossings = s.thresholdCrossings(channel, before, len(subs))
+	if before > len(subs) {
+		s.releaseNamespace(channel, len(subs) == 0)
+		s.notifyPresence(channel, "leave", client)
+		s.emit(EventUnsubscribe, client, channel)
+	}
+	if len(subs) == 0 {
+		delete(sh.subs, channel)
+		s.scheduleHistoryReclaim(channel)
+		s.channelCounters.touch(channel)
 	}
+	return crossings, removed
 }
 
-// 写入消息
-func (s *Server) writePump(client *Client) {
-	defer client.Conn.Close()
+// 处理心跳
+// 客户端在 data 中带上自己的时间戳时原样带回，同时给出服务器收到 ping 的时间（Unix 毫秒），
+// 客户端据此计算往返时间和时钟偏差
+func (s *Server) handlePing(client *Client, msg *Message) {
+	data := map[string]interface{}{"serverTime": time.Now().UnixMilli()}
+	if msg.Data != nil {
+		data["clientTime"] = msg.Data
+	}
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: msg.RequestID,
+		Action:    "pong",
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data:      data,
+	}
+	s.sendResponse(client, response)
+}
 
-	for {
-		select {
-		case message, ok := <-client.Send:
-			if !ok {
-				// 通道已关闭
-				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+// whoami 返回的连接信息
+type WhoAmI struct {
+	ClientID    string    `json:"clientId"`
+	UserID      string    `json:"userId,omitempty"`
+	Channels    []string  `json:"channels"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Subprotocol string    `json:"subprotocol,omitempty"`
+	Compression bool      `json:"compression"` // 握手时是否协商出了 permessage-deflate
+	ReadOnly    bool      `json:"readOnly"`
+}
 
-			if err := client.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("写入错误: %v", err)
-				return
-			}
-		}
+// 返回客户端自己的连接信息，重连后不必缓存连接确认也能知道自己的ID和订阅
+func (s *Server) handleWhoAmI(client *Client, requestID string) {
+	channels := client.channelList()
+	sort.Strings(channels)
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: requestID,
+		Action:    "whoami",
+		Code:      CodeSuccess,
+		Msg:       "success",
+		Data: WhoAmI{
+			ClientID:    client.ID,
+			UserID:      client.UserID,
+			Channels:    channels,
+			ConnectedAt: client.connectedAt,
+			Subprotocol: client.Subprotocol,
+			Compression: client.compressionNegotiated,
+			ReadOnly:    client.ReadOnly,
+		},
 	}
+	s.sendResponse(client, response)
 }
 
-// 处理消息
-func (s *Server) handleMessage(client *Client, msg *Message) {
-	switch msg.Action {
-	case "subscribe":
-		s.handleSubscribe(client, msg.Channel)
-	case "unsubscribe":
-		s.handleUnsubscribe(client, msg.Channel)
-	case "ping":
-		s.handlePing(client)
-	default:
-		log.Printf("未知操作: %s", msg.Action)
+// 连接关闭的状态码和原因
+type closeStatus struct {
+	code   int
+	reason string
+}
+
+// 记录关闭状态，只保留第一次：服务器主动关闭时记录自己发出的关闭码，否则记录客户端关闭帧中的值
+func (c *Client) recordClose(code int, reason string) {
+	c.closeStatus.CompareAndSwap(nil, &closeStatus{code: code, reason: reason})
+}
+
+// 连接的关闭码和原因。客户端发来关闭帧时为帧中的值，服务器主动关闭时为服务器发出的值，
+// 没有关闭帧的异常断开为 1006；连接尚未关闭时为 0
+func (c *Client) CloseStatus() (code int, reason string) {
+	if st := c.closeStatus.Load(); st != nil {
+		return st.code, st.reason
 	}
+	return 0, ""
 }
 
-// 处理订阅
-func (s *Server) handleSubscribe(client *Client, channel string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// 连接的读写缓冲区大小（字节），为客户端通过 X-WS-Buffer 协商的值或服务器默认值
+func (c *Client) BufferSizes() (read, write int) {
+	return c.readBufferSize, c.writeBufferSize
+}
 
-	// 添加到客户端的订阅列表
-	client.Channels[channel] = true
+// 发送队列中等待写出的消息条数
+func (c *Client) QueueLen() int {
+	return len(c.Send)
+}
 
-	// 添加到频道的订阅列表
-	if s.subscriptions[channel] == nil {
-		s.subscriptions[channel] = make(map[*Client]bool)
+// 最近一次服务器心跳（控制帧 ping）的往返时间，还没有测量时为 0
+func (c *Client) LastRTT() time.Duration {
+	return time.Duration(c.lastRTT.Load())
+}
+
+// 广播消息到频道。调用返回时消息已被事件循环接收；所有广播都由事件循环逐条投递，
+// 每个订阅者的发送队列先进先出，因此同一频道上后返回的调用一定后送达（见 README 的“投递顺序”）
+func (s *Server) BroadcastToChannel(channel string, data interface{}) {
+	msg := BroadcastMsg{
+		Channel: channel,
+		Data:    data,
+	}
+	s.broadcast <- msg
+	s.publishBackplane(msg)
+}
+
+// 同步广播：等待事件循环投递完成，返回本实例上成功放入发送队列的订阅者数，
+// 以及因缓冲区满（可能因此被断开）、降级或全局缓冲超限没有收到的订阅者数。
+// 与 BroadcastToChannel 一样转发给其它实例，但结果只统计本实例
+func (s *Server) BroadcastToChannelSync(channel string, data interface{}) (delivered int, skipped int) {
+	r := s.broadcastSync(BroadcastMsg{Channel: channel, Data: data})
+	return r.delivered, r.skipped
+}
+
+// 放入广播队列并等待事件循环（或投递 worker）投递完成
+func (s *Server) broadcastSync(msg BroadcastMsg) deliveryResult {
+	result := make(chan deliveryResult, 1)
+	msg.result = result
+	s.broadcast <- msg
+	s.publishBackplane(msg)
+	return <-result
+}
+
+// 带关联ID的广播
+func (s *Server) BroadcastWithCorrelation(channel string, data interface{}, correlationID string) {
+	msg := BroadcastMsg{
+		Channel:       channel,
+		Data:          data,
+		CorrelationID: correlationID,
+	}
+	s.broadcast <- msg
+	s.publishBackplane(msg)
+}
+
+// 广播到频道，但跳过指定客户端，避免发布者收到自己消息的回显
+func (s *Server) BroadcastToChannelExcept(channel string, data interface{}, exceptClientID string) {
+	msg := BroadcastMsg{
+		Channel: channel,
+		Data:    data,
+		Except:  exceptClientID,
+	}
+	s.broadcast <- msg
+	// 被排除的客户端只在本实例上，其它实例照常投递
+	s.publishBackplane(msg)
+}
+
+// 批量广播：整批作为一个事件交给事件循环，按顺序连续处理，中间不会插入其它广播。
+// 开启 FanoutWorkers 时只保证同一频道内的顺序：不同频道由不同 worker 投递，可能以任意顺序到达，
+// 紧急广播也可能插在批量消息之间。消息不做持久化，进程崩溃时批量中尚未投递的消息会丢失，
+// 慢客户端也可能只收到其中一部分
+func (s *Server) BroadcastBatch(msgs []BroadcastMsg) {
+	if len(msgs) == 0 {
+		return
+	}
+	s.batch <- append([]BroadcastMsg(nil), msgs...)
+}
+
+// 随机选出约 fraction 比例的订阅者投递消息，返回实际送达的连接数。
+// 可用于 A/B 测试或在线上频道内做灰度推送
+func (s *Server) BroadcastToSample(channel string, fraction float64, data interface{}) int {
+	if fraction <= 0 {
+		return 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	result := make(chan deliveryResult, 1)
+	s.broadcast <- BroadcastMsg{
+		Channel: channel,
+		Data:    data,
+		sample:  fraction,
+		result:  result,
+	}
+	return (<-result).delivered
+}
+
+// 紧急广播：事件循环总是先处理它，不会排在已积压的普通广播之后。
+// 因此它可能比更早调用 BroadcastToChannel 的消息先送达，同一频道的普通广播与紧急广播之间不保证顺序
+func (s *Server) BroadcastUrgent(channel string, data interface{}) {
+	s.urgent <- BroadcastMsg{
+		Channel: channel,
+		Data:    data,
+	}
+}
+
+// 频道当前订阅者的客户端ID（按ID排序）
+func (s *Server) ChannelSubscribers(channel string) []string {
+	clients := s.subscriptions.snapshot(channel)
+	ids := make([]string, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, client.ID)
 	}
-	s.subscriptions[channel][client] = true
 
-	// 发送订阅确认
+	sort.Strings(ids)
+	return ids
+}
+
+// 频道当前的订阅数
+func (s *Server) ChannelCount(channel string) int {
+	return s.subscriptions.count(channel)
+}
+
+// 依次对频道的每个订阅者调用 fn（顺序不定），fn 返回 false 时停止，不复制订阅者列表。
+// 遍历期间持有该频道分片的读锁：fn 不能阻塞，也不能调用服务器的方法（订阅、广播等会获取同一把锁，导致死锁）
+func (s *Server) ForEachSubscriber(channel string, fn func(*Client) bool) {
+	s.subscriptions.forEach(channel, fn)
+}
+
+// 至少有一个订阅者的频道（按名称排序）
+func (s *Server) Channels() []string {
+	counts := s.subscriptions.counts()
+	channels := make([]string, 0, len(counts))
+	for channel := range counts {
+		channels = append(channels, channel)
+	}
+
+	sort.Strings(channels)
+	return channels
+}
+
+// 向单个客户端发送私信。与广播一样，客户端发送缓冲区已满时将其断开
+func (s *Server) SendToClient(clientID string, data interface{}) error {
 	response := Response{
-		ClientID: client.ID,
-		Action:   "subscribe",
-		Channel:  channel,
-		Code:     200,
+		ClientID: clientID,
+		Action:   "message",
+		Code:     CodeSuccess,
 		Msg:      "success",
+		Data:     data,
+		SentAt:   time.Now().UnixMilli(),
+	}
+	if s.OutboundInterceptor != nil {
+		s.mu.RLock()
+		client := s.byID[clientID]
+		s.mu.RUnlock()
+		if client != nil && !s.intercept(client, &response) {
+			return ErrMessageBlocked
+		}
+	}
+	payload, ok := s.marshal(response)
+	if !ok {
+		return errors.New("消息序列化失败")
+	}
+	return s.sendFrameTo(clientID, s.frame(payload))
+}
+
+// 向用户的所有连接（如手机和电脑）各发送一条消息，格式同 SendToClient，返回放入发送队列的连接数。
+// 发送缓冲区已满的连接会被断开，不计入返回值；用户没有在线连接时返回 0
+func (s *Server) SendToUser(userID string, data interface{}) (delivered int) {
+	if userID == "" {
+		return 0
+	}
+	response := Response{
+		Action: "message",
+		Code:   CodeSuccess,
+		Msg:    "success",
+		Data:   data,
+		SentAt: time.Now().UnixMilli(),
+	}
+
+	var slow []*Client
+	s.mu.RLock()
+	for client := range s.byUser[userID] {
+		response.ClientID = client.ID
+		outgoing := response
+		if !s.intercept(client, &outgoing) {
+			continue
+		}
+		payload, ok := s.marshal(outgoing)
+		if !ok {
+			break
+		}
+		if s.enqueue(client, s.frame(payload)) {
+			delivered++
+		} else {
+			slow = append(slow, client)
+		}
 	}
-	data, _ := json.Marshal(response)
-	client.Send <- data
+	s.mu.RUnlock()
 
-	log.Printf("客户端 %s 订阅了频道 %s", client.ID, channel)
+	for _, client := range slow {
+		s.Logger.Warn("发送缓冲区已满，断开连接", "event", "slow_client", "client_id", client.ID, "user_id", userID)
+		s.Metrics.SlowEvictions.Add(1)
+		select {
+		case s.unregister <- client:
+		case <-s.done:
+		}
+	}
+	return delivered
 }
 
-// 处理取消订阅
-func (s *Server) handleUnsubscribe(client *Client, channel string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// 向单个客户端发送一条二进制消息（如 protobuf/msgpack），处理方式同 SendToClient
+func (s *Server) SendBinaryToClient(clientID string, payload []byte) error {
+	return s.sendFrameTo(clientID, OutboundMessage{Type: websocket.BinaryMessage, Payload: payload})
+}
 
-	// 从客户端订阅列表移除
-	delete(client.Channels, channel)
+// 按ID向单个客户端发送一帧，按 SlowClientPolicy 处理后仍放不进缓冲区时断开该客户端
+func (s *Server) sendFrameTo(clientID string, frame OutboundMessage) error {
+	// 持有读锁期间客户端不会被注销，Send 不会被关闭
+	s.mu.RLock()
+	client := s.byID[clientID]
+	sent := client != nil && s.enqueue(client, frame)
+	s.mu.RUnlock()
 
-	// 从频道订阅列表移除
-	if subs, ok := s.subscriptions[channel]; ok {
-		delete(subs, client)
-		if len(subs) == 0 {
-			delete(s.subscriptions, channel)
+	if client == nil {
+		return ErrClientNotFound
+	}
+	if !sent {
+		s.Logger.Warn("发送缓冲区已满，断开连接", "event", "slow_client", "client_id", clientID)
+		s.Metrics.SlowEvictions.Add(1)
+		// 事件循环已退出时不再注销，避免阻塞调用方
+		select {
+		case s.unregister <- client:
+		case <-s.done:
 		}
+		return ErrClientSlow
 	}
+	return nil
+}
 
-	// 发送取消订阅确认
+// 通知客户端迁移到另一个服务器实例：先下发带目标地址的 redirect 消息，
+// 稍后以 CloseMigrate 关闭连接，客户端应重新连接到该地址。用于手动重新均衡连接
+func (s *Server) RedirectClient(clientID, url string) error {
 	response := Response{
-		ClientID: client.ID,
-		Action:   "unsubscribe",
-		Channel:  channel,
-		Code:     200,
-		Msg:      "success",
+		ClientID: clientID,
+		Action:   "redirect",
+		Code:     CodeSuccess,
+		Msg:      "migrate",
+		Data:     map[string]string{"url": url},
+	}
+	data, ok := s.marshal(response)
+	if !ok {
+		return errors.New("redirect 消息序列化失败")
+	}
+
+	// 持有读锁期间客户端不会被注销，Send 不会被关闭
+	s.mu.RLock()
+	target := s.byID[clientID]
+	if target != nil && s.OutboundInterceptor != nil {
+		data, ok = s.marshalFor(target, response)
+	}
+	if target != nil && ok && !s.trySend(target, data) {
+		s.Logger.Warn("发送缓冲区已满，redirect 消息未送达", "event", "slow_client", "client_id", clientID)
+	}
+	s.mu.RUnlock()
+
+	if target == nil {
+		return ErrClientNotFound
 	}
-	data, _ := json.Marshal(response)
-	client.Send <- data
 
-	log.Printf("客户端 %s 取消订阅频道 %s", client.ID, channel)
+	time.AfterFunc(redirectGrace, func() {
+		s.Logger.Info("客户端迁移，关闭连接", "event", "redirect", "client_id", clientID, "url", url)
+		s.closeClient(target, CloseMigrate, "migrate")
+	})
+	return nil
 }
 
-// 处理心跳
-func (s *Server) handlePing(client *Client) {
-	response := Response{
-		ClientID: client.ID,
-		Action:   "pong",
-		Code:     200,
-		Msg:      "success",
+// 关闭帧的原因最多 123 字节（控制帧负载上限 125 减去 2 字节状态码）
+const maxCloseReason = 123
+
+// 管理员强制断开客户端：发送 ClosePolicyViolation 关闭帧后注销。
+// 可在任意 goroutine 调用；客户端已离开时返回 ErrClientNotFound，重复调用无副作用
+func (s *Server) Disconnect(clientID string, reason string) error {
+	s.mu.RLock()
+	client := s.byID[clientID]
+	s.mu.RUnlock()
+
+	if client == nil {
+		return ErrClientNotFound
 	}
-	data, _ := json.Marshal(response)
-	client.Send <- data
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+
+	s.Logger.Info("管理员断开客户端", "event", "kick", "client_id", clientID, "reason", reason)
+	s.closeClient(client, websocket.ClosePolicyViolation, reason)
+	// removeClient 是幂等的，readPump 退出时的再次注销不会重复清理
+	select {
+	case s.unregister <- client:
+	case <-s.done:
+	}
+	return nil
 }
 
-// 广播消息到频道
-func (s *Server) BroadcastToChannel(channel string, data interface{}) {
-	s.broadcast <- BroadcastMsg{
-		Channel: channel,
-		Data:    data,
+// 按环境变量构造日志：LOG_FORMAT=json 输出 JSON，LOG_LEVEL 为 debug/info/warn/error（默认 info）
+func newLogger(format, level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
 	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// 环境变量未设置时使用默认值
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }
 
 func main() {
-	server := NewServer()
+	// 命令行参数优先，其次环境变量
+	addr := flag.String("addr", envOr("LISTEN_ADDR", ":8089"), "监听地址")
+	certFile := flag.String("tls-cert", os.Getenv("TLS_CERT_FILE"), "TLS 证书文件，与 -tls-key 同时设置时启用 wss")
+	keyFile := flag.String("tls-key", os.Getenv("TLS_KEY_FILE"), "TLS 私钥文件")
+	flag.Parse()
+	if (*certFile == "") != (*keyFile == "") {
+		log.Fatal("TLS 证书和私钥必须同时设置")
+	}
+
+	// 本地开发允许任意来源，生产环境应改为具体的 AllowedOrigins
+	config := DefaultServerConfig()
+	config.AllowedOrigins = []string{"*"}
+	config.CompressionEnabled = true
+	server := NewServer(config)
+	server.Logger = newLogger(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	// 多实例部署时通过 Redis 转发广播
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		server.Backplane = NewRedisBackplane(redis.NewClient(&redis.Options{Addr: redisAddr}), "")
+	}
 	go server.Run()
 
-	// HTTP路由
-	http.HandleFunc("/ws", server.HandleWebSocket)
+	// HTTP路由，WebSocket 端点外包一层请求日志
+	server.Use(RequestLogger(server.Logger))
+	http.Handle("/ws", server.Handler())
+
+	// 调试用的状态导出接口。管理接口凭 ADMIN_TOKEN（Authorization: Bearer <token>）访问，未设置时一律拒绝
+	server.RedactKeys = []string{"query.token"}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		server.AdminAuthorizer = BearerTokenAuthorizer(token)
+	} else {
+		log.Printf("未设置 ADMIN_TOKEN，管理接口将全部返回 403")
+	}
+	http.HandleFunc("/admin/state", server.HandleDumpState)
+	http.HandleFunc("/admin/clients", server.HandleClients)
+	http.HandleFunc("/admin/kick", server.HandleKick)
+	http.HandleFunc("/clients/", server.HandleClientInfo)
+	http.HandleFunc("/metrics", server.HandleMetrics)
+	http.HandleFunc("/stats", server.HandleStats)
+	http.HandleFunc("/poll", server.HandlePoll)
 
 	// 测试用的广播接口（可选）
-	http.HandleFunc("/broadcast", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	http.HandleFunc("/broadcast", server.HandleBroadcast)
+
+	// 同时提供证书和私钥时启用 TLS（wss://），否则为明文
+	useTLS := *certFile != "" && *keyFile != ""
+	wsScheme, httpScheme := "ws", "http"
+	if useTLS {
+		wsScheme, httpScheme = "wss", "https"
+	}
+	log.Printf("WebSocket服务器启动在 %s", *addr)
+	log.Printf("WebSocket端点: %s://localhost%s/ws", wsScheme, *addr)
+	log.Printf("广播测试端点: %s://localhost%s/broadcast", httpScheme, *addr)
+	log.Printf("状态导出端点: %s://localhost%s/admin/state", httpScheme, *addr)
+	log.Printf("踢出客户端端点: %s://localhost%s/admin/kick", httpScheme, *addr)
+	log.Printf("指标端点: %s://localhost%s/metrics", httpScheme, *addr)
+	log.Printf("统计端点: %s://localhost%s/stats", httpScheme, *addr)
+	log.Printf("客户端详情端点: %s://localhost%s/clients/{id}", httpScheme, *addr)
+	log.Printf("长轮询端点: %s://localhost%s/poll", httpScheme, *addr)
 
-		var req struct {
-			Channel string      `json:"channel"`
-			Data    interface{} `json:"data"`
+	// 请求头必须在握手超时内发完，避免慢速握手长期占用连接
+	httpServer := &http.Server{Addr: *addr, ReadHeaderTimeout: config.HandshakeTimeout}
+	go func() {
+		var err error
+		if useTLS {
+			err = httpServer.ListenAndServeTLS(*certFile, *keyFile)
+		} else {
+			err = httpServer.ListenAndServe()
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("服务器启动失败:", err)
 		}
+	}()
 
-		server.BroadcastToChannel(req.Channel, req.Data)
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Broadcast sent"))
-	})
-
-	port := ":8089"
-	log.Printf("WebSocket服务器启动在端口 %s", port)
-	log.Printf("WebSocket端点: ws://localhost%s/ws", port)
-	log.Printf("广播测试端点: http://localhost%s/broadcast", port)
+	// 收到 SIGINT/SIGTERM 后优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
 
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatal("服务器启动失败:", err)
+	log.Printf("收到退出信号，开始关闭")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("关闭未完成: %v", err)
 	}
+	httpServer.Shutdown(shutdownCtx)
 }
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscribeReceivesBroadcast(t *testing.T) {
+	s, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+
+	s.BroadcastToChannel("room", "hello")
+	msg := c.Expect("message")
+	if msg.Channel != "room" || msg.Data != "hello" {
+		t.Fatalf("收到 %+v", msg)
+	}
+}
+
+func TestHandleMessageRejections(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+
+	tests := []struct {
+		name string
+		msg  Message
+		code int
+	}{
+		{"unknown action", Message{Action: "dance"}, CodeUnknownAction},
+		{"subscribe without channel", Message{Action: "subscribe"}, CodeMissingChannel},
+		{"publish without subscription", Message{Action: "publish", Channel: "room"}, CodeNotSubscribed},
+		{"invalid channel", Message{Action: "subscribe", Channel: "bad channel"}, CodeInvalidChannel},
+	}
+	for _, tt := range tests {
+		c.Send(tt.msg)
+		if resp := c.Expect(tt.msg.Action); resp.Code != tt.code {
+			t.Errorf("%s: code = %d, want %d (%s)", tt.name, resp.Code, tt.code, resp.Msg)
+		}
+	}
+}
+
+func TestReadOnlyFromAuthorizer(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+		s.Authorizer = func(r *http.Request, userID string) (ConnectionGrant, error) {
+			if userID == "mallory" {
+				return ConnectionGrant{}, errors.New("banned")
+			}
+			return ConnectionGrant{ReadOnly: userID == "monitor"}, nil
+		}
+	})
+
+	// 服务器授予的只读不能被 ?readonly=false 解除
+	c := Dial(t, ts, "user=monitor&readonly=false")
+	c.Subscribe("room")
+	if resp := c.Publish("room", "hi"); resp.Code != CodeForbidden {
+		t.Fatalf("只读连接发布: code = %d, want 403", resp.Code)
+	}
+	c.Send(Message{Action: "whoami"})
+	if info := c.Expect("whoami").Data.(map[string]interface{}); info["readOnly"] != true {
+		t.Fatalf("whoami = %v", info)
+	}
+
+	// 普通用户可以自愿只读，也可以正常发布
+	c = Dial(t, ts, "user=alice&readonly=true")
+	c.Subscribe("room")
+	if resp := c.Publish("room", "hi"); resp.Code != CodeForbidden {
+		t.Fatalf("?readonly=true 发布: code = %d, want 403", resp.Code)
+	}
+	c = Dial(t, ts, "user=bob")
+	c.Subscribe("room")
+	if resp := c.Publish("room", "hi"); resp.Code != CodeSuccess {
+		t.Fatalf("普通连接发布: code = %d", resp.Code)
+	}
+
+	// 授权失败时不升级
+	_, resp, err := dialRaw(ts, "user=mallory", nil, websocket.DefaultDialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("授权失败应返回 403，err=%v", err)
+	}
+}
+
+func TestSlowSendAfterEventLoopExitDoesNotBlock(t *testing.T) {
+	s := NewServer(DefaultServerConfig())
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := &Client{ID: "c1", UserID: "u1", Send: make(chan OutboundMessage)}
+	s.clients[client] = true
+	s.byID[client.ID] = client
+	s.byUser[client.UserID] = map[*Client]bool{client: true}
+	// 事件循环已退出，没有人再读 unregister
+	close(s.done)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if err := s.SendToClient("c1", "hi"); err != ErrClientSlow {
+			t.Errorf("SendToClient err = %v, want ErrClientSlow", err)
+		}
+		s.SendToUser("u1", "hi")
+	}()
+	select {
+	case <-finished:
+	case <-time.After(testTimeout):
+		t.Fatal("事件循环退出后发送给慢客户端阻塞")
+	}
+}
+
+func TestEventLoopSurvivesHookPanic(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MessageFilter = func(client *Client, channel string, data interface{}) (interface{}, bool) {
+			if channel == "boom" {
+				panic("bad hook")
+			}
+			return data, true
+		}
+	})
+	victim := Dial(t, ts, "")
+	victim.Subscribe("boom")
+	other := Dial(t, ts, "")
+	other.Subscribe("room")
+
+	s.BroadcastToChannel("boom", "x")
+	s.BroadcastToChannel("room", "still here")
+	if msg := other.Expect("message"); msg.Data != "still here" {
+		t.Fatalf("收到 %v", msg.Data)
+	}
+	if n := s.PanicCount(); n != 1 {
+		t.Fatalf("PanicCount = %d, want 1", n)
+	}
+	// 事件循环仍然处理注册
+	Dial(t, ts, "").Subscribe("room")
+}
+
+func TestDeterministicFanoutOrder(t *testing.T) {
+	var order []string
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.DeterministicFanout = true
+		s.MessageFilter = func(client *Client, channel string, data interface{}) (interface{}, bool) {
+			order = append(order, client.ID)
+			return data, true
+		}
+	})
+	for i := 0; i < 8; i++ {
+		Dial(t, ts, "").Subscribe("room")
+	}
+
+	// MessageFilter 在事件循环中按投递顺序调用，同步广播返回后 order 已完整
+	for round := 0; round < 3; round++ {
+		order = order[:0]
+		s.BroadcastToChannelSync("room", round)
+		if len(order) != 8 || !sort.StringsAreSorted(order) {
+			t.Fatalf("第 %d 次投递顺序 %v，应按客户端ID排序", round, order)
+		}
+	}
+}
+
+func TestSlowClientRemovedOnce(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 2
+	})
+	events := s.Events()
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	client := serverClient(s, c.ID)
+
+	// 暂停后发送缓冲区很快被填满，之后的每次广播都会发现它是慢客户端
+	client.Pause()
+	for i := 0; i < 10; i++ {
+		s.BroadcastToChannel("room", i)
+	}
+	c.ExpectClosed()
+	waitFor(t, "client removed", func() bool { return connectionCount(s) == 0 })
+
+	disconnects := 0
+	for {
+		select {
+		case event := <-events:
+			if event.Type == EventDisconnect && event.ClientID == c.ID {
+				disconnects++
+			}
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if disconnects != 1 {
+		t.Fatalf("disconnect 事件 %d 次, want 1", disconnects)
+	}
+	if n := s.PanicCount(); n != 0 {
+		t.Fatalf("PanicCount = %d", n)
+	}
+}
+
+func TestFullSendBufferDoesNotWedgeServer(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 4
+		s.SlowClientPolicy = SlowClientDropNewest
+	})
+	stuck := Dial(t, ts, "")
+	stuck.Subscribe("room")
+	client := serverClient(s, stuck.ID)
+	client.Pause()
+	for i := 0; i < 10; i++ {
+		s.BroadcastToChannelSync("room", i)
+	}
+
+	// 缓冲区满的客户端再订阅，确认发不出去也不能卡住持有锁的处理流程
+	stuck.Send(Message{Action: "subscribe", Channel: "other"})
+	stuck.Send(Message{Action: "ping"})
+
+	c := Dial(t, ts, "")
+	c.Subscribe("other")
+	s.BroadcastToChannel("other", "ok")
+	if msg := c.Expect("message"); msg.Data != "ok" {
+		t.Fatalf("收到 %v", msg.Data)
+	}
+}
+
+func TestOversizedMessageClosesConnection(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxMessageSize = 1024
+	})
+	c := Dial(t, ts, "")
+	c.Send(Message{Action: "publish", Channel: "room", Data: strings.Repeat("x", 2048)})
+
+	if code, _ := c.ExpectClosed(); code != websocket.CloseMessageTooBig {
+		t.Fatalf("关闭码 %d, want %d", code, websocket.CloseMessageTooBig)
+	}
+	waitFor(t, "client removed", func() bool { return connectionCount(s) == 0 })
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.IdleTimeout = 300 * time.Millisecond
+	})
+	idle := Dial(t, ts, "")
+	active := Dial(t, ts, "")
+
+	// 持续发送消息的客户端不会超时，一直沉默的客户端被断开
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		active.Send(Message{Action: "ping"})
+		active.Expect("pong")
+	}
+	code, reason := idle.ExpectClosed()
+	if code != CloseIdle || reason != "idle timeout" {
+		t.Fatalf("关闭 %d %q, want %d idle timeout", code, reason, CloseIdle)
+	}
+	waitFor(t, "idle client removed", func() bool { return serverClient(s, idle.ID) == nil })
+	if serverClient(s, active.ID) == nil {
+		t.Fatal("活跃的客户端不应被断开")
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	var seq atomic.Int64
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.IDGenerator = func() string { return fmt.Sprintf("node3-%d", seq.Add(1)) }
+	})
+	for i := 1; i <= 3; i++ {
+		c := Dial(t, ts, "")
+		if want := fmt.Sprintf("node3-%d", i); c.ID != want {
+			t.Fatalf("连接确认中的ID = %q, want %q", c.ID, want)
+		}
+		if serverClient(s, c.ID) == nil {
+			t.Fatalf("服务器上找不到 %s", c.ID)
+		}
+	}
+}
+
+func TestRequestIDEchoed(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+
+	c.Send(Message{Action: "subscribe", Channel: "a", RequestID: "req-1"})
+	c.Send(Message{Action: "subscribe", Channel: "b", RequestID: "req-2"})
+	c.Send(Message{Action: "dance", RequestID: "req-3"})
+	c.Send(Message{Action: "ping"})
+
+	want := map[string]string{"a": "req-1", "b": "req-2"}
+	for i := 0; i < 2; i++ {
+		ack := c.Expect("subscribe")
+		if ack.RequestID != want[ack.Channel] {
+			t.Fatalf("频道 %s 的确认带回 %q, want %q", ack.Channel, ack.RequestID, want[ack.Channel])
+		}
+	}
+	if resp := c.Expect("dance"); resp.RequestID != "req-3" {
+		t.Fatalf("错误响应带回 %q", resp.RequestID)
+	}
+	// 没有 requestId 时响应中也不出现
+	_, payload, err := c.NextFrame(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(payload), "requestId") {
+		t.Fatalf("不应包含 requestId: %s", payload)
+	}
+}
+
+func TestConnectAckIsFirstMessage(t *testing.T) {
+	// OnConnect 立即发私信，自动订阅也会产生确认，连接确认仍必须是第一条
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 2
+		s.OnConnect = func(client *Client) {
+			s.SendToClient(client.ID, "welcome")
+		}
+	})
+	for i := 0; i < 20; i++ {
+		c := DialHeader(t, ts, "channels=a", nil)
+		resp, err := c.NextMessage(testTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Action != "connect" {
+			t.Fatalf("第一条消息是 %q, want connect", resp.Action)
+		}
+		c.Conn.Close()
+	}
+}
+
+func TestCanSubscribe(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+		s.CanSubscribe = func(client *Client, channel string) bool {
+			return !strings.HasPrefix(channel, "admin.") || client.UserID == "root"
+		}
+	})
+	alice := Dial(t, ts, "user=alice")
+	alice.Send(Message{Action: "subscribe", Channel: "admin.feed"})
+	if resp := alice.Expect("subscribe"); resp.Code != CodeForbidden {
+		t.Fatalf("code = %d, want 403", resp.Code)
+	}
+	alice.Subscribe("lobby")
+
+	root := Dial(t, ts, "user=root")
+	root.Subscribe("admin.feed")
+	if got := s.ChannelSubscribers("admin.feed"); len(got) != 1 || got[0] != root.ID {
+		t.Fatalf("admin.feed 的订阅者 %v", got)
+	}
+
+	s.BroadcastToChannel("admin.feed", "secret")
+	root.Expect("message")
+	alice.ExpectNone(100 * time.Millisecond)
+}
+
+func TestCanSubscribeAppliesToWildcardMatches(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+		s.CanSubscribe = func(client *Client, channel string) bool {
+			return !strings.HasPrefix(channel, "admin.") || client.UserID == "root"
+		}
+	})
+	// 模式本身被允许，但匹配到的 admin.* 频道不应投递给 alice
+	alice := Dial(t, ts, "user=alice")
+	alice.Subscribe("*.feed")
+	alice.Subscribe("**")
+	root := Dial(t, ts, "user=root")
+	root.Subscribe("*.feed")
+
+	s.BroadcastToChannel("admin.feed", "secret")
+	s.BroadcastToChannel("news.feed", "public")
+	if msg := root.Expect("message"); msg.Channel != "admin.feed" {
+		t.Fatalf("root 收到 %+v", msg)
+	}
+	root.Expect("message")
+	if msg := alice.Expect("message"); msg.Channel != "news.feed" {
+		t.Fatalf("alice 通过通配订阅收到 %+v", msg)
+	}
+	alice.ExpectNone(100 * time.Millisecond)
+}
+
+func TestWriteTimeoutDisconnectsStuckReader(t *testing.T) {
+	reasons := make(chan string, 1)
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.WriteTimeout = 200 * time.Millisecond
+		s.SendBufferSize = 64
+		s.OnDisconnect = func(client *Client, code int, reason string) { reasons <- reason }
+	})
+
+	// 从不读取的客户端：TCP 窗口填满后 writePump 的写入阻塞，到 WriteTimeout 后失败
+	conn, _, err := dialRaw(ts, "channels=big", nil, websocket.DefaultDialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "auto subscription", func() bool { return len(s.ChannelSubscribers("big")) == 1 })
+
+	payload := strings.Repeat("x", 1<<20)
+	for i := 0; i < 32; i++ {
+		s.BroadcastToChannel("big", payload)
+	}
+
+	select {
+	case reason := <-reasons:
+		if reason != "write timeout" {
+			t.Fatalf("断开原因 %q, want write timeout", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("写入没有超时")
+	}
+	if n := s.Metrics.WriteTimeouts.Load(); n != 1 {
+		t.Fatalf("WriteTimeouts = %d, want 1", n)
+	}
+}
+
+func TestSendToUser(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+	})
+	phone := Dial(t, ts, "user=alice")
+	laptop := Dial(t, ts, "user=alice")
+	bob := Dial(t, ts, "user=bob")
+
+	if n := s.SendToUser("alice", "hello"); n != 2 {
+		t.Fatalf("SendToUser = %d, want 2", n)
+	}
+	for _, c := range []*TestClient{phone, laptop} {
+		if msg := c.Expect("message"); msg.Data != "hello" {
+			t.Fatalf("收到 %v", msg.Data)
+		}
+	}
+	bob.ExpectNone(100 * time.Millisecond)
+
+	// 断开的连接从索引中移除
+	phone.Conn.Close()
+	waitFor(t, "phone unregistered", func() bool { return serverClient(s, phone.ID) == nil })
+	if n := s.SendToUser("alice", "again"); n != 1 {
+		t.Fatalf("断开一个连接后 SendToUser = %d, want 1", n)
+	}
+	laptop.Expect("message")
+	if n := s.SendToUser("nobody", "x"); n != 0 {
+		t.Fatalf("没有在线连接的用户 SendToUser = %d, want 0", n)
+	}
+}
+
+// 连接测试服务器，丢弃前 drop 个 ping（不回 pong），之后正常回应；drop < 0 时从不回应
+func dialDroppingPings(t *testing.T, ts *httptest.Server, drop int) (*TestClient, *atomic.Int64) {
+	t.Helper()
+	conn, _, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pings := &atomic.Int64{}
+	conn.SetPingHandler(func(data string) error {
+		if n := pings.Add(1); drop < 0 || n <= int64(drop) {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	c := newTestClient(t, conn)
+	c.ID = c.Expect("connect").ClientID
+	return c, pings
+}
+
+func TestMissedPongsTolerated(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.PingInterval = 50 * time.Millisecond
+		s.PongWait = 75 * time.Millisecond
+		s.MaxMissedPongs = 3
+	})
+
+	// 丢掉两个 pong 后恢复的客户端保持连接
+	flaky, flakyPings := dialDroppingPings(t, ts, 2)
+	// 一直不回应的客户端在第三个 ping 没有回应后断开
+	dead, _ := dialDroppingPings(t, ts, -1)
+
+	dead.ExpectClosed()
+	waitFor(t, "flaky client recovered", func() bool { return flakyPings.Load() >= 6 })
+	if serverClient(s, flaky.ID) == nil {
+		t.Fatal("恢复回应 pong 的客户端被断开")
+	}
+	if n := s.Metrics.MissedPongEvictions.Load(); n != 1 {
+		t.Fatalf("MissedPongEvictions = %d, want 1", n)
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+	})
+	c := Dial(t, ts, "user=alice")
+	c.Subscribe("b")
+	c.Subscribe("a")
+
+	c.Send(Message{Action: "whoami", RequestID: "w1"})
+	resp := c.Expect("whoami")
+	if resp.RequestID != "w1" {
+		t.Fatalf("requestId = %q", resp.RequestID)
+	}
+	var info WhoAmI
+	raw, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ClientID != c.ID || info.UserID != "alice" || info.ConnectedAt.IsZero() {
+		t.Fatalf("whoami = %+v", info)
+	}
+	if !reflect.DeepEqual(info.Channels, []string{"a", "b"}) {
+		t.Fatalf("订阅的频道 %v, want [a b]", info.Channels)
+	}
+}
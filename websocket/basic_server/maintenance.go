@@ -0,0 +1,68 @@
+package main
+
+import "net/http"
+
+// 维护模式下拒绝的操作：它们会建立新的订阅或发布消息，其余操作（ping、取消订阅、历史等）照常处理
+var maintenanceBlockedActions = map[string]bool{
+	"subscribe": true,
+	"publish":   true,
+}
+
+// 维护模式的状态，整体替换以保证开关和原因一致
+type maintenanceState struct {
+	reason string
+}
+
+// 进入或退出维护模式：期间已有连接保持，订阅和发布返回 CodeMaintenance 并带上 reason；
+// MaintenanceRejectConnections 为 true 时新连接返回 503。MaintenanceAnnounce 为 true 时
+// 向所有连接发送一条公告，此时事件循环必须在运行，不能在事件循环中调用
+func (s *Server) SetMaintenance(on bool, reason string) {
+	if on {
+		s.maintenance.Store(&maintenanceState{reason: reason})
+	} else {
+		s.maintenance.Store(nil)
+	}
+	s.Logger.Info("维护模式", "event", "maintenance", "on", on, "reason", reason)
+
+	if s.MaintenanceAnnounce {
+		s.BroadcastToAll(map[string]interface{}{"maintenance": on, "reason": reason})
+	}
+}
+
+// 是否处于维护模式，以及进入时给出的原因
+func (s *Server) Maintenance() (on bool, reason string) {
+	state := s.maintenance.Load()
+	if state == nil {
+		return false, ""
+	}
+	return true, state.reason
+}
+
+// 维护模式下拒绝该操作并回复客户端，返回是否已拒绝
+func (s *Server) rejectInMaintenance(client *Client, action, channel, requestID string) bool {
+	on, reason := s.Maintenance()
+	if !on || !maintenanceBlockedActions[action] {
+		return false
+	}
+	if reason == "" {
+		reason = "server under maintenance"
+	}
+	response := errorResponse(client, action, CodeMaintenance, reason)
+	response.RequestID = requestID
+	response.Channel = channel
+	s.sendResponse(client, response)
+	return true
+}
+
+// 维护模式下是否拒绝新连接，拒绝时已写出 503
+func (s *Server) rejectConnectionInMaintenance(w http.ResponseWriter) bool {
+	on, reason := s.Maintenance()
+	if !on || !s.MaintenanceRejectConnections {
+		return false
+	}
+	if reason == "" {
+		reason = "Server under maintenance"
+	}
+	http.Error(w, reason, http.StatusServiceUnavailable)
+	return true
+}
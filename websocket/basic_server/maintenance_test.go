@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaintenanceAnnounce = true
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+
+	s.SetMaintenance(true, "deploying")
+	if msg := c.Expect("announcement"); !reflect.DeepEqual(msg.Data, map[string]interface{}{"maintenance": true, "reason": "deploying"}) {
+		t.Fatalf("公告 %v", msg.Data)
+	}
+	for _, action := range []string{"subscribe", "publish"} {
+		c.Send(Message{Action: action, Channel: "room", Data: "x", RequestID: action})
+		resp := c.Expect(action)
+		if resp.Code != CodeMaintenance || resp.Msg != "deploying" || resp.RequestID != action {
+			t.Fatalf("维护模式下的 %s 响应 %+v", action, resp)
+		}
+	}
+	// 已有连接保持，ping 和已有订阅照常工作，新连接默认仍然接受
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+	s.BroadcastToChannel("room", "still here")
+	c.Expect("message")
+	Dial(t, ts, "")
+
+	s.SetMaintenance(false, "")
+	c.Expect("announcement")
+	c.Subscribe("other")
+}
+
+func TestMaintenanceRejectsConnections(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaintenanceRejectConnections = true
+	})
+	s.SetMaintenance(true, "")
+	_, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("维护模式下的新连接应返回 503: %v", err)
+	}
+	s.SetMaintenance(false, "")
+	Dial(t, ts, "")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 发送缓冲区已满时的处理方式
+type SlowClientPolicy int
+
+const (
+	SlowClientEvict      SlowClientPolicy = iota // 断开客户端（默认）
+	SlowClientDropNewest                         // 丢弃这条新消息，保留连接
+	SlowClientDropOldest                         // 丢弃队列中最早的一条，再放入新消息
+	SlowClientBlock                              // 最多等待 SlowClientTimeout，超时后断开
+)
+
+// 单个客户端发送队列中的字节数，同时计入全局总量
+type queueAccount struct {
+	mu       sync.Mutex
+	bytes    int64
+	released bool // 客户端已注销，不再计入全局总量
+}
+
+// 非阻塞发送文本消息，缓冲区满时返回 false
+func (s *Server) trySend(client *Client, data []byte) bool {
+	return s.trySendFrame(client, s.frame(data))
+}
+
+// 非阻塞发送一帧，缓冲区满时返回 false
+func (s *Server) trySendFrame(client *Client, message OutboundMessage) bool {
+	s.stamp(&message)
+	s.accountEnqueue(client, len(message.Payload))
+	select {
+	case client.Send <- message:
+		s.checkHighWater(client)
+		return true
+	default:
+		s.accountDequeue(client, len(message.Payload))
+		return false
+	}
+}
+
+// 按 SlowClientPolicy 把一帧放入发送队列，返回 false 表示应断开该客户端。
+// 调用方需保证 Send 未被关闭（在事件循环中或持有 s.mu）
+func (s *Server) enqueue(client *Client, message OutboundMessage) bool {
+	if s.trySendFrame(client, message) {
+		return true
+	}
+
+	switch s.SlowClientPolicy {
+	case SlowClientDropNewest:
+		s.Metrics.SlowDrops.Add(1)
+		return true
+
+	case SlowClientDropOldest:
+		select {
+		case old := <-client.Send:
+			s.accountDequeue(client, len(old.Payload))
+		default:
+		}
+		// 腾出的位置可能被并发的发送者抢走，此时退化为丢弃新消息；两种情况都只丢一条
+		s.trySendFrame(client, message)
+		s.Metrics.SlowDrops.Add(1)
+		return true
+
+	case SlowClientBlock:
+		timer := time.NewTimer(s.SlowClientTimeout)
+		defer timer.Stop()
+		s.stamp(&message)
+		s.accountEnqueue(client, len(message.Payload))
+		select {
+		case client.Send <- message:
+			s.checkHighWater(client)
+			return true
+		case <-timer.C:
+			s.accountDequeue(client, len(message.Payload))
+			return false
+		}
+	}
+	return false
+}
+
+// 设置了 MessageTTL 时记录入队时间
+func (s *Server) stamp(message *OutboundMessage) {
+	if s.MessageTTL > 0 {
+		message.queuedAt = time.Now()
+	}
+}
+
+// 频道消息排队超过 MessageTTL 时视为过期，由 writePump 丢弃并计数
+func (s *Server) expired(message OutboundMessage) bool {
+	if s.MessageTTL <= 0 || message.Channel == "" || message.queuedAt.IsZero() || time.Since(message.queuedAt) <= s.MessageTTL {
+		return false
+	}
+	s.Metrics.ExpiredDrops.Add(1)
+	return true
+}
+
+func (s *Server) accountEnqueue(client *Client, n int) {
+	client.queue.mu.Lock()
+	if !client.queue.released {
+		client.queue.bytes += int64(n)
+		s.bufferedBytes.Add(int64(n))
+	}
+	client.queue.mu.Unlock()
+}
+
+// writePump 取出消息后调用
+func (s *Server) accountDequeue(client *Client, n int) {
+	client.queue.mu.Lock()
+	if !client.queue.released {
+		client.queue.bytes -= int64(n)
+		s.bufferedBytes.Add(-int64(n))
+	}
+	client.queue.mu.Unlock()
+
+	if len(client.Send) < highWaterMark(cap(client.Send)) {
+		client.highWater.Store(false)
+	}
+}
+
+// 发送缓冲区达到容量的 80% 视为高水位
+func highWaterMark(capacity int) int {
+	return capacity * 4 / 5
+}
+
+// 入队后检查队列深度，刚越过高水位时记录日志、计数并调用 OnSlowClient。
+// 入队可能发生在事件循环中或持有 s.mu 时，回调放到单独的 goroutine 里
+func (s *Server) checkHighWater(client *Client) {
+	depth := len(client.Send)
+	if depth < highWaterMark(cap(client.Send)) || !client.highWater.CompareAndSwap(false, true) {
+		return
+	}
+
+	s.Logger.Warn("发送队列越过高水位", "event", "slow_client_warning", "client_id", client.ID, "depth", depth, "capacity", cap(client.Send))
+	s.Metrics.SlowWarnings.Add(1)
+	if s.OnSlowClient != nil {
+		go func() {
+			defer s.recoverClient(client, "OnSlowClient")
+			s.OnSlowClient(client, depth)
+		}()
+	}
+}
+
+func (s *Server) sendBufferSize() int {
+	if s.SendBufferSize > 0 {
+		return s.SendBufferSize
+	}
+	return defaultSendBufferSize
+}
+
+// 客户端注销时把它剩余的队列字节从全局总量中扣除
+func (s *Server) releaseAccount(client *Client) {
+	client.queue.mu.Lock()
+	if !client.queue.released {
+		s.bufferedBytes.Add(-client.queue.bytes)
+		client.queue.bytes = 0
+		client.queue.released = true
+	}
+	client.queue.mu.Unlock()
+}
+
+// 全局缓冲超限时是否丢弃发给该客户端的消息：
+// 只丢弃发给已有积压的客户端的消息，队列为空的客户端很快就能发出，不受影响
+func (s *Server) shouldShed(client *Client, n int) bool {
+	if s.MaxBufferedBytes <= 0 || s.bufferedBytes.Load()+int64(n) <= s.MaxBufferedBytes {
+		return false
+	}
+	client.queue.mu.Lock()
+	lagging := client.queue.bytes > 0
+	client.queue.mu.Unlock()
+	return lagging
+}
+
+// 当前所有客户端发送队列中的总字节数
+func (s *Server) BufferedBytes() int64 {
+	return s.bufferedBytes.Load()
+}
+
+// 因全局缓冲超限而丢弃的消息数
+func (s *Server) ShedCount() int64 {
+	return s.shed.Load()
+}
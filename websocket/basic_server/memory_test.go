@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 暂停客户端（writePump 停止取消息），向它广播 0..n-1，恢复后返回收到的消息内容
+func stalledDelivery(t *testing.T, s *Server, c *TestClient, n int) []string {
+	t.Helper()
+	client := serverClient(s, c.ID)
+	client.Pause()
+	for i := 0; i < n; i++ {
+		s.BroadcastToChannelSync("room", i)
+	}
+	client.Resume()
+
+	var got []string
+	for {
+		resp, err := c.NextMessage(200 * time.Millisecond)
+		if err != nil {
+			return got
+		}
+		if resp.Action == "message" {
+			got = append(got, fmt.Sprint(resp.Data))
+		}
+	}
+}
+
+func TestSlowClientPolicies(t *testing.T) {
+	t.Run("evict", func(t *testing.T) {
+		s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.SendBufferSize = 2
+		})
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		stalledDelivery(t, s, c, 5)
+		c.ExpectClosed()
+		if n := s.Metrics.SlowEvictions.Load(); n != 1 {
+			t.Fatalf("SlowEvictions = %d, want 1", n)
+		}
+	})
+
+	t.Run("drop newest", func(t *testing.T) {
+		s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.SendBufferSize = 2
+			s.SlowClientPolicy = SlowClientDropNewest
+		})
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		// 暂停时 writePump 可能已经取出了第一条，所以收到前 2 或 3 条
+		got := stalledDelivery(t, s, c, 5)
+		if len(got) < 2 || len(got) > 3 || got[0] != "0" || got[len(got)-1] == "4" {
+			t.Fatalf("收到 %v，应保留最早的消息", got)
+		}
+		if serverClient(s, c.ID) == nil {
+			t.Fatal("不应断开")
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.SendBufferSize = 2
+			s.SlowClientPolicy = SlowClientDropOldest
+		})
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		got := stalledDelivery(t, s, c, 5)
+		if len(got) < 2 || len(got) > 3 || got[len(got)-2] != "3" || got[len(got)-1] != "4" {
+			t.Fatalf("收到 %v，应保留最新的消息", got)
+		}
+		if serverClient(s, c.ID) == nil {
+			t.Fatal("不应断开")
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.SendBufferSize = 2
+			s.SlowClientPolicy = SlowClientBlock
+			s.SlowClientTimeout = time.Second
+		})
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		client := serverClient(s, c.ID)
+		client.Pause()
+		// 在超时之内恢复，等待中的广播照常送达，一条都不丢
+		time.AfterFunc(100*time.Millisecond, client.Resume)
+		for i := 0; i < 5; i++ {
+			s.BroadcastToChannelSync("room", i)
+		}
+		for i := 0; i < 5; i++ {
+			if msg := c.Expect("message"); fmt.Sprint(msg.Data) != fmt.Sprint(i) {
+				t.Fatalf("第 %d 条 = %v", i, msg.Data)
+			}
+		}
+	})
+
+	t.Run("block timeout", func(t *testing.T) {
+		s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.SendBufferSize = 2
+			s.SlowClientPolicy = SlowClientBlock
+			s.SlowClientTimeout = 50 * time.Millisecond
+		})
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		serverClient(s, c.ID).Pause()
+		for i := 0; i < 5; i++ {
+			s.BroadcastToChannel("room", i)
+		}
+		c.ExpectClosed()
+	})
+}
+
+func TestMessageTTLDropsStaleMessages(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MessageTTL = 100 * time.Millisecond
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	client := serverClient(s, c.ID)
+
+	// 卡住期间排队的消息超过 TTL，恢复后丢弃；恢复后的新消息照常送达
+	client.Pause()
+	s.BroadcastToChannelSync("room", "stale")
+	time.Sleep(200 * time.Millisecond)
+	client.Resume()
+	s.BroadcastToChannelSync("room", "fresh")
+
+	if msg := c.Expect("message"); msg.Data != "fresh" {
+		t.Fatalf("收到 %v, want fresh", msg.Data)
+	}
+	if n := s.Metrics.ExpiredDrops.Load(); n != 1 {
+		t.Fatalf("ExpiredDrops = %d, want 1", n)
+	}
+}
+
+func TestBufferedBytesInStatsAndMetrics(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxBufferedBytes = 1
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	client := serverClient(s, c.ID)
+
+	// writePump 可能在暂停前已取走第一条，之后的消息积压，超过上限后被丢弃
+	client.Pause()
+	for i := 0; i < 4; i++ {
+		s.BroadcastToChannelSync("room", i)
+	}
+	stats := s.Stats()
+	if stats.BufferedBytes <= 0 || stats.BufferedBytes != s.BufferedBytes() {
+		t.Fatalf("Stats().BufferedBytes = %d, BufferedBytes() = %d", stats.BufferedBytes, s.BufferedBytes())
+	}
+	if stats.Shed < 2 || stats.Shed != s.ShedCount() {
+		t.Fatalf("Stats().Shed = %d, ShedCount() = %d, want 至少 2", stats.Shed, s.ShedCount())
+	}
+
+	var metrics strings.Builder
+	s.WriteMetrics(&metrics)
+	for _, line := range []string{
+		fmt.Sprintf("websocket_buffered_bytes %d\n", s.BufferedBytes()),
+		fmt.Sprintf("websocket_shed_messages_total %d\n", s.ShedCount()),
+	} {
+		if !strings.Contains(metrics.String(), line) {
+			t.Errorf("指标中缺少 %q", line)
+		}
+	}
+
+	// 恢复后队列排空，总量回到 0
+	client.Resume()
+	waitFor(t, "queue drained", func() bool { return s.Stats().BufferedBytes == 0 })
+}
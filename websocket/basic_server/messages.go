@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认的入站消息流缓冲
+const defaultMessageStreamBuffer = 1024
+
+// 交给应用处理的一条入站消息
+type InboundMessage struct {
+	Client  *Client
+	Message Message
+	Time    time.Time // 服务器收到消息的时间
+}
+
+// 入站消息流，第一次调用 Messages 时创建
+type messageStream struct {
+	once sync.Once
+	ch   atomic.Pointer[chan InboundMessage]
+}
+
+// 入站消息流：内置处理不认识的 action 送到这里由应用处理（RouteAllMessages 为 true 时所有消息都送一份），
+// 这些 action 不再返回 CodeUnknownAction。所有调用返回同一个通道，多个读取者会分摊消息。
+// 发送是非阻塞的，缓冲（MessageStreamBuffer，默认 1024）满时消息被丢弃并计入 Metrics.MessageStreamDrops，
+// 不会阻塞 readPump。没有调用过 Messages 时行为不变
+func (s *Server) Messages() <-chan InboundMessage {
+	s.messageStream.once.Do(func() {
+		size := s.MessageStreamBuffer
+		if size <= 0 {
+			size = defaultMessageStreamBuffer
+		}
+		ch := make(chan InboundMessage, size)
+		s.messageStream.ch.Store(&ch)
+	})
+	return *s.messageStream.ch.Load()
+}
+
+// 把消息送入入站消息流，没有应用读取时返回 false（只在 readPump 中调用）
+func (s *Server) routeMessage(client *Client, msg *Message) bool {
+	ch := s.messageStream.ch.Load()
+	if ch == nil {
+		return false
+	}
+	select {
+	case *ch <- InboundMessage{Client: client, Message: *msg, Time: time.Now()}:
+	default:
+		s.Metrics.MessageStreamDrops.Add(1)
+		s.Logger.Debug("入站消息流已满，丢弃消息", "event", "message_stream_drop", "client_id", client.ID, "action", msg.Action)
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 从入站消息流取下一条，超时测试失败
+func nextInbound(t *testing.T, messages <-chan InboundMessage) InboundMessage {
+	t.Helper()
+	select {
+	case in := <-messages:
+		return in
+	case <-time.After(testTimeout):
+		t.Fatal("入站消息流没有收到消息")
+		return InboundMessage{}
+	}
+}
+
+func TestMessagesStream(t *testing.T) {
+	s, ts := NewTestServer(t)
+	messages := s.Messages()
+	c := Dial(t, ts, "")
+
+	// 自定义 action 交给应用，不再返回 CodeUnknownAction
+	c.Send(Message{Action: "order.place", Data: "BTC", RequestID: "r1"})
+	in := nextInbound(t, messages)
+	if in.Client.ID != c.ID || in.Message.Action != "order.place" || in.Message.Data != "BTC" || in.Message.RequestID != "r1" || in.Time.IsZero() {
+		t.Fatalf("收到 %+v", in)
+	}
+	c.ExpectNone(100 * time.Millisecond)
+
+	// 内置处理的 action 默认不进入消息流
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+	select {
+	case in := <-messages:
+		t.Fatalf("内置 action 不应送入消息流: %+v", in.Message)
+	default:
+	}
+}
+
+func TestMessagesStreamRouteAll(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.RouteAllMessages = true
+	})
+	messages := s.Messages()
+	c := Dial(t, ts, "")
+
+	// 内置 action 照常处理，同时送一份到消息流
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+	if in := nextInbound(t, messages); in.Message.Action != "ping" {
+		t.Fatalf("收到 %+v", in.Message)
+	}
+}
+
+func TestMessagesStreamDropsWhenFull(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MessageStreamBuffer = 2
+	})
+	s.Messages() // 没有人读取
+	c := Dial(t, ts, "")
+
+	for i := 0; i < 5; i++ {
+		c.Send(Message{Action: "custom"})
+	}
+	// 消息流满了不阻塞 readPump
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+	if n := s.Metrics.MessageStreamDrops.Load(); n != 3 {
+		t.Fatalf("MessageStreamDrops = %d, want 3", n)
+	}
+}
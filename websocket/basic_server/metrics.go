@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// 消息吞吐计数，均为进程启动以来的累计值
+type Metrics struct {
+	MessagesReceived  atomic.Int64 // 收到的客户端消息
+	MessagesBroadcast atomic.Int64 // 投递的频道广播
+	SlowEvictions     atomic.Int64 // 因发送缓冲区已满被断开的客户端
+	SlowDrops         atomic.Int64 // 按 SlowClientPolicy 丢弃或降级期间跳过的消息
+	SlowWarnings      atomic.Int64 // 发送队列越过高水位的次数
+	EventDrops        atomic.Int64 // 观察者处理太慢被丢弃的服务器事件
+	ExpiredDrops      atomic.Int64 // 排队超过 MessageTTL 被丢弃的频道消息
+	Degraded          atomic.Int64 // 缓冲区满后进入降级宽限期的次数
+	Recovered         atomic.Int64 // 宽限期内追上、没有被断开的降级客户端
+	WriteTimeouts     atomic.Int64 // 写入超过 WriteTimeout 被断开的客户端
+
+	MissedPongEvictions atomic.Int64 // 连续错过 MaxMissedPongs 个 pong 被断开的客户端
+	MessageStreamDrops  atomic.Int64 // 应用处理太慢、入站消息流已满时丢弃的消息
+
+	ChannelRateDrops atomic.Int64 // 频道广播超过 ChannelRate 被丢弃的消息
+
+	TapDrops atomic.Int64 // 监听者处理太慢被丢弃的消息
+
+	OutboundBlocked atomic.Int64 // 被 OutboundInterceptor 拦截、没有发出的响应
+
+	ChannelsSwept atomic.Int64 // 因空闲超过 ChannelTTL 被回收的频道
+
+	CapacityRejections atomic.Int64 // 超过连接数上限、升级前被拒绝的连接
+	ClientPanics       atomic.Int64 // 连接处理中恢复的panic，出错的连接被关闭
+
+	// 按关闭码分类的断开次数
+	DisconnectsNormal    atomic.Int64 // 1000：正常关闭，如主动退出登录
+	DisconnectsGoingAway atomic.Int64 // 1001：页面关闭、服务器重启等
+	DisconnectsError     atomic.Int64 // 协议、策略等错误（1002/1003/1007-1011）
+	DisconnectsAbnormal  atomic.Int64 // 1006：没有关闭帧就断开，通常是网络中断或客户端崩溃
+	DisconnectsOther     atomic.Int64 // 其它关闭码，如应用自定义的 4000-4999
+}
+
+// 按关闭码给断开计数
+func (m *Metrics) countDisconnect(code int) {
+	switch disconnectClass(code) {
+	case "normal":
+		m.DisconnectsNormal.Add(1)
+	case "going_away":
+		m.DisconnectsGoingAway.Add(1)
+	case "error":
+		m.DisconnectsError.Add(1)
+	case "abnormal":
+		m.DisconnectsAbnormal.Add(1)
+	default:
+		m.DisconnectsOther.Add(1)
+	}
+}
+
+// 关闭码所属的类别，用作指标标签
+func disconnectClass(code int) string {
+	switch code {
+	case websocket.CloseNormalClosure:
+		return "normal"
+	case websocket.CloseGoingAway:
+		return "going_away"
+	case websocket.CloseAbnormalClosure:
+		return "abnormal"
+	case websocket.CloseProtocolError, websocket.CloseUnsupportedData, websocket.CloseInvalidFramePayloadData,
+		websocket.ClosePolicyViolation, websocket.CloseMessageTooBig, websocket.CloseMandatoryExtension,
+		websocket.CloseInternalServerErr:
+		return "error"
+	}
+	return "other"
+}
+
+// 默认最多导出的不同标签值个数，超出的归入 "other"
+const defaultMetricsLabelLimit = 20
+
+// 按 MetricsLabelKey 对应的元数据值统计连接数。
+// 标签值一旦被分配就保持不变，达到上限后新出现的值都计入 "other"，防止指标基数爆炸
+func (s *Server) connectionsByLabel() map[string]int {
+	limit := s.MetricsLabelLimit
+	if limit <= 0 {
+		limit = defaultMetricsLabelLimit
+	}
+
+	s.mu.RLock()
+	values := make([]string, 0, len(s.clients))
+	for client := range s.clients {
+		values = append(values, client.Metadata[s.MetricsLabelKey])
+	}
+	s.mu.RUnlock()
+
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+
+	counts := make(map[string]int)
+	for _, value := range values {
+		if value == "" {
+			value = "unknown"
+		}
+		if !s.labelValues[value] {
+			if len(s.labelValues) >= limit {
+				value = "other"
+			} else {
+				s.labelValues[value] = true
+			}
+		}
+		counts[value]++
+	}
+	return counts
+}
+
+// 把元数据键转换为合法的 Prometheus 标签名
+func metricsLabelName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 以 Prometheus 文本格式写出指标
+func (s *Server) WriteMetrics(w io.Writer) {
+	s.writeConnections(w)
+
+	channels := len(s.subscriptions.counts())
+	fmt.Fprintln(w, "# HELP websocket_channels Channels with at least one subscriber.")
+	fmt.Fprintln(w, "# TYPE websocket_channels gauge")
+	fmt.Fprintf(w, "websocket_channels %d\n", channels)
+	fmt.Fprintln(w, "# HELP websocket_tracked_channels Channels with server-side state (sequence, history or counters), including ones without subscribers.")
+	fmt.Fprintln(w, "# TYPE websocket_tracked_channels gauge")
+	fmt.Fprintf(w, "websocket_tracked_channels %d\n", len(s.trackedChannels()))
+	fmt.Fprintln(w, "# HELP websocket_buffered_bytes Bytes queued in all client send buffers.")
+	fmt.Fprintln(w, "# TYPE websocket_buffered_bytes gauge")
+	fmt.Fprintf(w, "websocket_buffered_bytes %d\n", s.BufferedBytes())
+
+	writeCounter(w, "websocket_shed_messages_total", "Messages dropped because buffered bytes exceeded MaxBufferedBytes.", s.ShedCount())
+	writeCounter(w, "websocket_messages_received_total", "Messages received from clients.", s.Metrics.MessagesReceived.Load())
+	writeCounter(w, "websocket_messages_broadcast_total", "Channel broadcasts fanned out.", s.Metrics.MessagesBroadcast.Load())
+	writeCounter(w, "websocket_slow_client_evictions_total", "Clients disconnected because their send buffer was full.", s.Metrics.SlowEvictions.Load())
+	writeCounter(w, "websocket_slow_client_drops_total", "Messages dropped for slow clients by SlowClientPolicy or while degraded.", s.Metrics.SlowDrops.Load())
+	writeCounter(w, "websocket_slow_client_warnings_total", "Times a client send queue crossed the high-water mark.", s.Metrics.SlowWarnings.Load())
+	writeCounter(w, "websocket_event_drops_total", "Server events dropped for slow observers.", s.Metrics.EventDrops.Load())
+	writeCounter(w, "websocket_message_stream_drops_total", "Inbound messages dropped because the Messages stream was full.", s.Metrics.MessageStreamDrops.Load())
+	writeCounter(w, "websocket_channel_rate_drops_total", "Channel broadcasts dropped because the channel exceeded ChannelRate.", s.Metrics.ChannelRateDrops.Load())
+	writeCounter(w, "websocket_tap_drops_total", "Channel messages dropped for slow taps.", s.Metrics.TapDrops.Load())
+	writeCounter(w, "websocket_outbound_blocked_total", "Outbound responses blocked by OutboundInterceptor.", s.Metrics.OutboundBlocked.Load())
+	writeCounter(w, "websocket_channels_swept_total", "Idle channels reclaimed after ChannelTTL.", s.Metrics.ChannelsSwept.Load())
+	writeCounter(w, "websocket_expired_messages_total", "Channel messages dropped after waiting longer than MessageTTL.", s.Metrics.ExpiredDrops.Load())
+	writeCounter(w, "websocket_slow_client_degraded_total", "Times a slow client entered the SlowClientGrace window.", s.Metrics.Degraded.Load())
+	writeCounter(w, "websocket_slow_client_recovered_total", "Degraded clients that caught up within the grace window.", s.Metrics.Recovered.Load())
+	writeCounter(w, "websocket_write_timeouts_total", "Clients disconnected because a write exceeded WriteTimeout.", s.Metrics.WriteTimeouts.Load())
+	writeCounter(w, "websocket_missed_pong_evictions_total", "Clients disconnected after missing MaxMissedPongs consecutive pongs.", s.Metrics.MissedPongEvictions.Load())
+	writeCounter(w, "websocket_capacity_rejections_total", "Connections rejected before upgrade because a connection limit was reached.", s.Metrics.CapacityRejections.Load())
+	writeCounter(w, "websocket_client_panics_total", "Panics recovered in a connection's pumps or hooks; the connection was closed.", s.Metrics.ClientPanics.Load())
+
+	fmt.Fprintln(w, "# HELP websocket_disconnects_total Disconnects by close code class.")
+	fmt.Fprintln(w, "# TYPE websocket_disconnects_total counter")
+	counts := s.Metrics.disconnects()
+	for _, class := range disconnectClasses {
+		fmt.Fprintf(w, "websocket_disconnects_total{reason=\"%s\"} %d\n", class, counts[class])
+	}
+}
+
+// 断开类别的导出顺序
+var disconnectClasses = []string{"normal", "going_away", "error", "abnormal", "other"}
+
+// 断开次数，按类别
+func (m *Metrics) disconnects() map[string]int64 {
+	return map[string]int64{
+		"normal":     m.DisconnectsNormal.Load(),
+		"going_away": m.DisconnectsGoingAway.Load(),
+		"error":      m.DisconnectsError.Load(),
+		"abnormal":   m.DisconnectsAbnormal.Load(),
+		"other":      m.DisconnectsOther.Load(),
+	}
+}
+
+func writeCounter(w io.Writer, name, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// 当前连接数，设置了 MetricsLabelKey 时按标签分组
+func (s *Server) writeConnections(w io.Writer) {
+	fmt.Fprintln(w, "# HELP websocket_connections Current WebSocket connections.")
+	fmt.Fprintln(w, "# TYPE websocket_connections gauge")
+
+	if s.MetricsLabelKey == "" {
+		s.mu.RLock()
+		count := len(s.clients)
+		s.mu.RUnlock()
+		fmt.Fprintf(w, "websocket_connections %d\n", count)
+		return
+	}
+
+	counts := s.connectionsByLabel()
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	label := metricsLabelName(s.MetricsLabelKey)
+	for _, value := range values {
+		fmt.Fprintf(w, "websocket_connections{%s=\"%s\"} %d\n", label, labelValueEscaper.Replace(value), counts[value])
+	}
+}
+
+// Prometheus 指标接口
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.WriteMetrics(w)
+}
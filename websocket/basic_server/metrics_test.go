@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDisconnectCloseCodeSurfaced(t *testing.T) {
+	type closed struct {
+		code   int
+		reason string
+	}
+	disconnects := make(chan closed, 3)
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.OnDisconnect = func(client *Client, code int, reason string) {
+			disconnects <- closed{code, reason}
+		}
+	})
+
+	tests := []struct {
+		name  string
+		close func(c *TestClient)
+		want  closed
+	}{
+		{"custom code", func(c *TestClient) {
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4100, "logout"))
+		}, closed{4100, "logout"}},
+		{"going away", func(c *TestClient) {
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		}, closed{websocket.CloseGoingAway, ""}},
+		{"abrupt", func(c *TestClient) {
+			c.Conn.Close()
+		}, closed{websocket.CloseAbnormalClosure, ""}},
+	}
+	for _, tt := range tests {
+		c := Dial(t, ts, "")
+		tt.close(c)
+		got := <-disconnects
+		if tt.want.code == websocket.CloseAbnormalClosure {
+			// 没有关闭帧时原因是读取错误的文本，只比较关闭码
+			got.reason = ""
+		}
+		if got != tt.want {
+			t.Errorf("%s: OnDisconnect(%d, %q), want (%d, %q)", tt.name, got.code, got.reason, tt.want.code, tt.want.reason)
+		}
+	}
+
+	m := &s.Metrics
+	if m.DisconnectsOther.Load() != 1 || m.DisconnectsGoingAway.Load() != 1 || m.DisconnectsAbnormal.Load() != 1 || m.DisconnectsNormal.Load() != 0 {
+		t.Fatalf("断开统计 normal=%d going_away=%d abnormal=%d other=%d",
+			m.DisconnectsNormal.Load(), m.DisconnectsGoingAway.Load(), m.DisconnectsAbnormal.Load(), m.DisconnectsOther.Load())
+	}
+}
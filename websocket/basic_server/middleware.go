@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTP 中间件，包在 WebSocket 端点外层，在升级之前运行。
+// 中间件可以直接写出响应（如 401、429）而不调用 next，此时连接不会被升级
+type Middleware func(next http.Handler) http.Handler
+
+// 添加中间件，先添加的在最外层。应在 Handler 之前调用
+func (s *Server) Use(m Middleware) {
+	s.middleware = append(s.middleware, m)
+}
+
+// WebSocket 端点：按添加顺序包上中间件，最内层由 HandleWebSocket 完成升级
+func (s *Server) Handler() http.Handler {
+	var h http.Handler = http.HandlerFunc(s.HandleWebSocket)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
+
+// 记录每个请求的方法、路径、来源地址、状态码和耗时。升级成功的请求状态码为 101，
+// 耗时为握手耗时，不包括连接的存活时间
+func RequestLogger(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			logger.Info("HTTP 请求", "event", "http_request", "method", r.Method, "path", r.URL.Path,
+				"remote_addr", r.RemoteAddr, "status", rec.statusCode(), "duration", time.Since(start))
+		})
+	}
+}
+
+// 记录响应状态码的 ResponseWriter，保留劫持能力以便升级
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func (w *statusRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMigrateChannel(t *testing.T) {
+	s, ts := NewTestServer(t)
+	a := Dial(t, ts, "channels=room.old")
+	b := Dial(t, ts, "channels=room.old")
+	both := Dial(t, ts, "channels=room.old,room.new")
+	other := Dial(t, ts, "channels=lobby")
+	for _, c := range []*TestClient{a, b, both, both, other} {
+		c.Expect("subscribe")
+	}
+
+	if n := s.MigrateChannel("room.old", "room.new"); n != 3 {
+		t.Fatalf("MigrateChannel = %d, want 3", n)
+	}
+	for _, c := range []*TestClient{a, b, both} {
+		resp := c.Expect("channel_migrated")
+		if resp.Channel != "room.new" || !reflect.DeepEqual(resp.Data, map[string]interface{}{"from": "room.old", "to": "room.new"}) {
+			t.Fatalf("迁移通知 %+v", resp)
+		}
+		if got := serverClient(s, c.ID).channelList(); !reflect.DeepEqual(got, []string{"room.new"}) {
+			t.Fatalf("客户端记录的订阅 %v, want [room.new]", got)
+		}
+	}
+	if got := s.ChannelSubscribers("room.old"); len(got) != 0 {
+		t.Fatalf("旧频道仍有订阅者 %v", got)
+	}
+	got := s.ChannelSubscribers("room.new")
+	want := []string{a.ID, b.ID, both.ID}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("新频道的订阅者 %v, want %v", got, want)
+	}
+
+	// 旧频道的广播没有人收到，新频道的广播每个客户端收到一次
+	s.BroadcastToChannel("room.old", "old")
+	s.BroadcastToChannel("room.new", "new")
+	for _, c := range []*TestClient{a, b, both} {
+		if msg := c.Expect("message"); msg.Channel != "room.new" || msg.Data != "new" {
+			t.Fatalf("收到 %+v", msg)
+		}
+		c.ExpectNone(50 * time.Millisecond)
+	}
+	other.ExpectNone(50 * time.Millisecond)
+
+	if n := s.MigrateChannel("room.old", "room.new"); n != 0 {
+		t.Fatalf("没有订阅者的频道迁移了 %d 个客户端", n)
+	}
+}
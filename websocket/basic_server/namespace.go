@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// 命名空间的限制和授权。频道名中第一个 NamespaceSeparator 之前的部分是它的命名空间，
+// 没有分隔符的频道属于默认命名空间 ""
+type Namespace struct {
+	MaxChannels    int // 命名空间内有订阅者的频道数上限（0 表示不限制）
+	MaxSubscribers int // 命名空间内的订阅总数上限，一个客户端订阅两个频道计两次（0 表示不限制）
+
+	// 在 Server.CanSubscribe / Server.CanPublish 通过之后检查（可选），返回 false 时拒绝（403）
+	CanSubscribe func(client *Client, channel string) bool
+	CanPublish   func(client *Client, channel string) bool
+}
+
+// 订阅被命名空间上限拒绝的原因
+var (
+	errNamespaceChannelLimit    = errors.New("namespace channel limit reached")
+	errNamespaceSubscriberLimit = errors.New("namespace subscriber limit reached")
+)
+
+// 已配置的命名空间及其当前计数
+type namespaceState struct {
+	Namespace
+	channels    int
+	subscribers int
+}
+
+type namespaceRegistry struct {
+	mu         sync.Mutex
+	namespaces map[string]*namespaceState
+}
+
+// 频道所属的命名空间
+func namespaceOf(channel, separator string) string {
+	if i := strings.Index(channel, separator); i >= 0 {
+		return channel[:i]
+	}
+	return ""
+}
+
+// 配置命名空间的上限和授权，需要同时设置 NamespaceSeparator。
+// 计数从调用时开始，应在启动前调用；重复调用替换配置并保留计数
+func (s *Server) SetNamespace(name string, ns Namespace) {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+	if s.namespaces.namespaces == nil {
+		s.namespaces.namespaces = make(map[string]*namespaceState)
+	}
+	if state := s.namespaces.namespaces[name]; state != nil {
+		state.Namespace = ns
+		return
+	}
+	s.namespaces.namespaces[name] = &namespaceState{Namespace: ns}
+}
+
+// 命名空间当前有订阅者的频道数和订阅总数，只统计用 SetNamespace 配置过的命名空间
+func (s *Server) NamespaceCounts(name string) (channels, subscribers int) {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+	if state := s.namespaces.namespaces[name]; state != nil {
+		return state.channels, state.subscribers
+	}
+	return 0, 0
+}
+
+// 频道所属命名空间的配置（副本），没有开启命名空间或该命名空间没有配置时 ok 为 false
+func (s *Server) namespaceConfig(channel string) (ns Namespace, ok bool) {
+	if s.NamespaceSeparator == "" {
+		return Namespace{}, false
+	}
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+	state := s.namespaces.namespaces[namespaceOf(channel, s.NamespaceSeparator)]
+	if state == nil {
+		return Namespace{}, false
+	}
+	return state.Namespace, true
+}
+
+// 命名空间的订阅授权。通配订阅只能在一个命名空间内匹配，命名空间部分含 "*" 时拒绝
+func (s *Server) namespaceAllowsSubscribe(client *Client, channel string) bool {
+	if s.NamespaceSeparator == "" {
+		return true
+	}
+	if isPattern(namespaceOf(channel, s.NamespaceSeparator)) {
+		return false
+	}
+	ns, ok := s.namespaceConfig(channel)
+	return !ok || ns.CanSubscribe == nil || ns.CanSubscribe(client, channel)
+}
+
+func (s *Server) namespaceAllowsPublish(client *Client, channel string) bool {
+	ns, ok := s.namespaceConfig(channel)
+	return !ok || ns.CanPublish == nil || ns.CanPublish(client, channel)
+}
+
+// 为一个新的订阅占用命名空间的名额，newChannel 表示该频道此前没有订阅者。
+// enforce 为 true 时超过上限返回对应的错误，不占用名额；MigrateChannel 等服务端操作不受上限限制。
+// 调用方需持有该频道分片的写锁
+func (s *Server) reserveNamespace(channel string, newChannel, enforce bool) error {
+	if s.NamespaceSeparator == "" {
+		return nil
+	}
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+	state := s.namespaces.namespaces[namespaceOf(channel, s.NamespaceSeparator)]
+	if state == nil {
+		return nil
+	}
+	if enforce && newChannel && state.MaxChannels > 0 && state.channels >= state.MaxChannels {
+		return errNamespaceChannelLimit
+	}
+	if enforce && state.MaxSubscribers > 0 && state.subscribers >= state.MaxSubscribers {
+		return errNamespaceSubscriberLimit
+	}
+	if newChannel {
+		state.channels++
+	}
+	state.subscribers++
+	return nil
+}
+
+// 归还一个订阅占用的名额，emptied 表示该频道已没有订阅者（调用方需持有该频道分片的写锁）
+func (s *Server) releaseNamespace(channel string, emptied bool) {
+	if s.NamespaceSeparator == "" {
+		return
+	}
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+	state := s.namespaces.namespaces[namespaceOf(channel, s.NamespaceSeparator)]
+	if state == nil {
+		return
+	}
+	// SetNamespace 之前就存在的订阅没有计数，不会减成负数
+	if emptied && state.channels > 0 {
+		state.channels--
+	}
+	if state.subscribers > 0 {
+		state.subscribers--
+	}
+}
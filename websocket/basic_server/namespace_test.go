@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func namespaceServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	return newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+		s.NamespaceSeparator = ":"
+		s.SetNamespace("t1", Namespace{MaxChannels: 2, MaxSubscribers: 3})
+		s.SetNamespace("t2", Namespace{
+			CanSubscribe: func(client *Client, channel string) bool { return client.UserID == "t2-user" },
+		})
+	})
+}
+
+func TestNamespaceDeliveryIsolation(t *testing.T) {
+	s, ts := namespaceServer(t)
+	t1 := Dial(t, ts, "user=t1-user")
+	t1.Subscribe("t1:room.a")
+	t1Pattern := Dial(t, ts, "user=t1-user")
+	t1Pattern.Subscribe("t1:room.*")
+	t2 := Dial(t, ts, "user=t2-user")
+	t2.Subscribe("t2:room.a")
+	// 没有命名空间的通配订阅只匹配默认命名空间的频道
+	global := Dial(t, ts, "")
+	global.Subscribe("**.a")
+
+	s.BroadcastToChannel("t1:room.a", "for t1")
+	for _, c := range []*TestClient{t1, t1Pattern} {
+		if msg := c.Expect("message"); msg.Data != "for t1" {
+			t.Fatalf("收到 %v", msg.Data)
+		}
+	}
+	s.BroadcastToChannel("t2:room.a", "for t2")
+	if msg := t2.Expect("message"); msg.Data != "for t2" {
+		t.Fatalf("收到 %v", msg.Data)
+	}
+	t1.ExpectNone(50 * time.Millisecond)
+	t1Pattern.ExpectNone(50 * time.Millisecond)
+	global.ExpectNone(50 * time.Millisecond)
+
+	// 命名空间部分带通配符的模式会跨租户匹配，拒绝
+	global.Send(Message{Action: "subscribe", Channel: "*:room.a"})
+	if resp := global.Expect("subscribe"); resp.Code != CodeForbidden {
+		t.Fatalf("跨命名空间的模式订阅 %+v", resp)
+	}
+}
+
+func TestNamespaceLimitsAndAuth(t *testing.T) {
+	s, ts := namespaceServer(t)
+	c := Dial(t, ts, "user=t1-user")
+	c.Subscribe("t1:a")
+	c.Subscribe("t1:b")
+
+	// t1 最多两个频道
+	c.Send(Message{Action: "subscribe", Channel: "t1:c"})
+	if resp := c.Expect("subscribe"); resp.Code != CodeForbidden || resp.Msg != errNamespaceChannelLimit.Error() {
+		t.Fatalf("超过频道数上限 %+v", resp)
+	}
+	// t1 最多三个订阅
+	other := Dial(t, ts, "user=t1-user")
+	other.Subscribe("t1:a")
+	other.Send(Message{Action: "subscribe", Channel: "t1:b"})
+	if resp := other.Expect("subscribe"); resp.Code != CodeForbidden || resp.Msg != errNamespaceSubscriberLimit.Error() {
+		t.Fatalf("超过订阅数上限 %+v", resp)
+	}
+	if channels, subscribers := s.NamespaceCounts("t1"); channels != 2 || subscribers != 3 {
+		t.Fatalf("t1 计数 (%d, %d), want (2, 3)", channels, subscribers)
+	}
+
+	// 其它命名空间不受 t1 的上限影响，但有自己的授权
+	c.Send(Message{Action: "subscribe", Channel: "t2:a"})
+	if resp := c.Expect("subscribe"); resp.Code != CodeForbidden {
+		t.Fatalf("t2 的授权没有生效 %+v", resp)
+	}
+	t2 := Dial(t, ts, "user=t2-user")
+	for _, channel := range []string{"t2:a", "t2:b", "t2:c"} {
+		t2.Subscribe(channel)
+	}
+
+	// 取消订阅归还名额
+	c.Send(Message{Action: "unsubscribe", Channel: "t1:b"})
+	c.Expect("unsubscribe")
+	other.Subscribe("t1:b")
+	if channels, subscribers := s.NamespaceCounts("t1"); channels != 2 || subscribers != 3 {
+		t.Fatalf("t1 计数 (%d, %d), want (2, 3)", channels, subscribers)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 溢出队列已满
+var ErrOverflowFull = errors.New("overflow store is full")
+
+// 溢出存储：客户端发送缓冲区满时，多余消息暂存于此，客户端追上后再取回
+type OverflowStore interface {
+	Push(clientID string, message SpilledMessage) error // 追加一条消息
+	Pop(clientID string) (SpilledMessage, bool, error)  // 取出最早的一条消息
+	Len(clientID string) int                            // 当前暂存的消息数
+	Remove(clientID string) error                       // 客户端断开时清理
+}
+
+// 溢出存储中的一条消息。取回时按它还原发送队列中的一帧，频道的压缩设置和 MessageTTL 照常生效
+type SpilledMessage struct {
+	Type     int       // 帧类型
+	Payload  []byte    // 帧内容
+	Channel  string    // 所属频道
+	QueuedAt time.Time // 最初入队的时间，未设置 MessageTTL 时为零值
+}
+
+// 还原为发送队列中的一帧
+func (m SpilledMessage) outbound() OutboundMessage {
+	return OutboundMessage{Type: m.Type, Payload: m.Payload, Channel: m.Channel, queuedAt: m.QueuedAt}
+}
+
+// 基于磁盘的有界溢出队列，每个客户端一个文件
+type FileOverflowStore struct {
+	Dir               string // 队列文件目录
+	MaxBytesPerClient int64  // 单个客户端最多暂存的字节数
+
+	mu     sync.Mutex
+	queues map[string]*fileQueue
+}
+
+type fileQueue struct {
+	file     *os.File
+	readOff  int64
+	writeOff int64
+	count    int
+}
+
+// 创建磁盘溢出存储
+func NewFileOverflowStore(dir string, maxBytesPerClient int64) (*FileOverflowStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileOverflowStore{
+		Dir:               dir,
+		MaxBytesPerClient: maxBytesPerClient,
+		queues:            make(map[string]*fileQueue),
+	}, nil
+}
+
+// 文件开头已读部分超过该大小、且不少于未读部分时，把未读部分移到文件开头
+const spillCompactThreshold = 64 << 10
+
+// 每条记录的头部：4字节负载长度 + 1字节帧类型 + 8字节入队时间（UnixNano，0 表示未记录）+ 2字节频道名长度
+const spillHeaderSize = 4 + 1 + 8 + 2
+
+func (f *FileOverflowStore) Push(clientID string, message SpilledMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, ok := f.queues[clientID]
+	if !ok {
+		file, err := os.OpenFile(filepath.Join(f.Dir, clientID+".queue"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		q = &fileQueue{file: file}
+		f.queues[clientID] = q
+	}
+
+	// 每条记录：头部 + 频道名 + 负载
+	if len(message.Channel) > 0xFFFF {
+		return fmt.Errorf("频道名过长: %d 字节", len(message.Channel))
+	}
+	size := int64(spillHeaderSize + len(message.Channel) + len(message.Payload))
+	if f.MaxBytesPerClient > 0 && q.writeOff-q.readOff+size > f.MaxBytesPerClient {
+		return ErrOverflowFull
+	}
+
+	var queuedAt int64
+	if !message.QueuedAt.IsZero() {
+		queuedAt = message.QueuedAt.UnixNano()
+	}
+	record := make([]byte, size)
+	binary.BigEndian.PutUint32(record, uint32(len(message.Payload)))
+	record[4] = byte(message.Type)
+	binary.BigEndian.PutUint64(record[5:], uint64(queuedAt))
+	binary.BigEndian.PutUint16(record[13:], uint16(len(message.Channel)))
+	n := copy(record[spillHeaderSize:], message.Channel)
+	copy(record[spillHeaderSize+n:], message.Payload)
+	if _, err := q.file.WriteAt(record, q.writeOff); err != nil {
+		return err
+	}
+	q.writeOff += size
+	q.count++
+	return nil
+}
+
+func (f *FileOverflowStore) Pop(clientID string) (SpilledMessage, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, ok := f.queues[clientID]
+	if !ok || q.count == 0 {
+		return SpilledMessage{}, false, nil
+	}
+
+	var header [spillHeaderSize]byte
+	if _, err := q.file.ReadAt(header[:], q.readOff); err != nil {
+		return SpilledMessage{}, false, err
+	}
+	payloadLen := int(binary.BigEndian.Uint32(header[:]))
+	channelLen := int(binary.BigEndian.Uint16(header[13:]))
+	body := make([]byte, channelLen+payloadLen)
+	if _, err := q.file.ReadAt(body, q.readOff+spillHeaderSize); err != nil {
+		return SpilledMessage{}, false, err
+	}
+	message := SpilledMessage{
+		Type:    int(header[4]),
+		Channel: string(body[:channelLen]),
+		Payload: body[channelLen:],
+	}
+	if queuedAt := int64(binary.BigEndian.Uint64(header[5:])); queuedAt != 0 {
+		message.QueuedAt = time.Unix(0, queuedAt)
+	}
+	q.readOff += int64(spillHeaderSize + len(body))
+	q.count--
+
+	// 队列清空后截断文件，回收磁盘空间；一直没有清空的客户端定期压缩，文件不会无限增长
+	if q.count == 0 {
+		q.readOff, q.writeOff = 0, 0
+		if err := q.file.Truncate(0); err != nil {
+			return message, true, err
+		}
+	} else if q.readOff >= spillCompactThreshold && q.readOff >= q.writeOff-q.readOff {
+		if err := q.compact(); err != nil {
+			return message, true, err
+		}
+	}
+	return message, true, nil
+}
+
+// 把未读部分复制到文件开头并截断。调用方保证已读部分不少于未读部分，源和目标区域不重叠
+func (q *fileQueue) compact() error {
+	live := q.writeOff - q.readOff
+	src := io.NewSectionReader(q.file, q.readOff, live)
+	if _, err := io.Copy(io.NewOffsetWriter(q.file, 0), src); err != nil {
+		return err
+	}
+	if err := q.file.Truncate(live); err != nil {
+		return err
+	}
+	q.readOff, q.writeOff = 0, live
+	return nil
+}
+
+func (f *FileOverflowStore) Len(clientID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if q, ok := f.queues[clientID]; ok {
+		return q.count
+	}
+	return 0
+}
+
+func (f *FileOverflowStore) Remove(clientID string) error {
+	f.mu.Lock()
+	q, ok := f.queues[clientID]
+	delete(f.queues, clientID)
+	f.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	q.file.Close()
+	if err := os.Remove(q.file.Name()); err != nil {
+		return fmt.Errorf("删除溢出文件失败: %w", err)
+	}
+	return nil
+}
+
+// 为频道开启溢出缓冲（需先设置 Server.Overflow）
+func (s *Server) EnableOverflow(channel string) {
+	s.mu.Lock()
+	s.overflowChannels[channel] = true
+	s.mu.Unlock()
+}
+
+// 关闭频道的溢出缓冲
+func (s *Server) DisableOverflow(channel string) {
+	s.mu.Lock()
+	delete(s.overflowChannels, channel)
+	s.mu.Unlock()
+}
+
+// 频道是否开启了溢出缓冲（调用方需持有读锁）
+func (s *Server) overflowEnabled(channel string) bool {
+	return s.Overflow != nil && s.overflowChannels[channel]
+}
+
+// 发送消息，缓冲区满时写入溢出存储；返回 false 表示溢出存储也已满
+func (s *Server) sendOrSpill(client *Client, message OutboundMessage) bool {
+	client.overflowMu.Lock()
+	defer client.overflowMu.Unlock()
+
+	// 已有溢出消息时必须继续写入溢出存储，保证顺序
+	if s.Overflow.Len(client.ID) == 0 && s.trySendFrame(client, message) {
+		return true
+	}
+
+	// 连同频道和入队时间一起保存，取回后仍按频道压缩、按 MessageTTL 过期
+	s.stamp(&message)
+	spilled := SpilledMessage{Type: message.Type, Payload: message.Payload, Channel: message.Channel, QueuedAt: message.queuedAt}
+	if err := s.Overflow.Push(client.ID, spilled); err != nil {
+		s.Logger.Warn("溢出存储写入失败", "event", "overflow_error", "client_id", client.ID, "error", err)
+		return false
+	}
+	return true
+}
+
+// 发送缓冲区空闲时，把溢出存储中的消息取回并写出，在磁盘上停留超过 MessageTTL 的频道消息直接丢弃
+func (s *Server) drainOverflow(client *Client) error {
+	return s.drainOverflowTo(client, func(message OutboundMessage) error {
+		if s.expired(message) {
+			return nil
+		}
+		return s.writeFrame(client, message)
+	})
+}
+
+// 发送缓冲区空闲时按顺序取回溢出消息交给 write，发送队列中又有消息时停止
+func (s *Server) drainOverflowTo(client *Client, write func(OutboundMessage) error) error {
+	if s.Overflow == nil {
+		return nil
+	}
+
+	for {
+		client.overflowMu.Lock()
+		if len(client.Send) > 0 {
+			client.overflowMu.Unlock()
+			return nil
+		}
+		spilled, ok, err := s.Overflow.Pop(client.ID)
+		client.overflowMu.Unlock()
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := write(spilled.outbound()); err != nil {
+			return err
+		}
+	}
+}
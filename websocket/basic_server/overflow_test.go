@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFileOverflowStoreKeepsMessageFields(t *testing.T) {
+	store, err := NewFileOverflowStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queuedAt := time.Unix(0, time.Now().UnixNano())
+	pushed := []SpilledMessage{
+		{Type: websocket.TextMessage, Payload: []byte(`{"n":1}`), Channel: "ticks", QueuedAt: queuedAt},
+		{Type: websocket.BinaryMessage, Payload: []byte{0, 1, 2}},
+	}
+	for _, message := range pushed {
+		if err := store.Push("c1", message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := store.Len("c1"); n != 2 {
+		t.Fatalf("Len = %d, want 2", n)
+	}
+
+	for i, want := range pushed {
+		got, ok, err := store.Pop("c1")
+		if err != nil || !ok {
+			t.Fatalf("Pop %d: ok=%v err=%v", i, ok, err)
+		}
+		if got.Type != want.Type || got.Channel != want.Channel || !bytes.Equal(got.Payload, want.Payload) || !got.QueuedAt.Equal(want.QueuedAt) {
+			t.Fatalf("Pop %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if _, ok, _ := store.Pop("c1"); ok {
+		t.Fatal("队列应已清空")
+	}
+}
+
+func TestFileOverflowStoreLimit(t *testing.T) {
+	store, err := NewFileOverflowStore(t.TempDir(), spillHeaderSize+8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Push("c1", SpilledMessage{Payload: []byte("12345678")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Push("c1", SpilledMessage{Payload: []byte("x")}); err != ErrOverflowFull {
+		t.Fatalf("err = %v, want ErrOverflowFull", err)
+	}
+	if err := store.Remove("c1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileOverflowStoreCompacts(t *testing.T) {
+	store, err := NewFileOverflowStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("x"), 1000)
+	for i := 0; i < 3; i++ {
+		if err := store.Push("c1", SpilledMessage{Payload: append([]byte{byte(i)}, payload...)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 客户端一直落后几条：队列从不清空，文件仍应保持在压缩阈值附近
+	for i := 3; i < 1000; i++ {
+		if err := store.Push("c1", SpilledMessage{Payload: append([]byte{byte(i)}, payload...)}); err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := store.Pop("c1")
+		if err != nil || !ok {
+			t.Fatalf("Pop: ok=%v err=%v", ok, err)
+		}
+		if got.Payload[0] != byte(i-3) {
+			t.Fatalf("第 %d 次取出 %d, want %d", i, got.Payload[0], byte(i-3))
+		}
+	}
+	info, err := store.queues["c1"].file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*spillCompactThreshold {
+		t.Fatalf("溢出文件 %d 字节, 没有压缩", info.Size())
+	}
+	if n := store.Len("c1"); n != 3 {
+		t.Fatalf("Len = %d, want 3", n)
+	}
+}
+
+func TestDrainOverflowRestoresChannelAndTTL(t *testing.T) {
+	store, err := NewFileOverflowStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(DefaultServerConfig())
+	s.Overflow = store
+	s.MessageTTL = time.Minute
+	client := &Client{ID: "c1", Send: make(chan OutboundMessage, 1)}
+
+	// 第一条已在磁盘上排队超过 MessageTTL，取回时丢弃；第二条按原频道写出
+	store.Push("c1", SpilledMessage{Type: websocket.TextMessage, Payload: []byte(`"stale"`), Channel: "ticks", QueuedAt: time.Now().Add(-time.Hour)})
+	if !s.sendOrSpill(client, OutboundMessage{Type: websocket.TextMessage, Payload: []byte(`"fresh"`), Channel: "ticks"}) {
+		t.Fatal("sendOrSpill 失败")
+	}
+
+	var written []OutboundMessage
+	err = s.drainOverflowTo(client, func(message OutboundMessage) error {
+		if !s.expired(message) {
+			written = append(written, message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || string(written[0].Payload) != `"fresh"` || written[0].Channel != "ticks" || written[0].queuedAt.IsZero() {
+		t.Fatalf("取回 %+v", written)
+	}
+	if n := s.Metrics.ExpiredDrops.Load(); n != 1 {
+		t.Fatalf("ExpiredDrops = %d, want 1", n)
+	}
+}
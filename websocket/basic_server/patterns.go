@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 通配订阅：频道名按 "." 分段，"*" 匹配恰好一段，"**" 匹配一段或多段。
+// 例如 "sensors.*" 匹配 "sensors.temp"，不匹配 "sensors.temp.max"；"sensors.**" 两者都匹配，
+// 但都不匹配 "sensors" 本身。其它段（包括 "a*b" 这样含 "*" 的段）按字面比较。
+// 连续的 "**" 等同于一个 "**"
+type patternRegistry struct {
+	mu   sync.RWMutex
+	subs map[string]map[*Client]bool // 模式 -> 订阅者
+	// 按第一段索引模式：第一段是字面量的模式只需和第一段相同的频道比较，
+	// 第一段含 "*" 的模式放在 "" 下，与所有频道比较
+	byHead map[string]map[string]bool
+}
+
+func newPatternRegistry() *patternRegistry {
+	return &patternRegistry{
+		subs:   make(map[string]map[*Client]bool),
+		byHead: make(map[string]map[string]bool),
+	}
+}
+
+// 模式在 byHead 中的索引键
+func patternHead(pattern string) string {
+	head, _, _ := strings.Cut(pattern, ".")
+	if strings.Contains(head, "*") {
+		return ""
+	}
+	return head
+}
+
+// 频道名中含 "*" 的订阅按模式处理
+func isPattern(channel string) bool {
+	return strings.Contains(channel, "*")
+}
+
+// 一个模式中最多允许的 "**" 段数（连续的 "**" 合并后计数），超过时订阅被拒绝
+const maxPatternGlobstars = 4
+
+// 模式是否匹配具体频道
+func matchPattern(pattern, channel string) bool {
+	return matchSegments(patternSegments(pattern), strings.Split(channel, "."))
+}
+
+// 把模式按 "." 分段，连续的 "**" 合并为一个
+func patternSegments(pattern string) []string {
+	segments := strings.Split(pattern, ".")
+	collapsed := segments[:0]
+	for _, segment := range segments {
+		if segment == "**" && len(collapsed) > 0 && collapsed[len(collapsed)-1] == "**" {
+			continue
+		}
+		collapsed = append(collapsed, segment)
+	}
+	return collapsed
+}
+
+// 校验模式：合并后的 "**" 段数不超过 maxPatternGlobstars
+func validatePattern(pattern string) error {
+	n := 0
+	for _, segment := range patternSegments(pattern) {
+		if segment == "**" {
+			n++
+		}
+	}
+	if n > maxPatternGlobstars {
+		return fmt.Errorf("pattern has more than %d \"**\" segments", maxPatternGlobstars)
+	}
+	return nil
+}
+
+// 按 (模式段, 频道段) 做动态规划，耗时与两者段数之积成正比，不会因多个 "**" 指数回溯。
+// next[j] 表示 pattern[i+1:] 是否匹配 channel[j:]，从模式末尾向前逐段计算
+func matchSegments(pattern, channel []string) bool {
+	next := make([]bool, len(channel)+1)
+	cur := make([]bool, len(channel)+1)
+	next[len(channel)] = true
+	for i := len(pattern) - 1; i >= 0; i-- {
+		cur[len(channel)] = false
+		for j := len(channel) - 1; j >= 0; j-- {
+			switch pattern[i] {
+			case "**":
+				// 吃掉一段后结束，或继续吃掉更多段
+				cur[j] = next[j+1] || cur[j+1]
+			case "*":
+				cur[j] = next[j+1]
+			default:
+				cur[j] = pattern[i] == channel[j] && next[j+1]
+			}
+		}
+		next, cur = cur, next
+	}
+	return next[0]
+}
+
+// 加入模式订阅，返回是否是新的订阅（调用方需通过 lockSubscriptions 持有 r.mu 写锁）
+func (r *patternRegistry) add(client *Client, pattern string) bool {
+	if r.subs[pattern] == nil {
+		r.subs[pattern] = make(map[*Client]bool)
+		head := patternHead(pattern)
+		if r.byHead[head] == nil {
+			r.byHead[head] = make(map[string]bool)
+		}
+		r.byHead[head][pattern] = true
+	}
+	if r.subs[pattern][client] {
+		return false
+	}
+	r.subs[pattern][client] = true
+	return true
+}
+
+// 锁住订阅这些频道需要的分片，其中有通配订阅时再持有模式表的写锁（在分片锁之后获取）。
+// 广播在分片锁下取精确订阅者、在模式表读锁下取模式订阅者，订阅确认在解锁之前入队，
+// 所以无论哪种订阅，实时消息都不会早于确认
+func (s *Server) lockSubscriptions(channels []string) func() {
+	unlock := s.subscriptions.lockChannels(channels)
+	for _, channel := range channels {
+		if isPattern(channel) {
+			s.patterns.mu.Lock()
+			return func() {
+				s.patterns.mu.Unlock()
+				unlock()
+			}
+		}
+	}
+	return unlock
+}
+
+// 移除模式订阅，返回客户端之前是否订阅了该模式
+func (r *patternRegistry) remove(client *Client, pattern string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subs, ok := r.subs[pattern]
+	if !ok || !subs[client] {
+		return false
+	}
+	delete(subs, client)
+	if len(subs) == 0 {
+		delete(r.subs, pattern)
+		head := patternHead(pattern)
+		delete(r.byHead[head], pattern)
+		if len(r.byHead[head]) == 0 {
+			delete(r.byHead, head)
+		}
+	}
+	return true
+}
+
+// 通过模式订阅了该频道的客户端。只比较第一段与频道相同以及第一段为通配的模式，
+// 后者的数量由 MaxPatternsPerClient 限制。separator 不为空时只匹配与频道处于同一命名空间的模式
+func (r *patternRegistry) match(channel, separator string) []*Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	head, _, _ := strings.Cut(channel, ".")
+	var clients []*Client
+	for _, candidates := range []map[string]bool{r.byHead[head], r.byHead[""]} {
+		for pattern := range candidates {
+			if separator != "" && namespaceOf(pattern, separator) != namespaceOf(channel, separator) {
+				continue
+			}
+			if !matchPattern(pattern, channel) {
+				continue
+			}
+			for client := range r.subs[pattern] {
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
+
+// 合并精确订阅者和模式订阅者，同一客户端只保留一次
+func mergeSubscribers(exact, matched []*Client) []*Client {
+	if len(matched) == 0 {
+		return exact
+	}
+	seen := make(map[*Client]bool, len(exact))
+	for _, client := range exact {
+		seen[client] = true
+	}
+	for _, client := range matched {
+		if !seen[client] {
+			seen[client] = true
+			exact = append(exact, client)
+		}
+	}
+	return exact
+}
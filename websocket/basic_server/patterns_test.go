@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, channel string
+		want             bool
+	}{
+		{"sensors.*", "sensors.temp", true},
+		{"sensors.*", "sensors.temp.max", false},
+		{"sensors.*", "sensors", false},
+		{"sensors.**", "sensors.temp", true},
+		{"sensors.**", "sensors.temp.max", true},
+		{"sensors.**", "sensors", false},
+		{"sensors.**", "devices.temp", false},
+		{"*.temp", "sensors.temp", true},
+		{"*.temp", "sensors.a.temp", false},
+		{"**.temp", "sensors.a.temp", true},
+		{"a.**.b.**.c", "a.x.b.y.z.c", true},
+		{"a.**.b.**.c", "a.b.c", false},
+		{"a.**.**.b", "a.x.b", true},
+		{"a*b.c", "a*b.c", true},
+		{"a*b.c", "axb.c", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.channel); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.channel, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPatternManyGlobstarsIsFast(t *testing.T) {
+	// 回溯实现在这里需要指数时间
+	pattern := strings.Repeat("**.x.", 20) + "never"
+	channel := strings.TrimSuffix(strings.Repeat("x.", 120), ".")
+	start := time.Now()
+	if matchPattern(pattern, channel) {
+		t.Fatal("不应匹配")
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("匹配耗时 %v", d)
+	}
+}
+
+func TestSubscribeRejectsTooManyGlobstars(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+
+	c.Send(Message{Action: "subscribe", Channel: "a.**.b.**.c.**.d.**.e.**"})
+	if resp := c.Expect("subscribe"); resp.Code != CodeInvalidChannel {
+		t.Fatalf("code = %d, want %d", resp.Code, CodeInvalidChannel)
+	}
+	// 连续的 "**" 合并后计数
+	c.Subscribe("a.**.**.**.**.**.b")
+}
+
+func TestPatternRegistryIndex(t *testing.T) {
+	r := newPatternRegistry()
+	a, b, c := &Client{ID: "a"}, &Client{ID: "b"}, &Client{ID: "c"}
+	r.add(a, "sensors.*")
+	r.add(b, "*.temp")
+	r.add(c, "devices.**")
+
+	ids := func(clients []*Client) map[string]bool {
+		got := map[string]bool{}
+		for _, client := range clients {
+			got[client.ID] = true
+		}
+		return got
+	}
+	if got := ids(r.match("sensors.temp", "")); len(got) != 2 || !got["a"] || !got["b"] {
+		t.Fatalf("sensors.temp 匹配 %v, want a 和 b", got)
+	}
+	if got := ids(r.match("devices.x.y", "")); len(got) != 1 || !got["c"] {
+		t.Fatalf("devices.x.y 匹配 %v, want c", got)
+	}
+
+	// 最后一个订阅者退出后模式从索引中移除
+	r.remove(a, "sensors.*")
+	r.remove(b, "*.temp")
+	if len(r.byHead) != 1 || len(r.match("sensors.temp", "")) != 0 {
+		t.Fatalf("移除后索引 %v", r.byHead)
+	}
+}
+
+func TestMaxPatternsPerClient(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxPatternsPerClient = 2
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("a.*")
+	c.Subscribe("*.b")
+	// 重复订阅和精确订阅不占用通配名额
+	c.Subscribe("a.*")
+	c.Subscribe("room")
+
+	c.Send(Message{Action: "subscribe", Channel: "c.**"})
+	if resp := c.Expect("subscribe"); resp.Code != CodeForbidden || resp.Msg != errTooManyPatterns.Error() {
+		t.Fatalf("超过上限的通配订阅: %d %s", resp.Code, resp.Msg)
+	}
+	// 退订后名额释放
+	c.Send(Message{Action: "unsubscribe", Channel: "a.*"})
+	c.Expect("unsubscribe")
+	c.Subscribe("c.**")
+}
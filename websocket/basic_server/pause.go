@@ -0,0 +1,42 @@
+package main
+
+// 暂停向客户端投递：消息照常进入发送队列，但 writePump（长轮询客户端为 GET）不再取出，直到 Resume。
+// 队列满后按 SlowClientPolicy 处理，与消费太慢的客户端相同，长时间暂停应配合 Overflow 或丢弃策略使用。
+// 暂停期间心跳照常进行，连接不会因此超时；调用时正在写出的那一条消息仍会发出。
+// 客户端被注销（踢出、慢客户端驱逐、服务器关闭等）后暂停不再生效，剩余消息照常写出后关闭连接
+func (c *Client) Pause() {
+	c.paused.Store(true)
+}
+
+// 恢复投递，暂停期间排队的消息按原有顺序发出
+func (c *Client) Resume() {
+	c.paused.Store(false)
+	select {
+	case c.resumed <- struct{}{}:
+	default:
+	}
+}
+
+// 是否处于暂停状态
+func (c *Client) Paused() bool {
+	return c.paused.Load()
+}
+
+// 本轮要读取的发送通道：暂停时返回 nil，select 不会从中取消息。
+// 已注销的客户端不理会暂停，这样才能读到通道关闭并结束 writePump
+func (c *Client) sendQueue() <-chan OutboundMessage {
+	if c.paused.Load() && !c.isRemoved() {
+		return nil
+	}
+	return c.Send
+}
+
+// 客户端是否已被 removeClient 注销
+func (c *Client) isRemoved() bool {
+	select {
+	case <-c.removed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauseHoldsMessagesUntilResume(t *testing.T) {
+	s, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	client := serverClient(s, c.ID)
+
+	client.Pause()
+	s.BroadcastToChannel("room", "one")
+	s.BroadcastToChannel("room", "two")
+	c.ExpectNone(200 * time.Millisecond)
+
+	client.Resume()
+	for _, want := range []string{"one", "two"} {
+		if msg := c.Expect("message"); msg.Data != want {
+			t.Fatalf("收到 %v, want %s", msg.Data, want)
+		}
+	}
+}
+
+func TestPausedClientClosedWhenEvicted(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 4
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	client := serverClient(s, c.ID)
+
+	// 暂停后队列很快被填满，客户端作为慢客户端被驱逐；连接必须关闭而不是一直挂着
+	client.Pause()
+	for i := 0; i < 10; i++ {
+		s.BroadcastToChannel("room", i)
+	}
+	waitFor(t, "paused client evicted", func() bool { return serverClient(s, c.ID) == nil })
+	c.ExpectClosed()
+}
@@ -0,0 +1,81 @@
+package main
+
+// 广播到没有订阅者的频道时的处理方式
+type EmptyChannelPolicy int
+
+const (
+	EmptyChannelLog     EmptyChannelPolicy = iota // 记录日志后丢弃（默认）
+	EmptyChannelDrop                              // 静默丢弃
+	EmptyChannelHook                              // 交给 OnUndeliverable 处理
+	EmptyChannelPersist                           // 暂存，第一个订阅者到来时回放
+)
+
+// 每个频道默认最多暂存的消息数
+const defaultPendingLimit = 100
+
+// 处理没有订阅者的广播。
+// EmptyChannelPersist 下如果检查期间已有客户端订阅，返回这些订阅者由调用方继续投递
+func (s *Server) handleEmptyChannel(msg BroadcastMsg) []*Client {
+	switch s.EmptyChannelPolicy {
+	case EmptyChannelDrop:
+	case EmptyChannelHook:
+		if s.OnUndeliverable != nil {
+			s.OnUndeliverable(msg)
+		}
+	case EmptyChannelPersist:
+		// 与订阅时的 replayPending 在同一分片锁下互斥，暂存和回放之间不会丢消息
+		sh := s.subscriptions.shard(msg.Channel)
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+
+		if subs := sh.subs[msg.Channel]; len(subs) > 0 {
+			clients := make([]*Client, 0, len(subs))
+			for client := range subs {
+				clients = append(clients, client)
+			}
+			return clients
+		}
+		limit := s.PendingLimit
+		if limit <= 0 {
+			limit = defaultPendingLimit
+		}
+		pending := append(sh.pending[msg.Channel], msg)
+		if len(pending) > limit {
+			pending = pending[len(pending)-limit:]
+		}
+		sh.pending[msg.Channel] = pending
+		s.Logger.Debug("频道没有订阅者，消息已暂存", "event", "pending", "channel", msg.Channel, "pending", len(pending))
+	default:
+		s.Logger.Info("频道没有订阅者", "event", "no_subscribers", "channel", msg.Channel)
+	}
+	return nil
+}
+
+// 把暂存的消息回放给频道的第一个订阅者（调用方需持有该频道分片的写锁）
+func (s *Server) replayPending(client *Client, channel string) {
+	sh := s.subscriptions.shard(channel)
+	pending, ok := sh.pending[channel]
+	if !ok {
+		return
+	}
+	delete(sh.pending, channel)
+
+	for _, msg := range pending {
+		response := Response{
+			Action:        "message",
+			Channel:       channel,
+			Code:          CodeSuccess,
+			Msg:           "success",
+			Data:          msg.Data,
+			CorrelationID: msg.CorrelationID,
+		}
+		data, ok := s.marshalFor(client, response)
+		if !ok {
+			continue
+		}
+		if !s.trySend(client, data) {
+			s.Logger.Warn("缓冲区已满，丢弃暂存消息", "event", "pending_dropped", "client_id", client.ID, "channel", channel)
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmptyChannelPolicies(t *testing.T) {
+	t.Run("log", func(t *testing.T) {
+		// 默认级别（Info）下就能看到被丢弃的广播
+		var logs bytes.Buffer
+		s, _ := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+		})
+		s.BroadcastToChannelSync("empty", "x")
+		if !strings.Contains(logs.String(), "event=no_subscribers channel=empty") {
+			t.Fatalf("没有记录空频道的广播: %s", logs.String())
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		var logs bytes.Buffer
+		s, _ := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+			s.EmptyChannelPolicy = EmptyChannelDrop
+		})
+		s.BroadcastToChannelSync("empty", "x")
+		if strings.Contains(logs.String(), "no_subscribers") {
+			t.Fatalf("静默丢弃时不应记录日志: %s", logs.String())
+		}
+	})
+
+	t.Run("hook", func(t *testing.T) {
+		got := make(chan BroadcastMsg, 1)
+		s, _ := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.EmptyChannelPolicy = EmptyChannelHook
+			s.OnUndeliverable = func(msg BroadcastMsg) { got <- msg }
+		})
+		s.BroadcastToChannelSync("empty", "x")
+		if msg := <-got; msg.Channel != "empty" || msg.Data != "x" {
+			t.Fatalf("OnUndeliverable(%+v)", msg)
+		}
+	})
+
+	t.Run("persist", func(t *testing.T) {
+		s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+			s.EmptyChannelPolicy = EmptyChannelPersist
+			s.PendingLimit = 2
+		})
+		for _, data := range []string{"a", "b", "c"} {
+			s.BroadcastToChannelSync("later", data)
+		}
+		// 只保留最近的 PendingLimit 条，第一个订阅者按顺序收到
+		c := Dial(t, ts, "")
+		c.Subscribe("later")
+		for _, want := range []string{"b", "c"} {
+			if msg := c.Expect("message"); msg.Data != want {
+				t.Fatalf("回放 %v, want %s", msg.Data, want)
+			}
+		}
+		c.ExpectNone(100 * time.Millisecond)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 长轮询的默认和最大等待时间
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 60 * time.Second
+)
+
+// 分配频道的下一个消息序号（在事件循环或该频道的投递 worker 中调用）
+func (s *Server) nextSeq(channel string) uint64 {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	s.channelSeq[channel]++
+	return s.channelSeq[channel]
+}
+
+// 频道当前的最新序号
+func (s *Server) currentSeq(channel string) uint64 {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	return s.channelSeq[channel]
+}
+
+// 返回频道历史中序号大于 cursor 的消息
+func (s *Server) historyAfter(channel string, cursor uint64) []Response {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	ring, ok := s.history[channel]
+	if !ok {
+		return nil
+	}
+	var out []Response
+	for _, entry := range ring.ordered() {
+		if entry.Seq > cursor {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// 把广播交给正在等待该频道的长轮询请求（调用方需持有读锁）
+func (s *Server) notifyPollers(resp Response) {
+	for waiter := range s.pollWaiters[resp.Channel] {
+		select {
+		case waiter <- resp:
+		default:
+			// 等待者缓冲已满，客户端下次轮询时可凭 cursor 从历史中补齐
+		}
+	}
+}
+
+// 长轮询接口：为无法使用 WebSocket 的客户端提供降级方案。
+//
+//	GET /poll?channel=room&cursor=41&timeout=30
+//
+// 有序号大于 cursor 的历史消息（需开启 HistorySize）时立即返回，否则阻塞到有新消息或超时。
+// 返回 Response 数组，超时返回空数组；客户端用最后一条消息的 seq 作为下一次的 cursor。
+// 带 session 参数时改为完整的会话传输，见 handlePollSession
+func (s *Server) HandlePoll(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("session"); token != "" {
+		s.handlePollSession(w, r, token)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	channel := query.Get("channel")
+	if channel == "" {
+		http.Error(w, "channel is required", http.StatusBadRequest)
+		return
+	}
+	var cursor uint64
+	if value := query.Get("cursor"); value != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	timeout, ok := parsePollTimeout(query.Get("timeout"))
+	if !ok {
+		http.Error(w, "invalid timeout", http.StatusBadRequest)
+		return
+	}
+
+	// 检查历史与登记等待在同一把锁内完成，广播不会落在两者之间
+	waiter := make(chan Response, 16)
+	s.mu.Lock()
+	var messages []Response
+	if cursor > 0 {
+		messages = s.historyAfter(channel, cursor)
+	}
+	if len(messages) == 0 {
+		if s.pollWaiters[channel] == nil {
+			s.pollWaiters[channel] = make(map[chan Response]bool)
+		}
+		s.pollWaiters[channel][waiter] = true
+	}
+	s.mu.Unlock()
+
+	if len(messages) == 0 {
+		timer := time.NewTimer(timeout)
+		select {
+		case resp := <-waiter:
+			messages = append(messages, resp)
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+
+		s.mu.Lock()
+		delete(s.pollWaiters[channel], waiter)
+		if len(s.pollWaiters[channel]) == 0 {
+			delete(s.pollWaiters, channel)
+		}
+		s.mu.Unlock()
+
+		// 取走登记期间已到达的其它消息
+		for len(waiter) > 0 {
+			messages = append(messages, <-waiter)
+		}
+	}
+
+	if messages == nil {
+		messages = []Response{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// 解析 timeout 参数（秒）：为空时使用默认值，超过上限时按上限处理
+func parsePollTimeout(value string) (time.Duration, bool) {
+	if value == "" {
+		return defaultPollTimeout, true
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	timeout := time.Duration(seconds) * time.Second
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+	return timeout, true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 长轮询会话的 HTTP 客户端
+type pollSession struct {
+	t     *testing.T
+	ts    *httptest.Server
+	token string
+}
+
+// 发送一条消息，期望 202
+func (p *pollSession) send(msg Message) {
+	p.t.Helper()
+	body, _ := json.Marshal(msg)
+	resp, err := http.Post(p.ts.URL+"/poll?session="+p.token, "application/json", bytes.NewReader(body))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		p.t.Fatalf("POST /poll status %d", resp.StatusCode)
+	}
+}
+
+// 取回排队的消息，没有消息时最多等待一秒
+func (p *pollSession) receive() []Response {
+	p.t.Helper()
+	resp, err := http.Get(p.ts.URL + "/poll?session=" + p.token + "&timeout=1")
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		p.t.Fatalf("GET /poll status %d", resp.StatusCode)
+	}
+	var messages []Response
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		p.t.Fatal(err)
+	}
+	return messages
+}
+
+// 跳过其它消息直到收到 action 为 action 的一条
+func (p *pollSession) expect(action string) Response {
+	p.t.Helper()
+	for i := 0; i < 5; i++ {
+		for _, msg := range p.receive() {
+			if msg.Action == action {
+				return msg
+			}
+		}
+	}
+	p.t.Fatalf("长轮询没有收到 %s 消息", action)
+	return Response{}
+}
+
+func TestPollSessionSubscribeAndReceive(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SessionTTL = time.Minute
+	})
+
+	// 先用 WebSocket 连接拿到会话令牌，之后完全通过 HTTP 收发
+	ws := DialHeader(t, ts, "", nil)
+	data, _ := ws.Expect("connect").Data.(map[string]interface{})
+	token, _ := data["sessionToken"].(string)
+	if token == "" {
+		t.Fatal("连接确认中没有 sessionToken")
+	}
+	ws.Conn.Close()
+	waitFor(t, "websocket client unregistered", func() bool { return connectionCount(s) == 0 })
+
+	poll := &pollSession{t: t, ts: ts, token: token}
+	poll.send(Message{Action: "subscribe", Channel: "news", RequestID: "s1"})
+	if ack := poll.expect("subscribe"); ack.Code != CodeSuccess || ack.RequestID != "s1" {
+		t.Fatalf("订阅确认 %+v", ack)
+	}
+	if got := s.ChannelSubscribers("news"); len(got) != 1 {
+		t.Fatalf("news 的订阅者 %v", got)
+	}
+
+	s.BroadcastToChannel("news", "headline")
+	if msg := poll.expect("message"); msg.Channel != "news" || msg.Data != "headline" {
+		t.Fatalf("收到 %+v", msg)
+	}
+
+	// 通过 POST 发布，WebSocket 订阅者收到
+	peer := Dial(t, ts, "channels=news")
+	peer.Expect("subscribe")
+	poll.send(Message{Action: "publish", Channel: "news", Data: "from poll"})
+	if msg := peer.Expect("message"); msg.Data != "from poll" {
+		t.Fatalf("WebSocket 订阅者收到 %v", msg.Data)
+	}
+	poll.expect("publish")
+}
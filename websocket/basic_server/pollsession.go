@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 会话长轮询两次请求之间允许的最长间隔
+const defaultPollIdleTimeout = 30 * time.Second
+
+// 长轮询传输的状态：同一会话同时只允许一个 GET 等待消息，POST 按到达顺序串行处理
+type pollTransport struct {
+	receiving atomic.Bool  // 是否有 GET 正在等待
+	lastPoll  atomic.Int64 // 上一次请求结束的时间（UnixNano）
+	postMu    sync.Mutex   // processFrame 中的限流状态只允许一个读者访问
+}
+
+func (s *Server) pollIdleTimeout() time.Duration {
+	if s.PollIdleTimeout > 0 {
+		return s.PollIdleTimeout
+	}
+	return defaultPollIdleTimeout
+}
+
+// 会话长轮询：无法升级 WebSocket 的客户端凭之前连接得到的 sessionToken 通过 HTTP 收发消息。
+//
+//	GET  /poll?session=<token>&timeout=30   取回发送队列中的消息（数组），没有消息时阻塞到有消息或超时
+//	POST /poll?session=<token>              请求体为一条客户端消息，与 WebSocket 上发送的相同
+//
+// 第一次请求恢复该令牌保存的会话（与带 ?session= 重连 WebSocket 相同：重新订阅并补发错过的消息），
+// 之后的请求都落在同一个客户端上，直到超过 PollIdleTimeout 没有请求，会话被重新保存
+func (s *Server) handlePollSession(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout, ok := parsePollTimeout(r.URL.Query().Get("timeout"))
+	if !ok {
+		http.Error(w, "invalid timeout", http.StatusBadRequest)
+		return
+	}
+
+	// 每个请求都要认证，且必须与会话属于同一用户
+	userID, grant, ok := s.authorizeConnection(w, r)
+	if !ok {
+		return
+	}
+
+	client := s.pollClient(w, r, token, userID, grant)
+	if client == nil {
+		return
+	}
+	client.lastSeen.Store(time.Now().UnixNano())
+	client.heard()
+
+	if r.Method == "POST" {
+		s.pollSend(w, r, client)
+		return
+	}
+	s.pollReceive(w, r, client, timeout)
+}
+
+// 找到令牌对应的长轮询客户端，没有时恢复会话并注册一个新的。
+// 失败时已写出错误响应并返回 nil
+func (s *Server) pollClient(w http.ResponseWriter, r *http.Request, token, userID string, grant ConnectionGrant) *Client {
+	s.mu.RLock()
+	client := s.pollClients[token]
+	s.mu.RUnlock()
+	if client != nil {
+		if client.UserID != userID {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return nil
+		}
+		return client
+	}
+
+	// 恢复会话相当于建立新连接，经过与 WebSocket 握手相同的准入检查
+	if s.SessionTTL <= 0 {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil
+	}
+	if s.Draining() || s.closing.Load() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return nil
+	}
+	if s.rejectNotRunning(w) {
+		return nil
+	}
+	if s.rejectConnectionInMaintenance(w) {
+		return nil
+	}
+	if !isJSONCodec(s.codec()) {
+		// 发送队列中的消息原样放进 JSON 数组返回，其它编解码器无法这样拼接
+		http.Error(w, "long-poll sessions require the JSON codec", http.StatusNotImplemented)
+		return nil
+	}
+
+	// 取出会话和登记客户端在同一把锁内完成，同一令牌的并发请求只会恢复一次
+	ip := remoteIP(r)
+	s.mu.Lock()
+	if client = s.pollClients[token]; client != nil {
+		s.mu.Unlock()
+		if client.UserID != userID {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return nil
+		}
+		return client
+	}
+	if !s.reserveConnection(ip) {
+		s.mu.Unlock()
+		s.rejectAtCapacity(w, ip)
+		return nil
+	}
+	sess := s.takeSession(token, userID)
+	if sess == nil {
+		s.releaseConnection(ip)
+		s.mu.Unlock()
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil
+	}
+	client = s.newClient(r, userID, grant, ip, &connCounters{})
+	client.poll = &pollTransport{}
+	client.poll.lastPoll.Store(time.Now().UnixNano())
+	client.sessionToken = token
+	client.resume = sess
+	s.pollClients[token] = client
+	s.mu.Unlock()
+
+	if !s.admit(client) {
+		s.mu.Lock()
+		if s.pollClients[token] == client {
+			delete(s.pollClients, token)
+		}
+		s.mu.Unlock()
+		http.Error(w, "server busy", http.StatusServiceUnavailable)
+		return nil
+	}
+	s.Logger.Info("会话已切换到长轮询", "event", "poll_session", "client_id", client.ID)
+	go s.pollPump(client)
+	return client
+}
+
+// 取回发送队列中的消息：队列为空时等待到有消息、超时或客户端断开，
+// 取到第一条后一并取走已排队的其它消息和溢出存储中的消息
+func (s *Server) pollReceive(w http.ResponseWriter, r *http.Request, client *Client, timeout time.Duration) {
+	if !client.poll.receiving.CompareAndSwap(false, true) {
+		http.Error(w, "poll already in progress", http.StatusConflict)
+		return
+	}
+	defer func() {
+		client.poll.lastPoll.Store(time.Now().UnixNano())
+		client.poll.receiving.Store(false)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	messages := []json.RawMessage{}
+	closed := false
+	removed := client.removed // 暂停中被注销时唤醒，下一轮 sendQueue 不再理会暂停
+wait:
+	for len(messages) == 0 {
+		select {
+		case message, ok := <-client.sendQueue():
+			if !ok {
+				closed = true
+				break wait
+			}
+			s.accountDequeue(client, len(message.Payload))
+			messages = s.appendPolled(client, messages, message)
+		case <-client.resumed:
+		case <-removed:
+			removed = nil
+		case <-timer.C:
+			break wait
+		case <-client.ctx.Done():
+			break wait
+		case <-r.Context().Done():
+			// 还没有取走任何消息，直接返回即可
+			return
+		}
+	}
+
+drain:
+	for !closed && len(messages) > 0 && client.sendQueue() != nil {
+		select {
+		case message, ok := <-client.Send:
+			if !ok {
+				closed = true
+				break drain
+			}
+			s.accountDequeue(client, len(message.Payload))
+			messages = s.appendPolled(client, messages, message)
+		default:
+			s.drainOverflowTo(client, func(message OutboundMessage) error {
+				messages = s.appendPolled(client, messages, message)
+				return nil
+			})
+			break drain
+		}
+	}
+	if closed {
+		// 客户端已被注销（踢出、服务器关闭等），结束 pollPump
+		client.cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// 把一帧加入长轮询响应。过期的消息、二进制帧和不是 JSON 的原始帧无法放进响应，直接跳过
+func (s *Server) appendPolled(client *Client, messages []json.RawMessage, message OutboundMessage) []json.RawMessage {
+	if s.expired(message) {
+		return messages
+	}
+	if message.Type != websocket.TextMessage || !json.Valid(message.Payload) {
+		s.Logger.Debug("长轮询无法承载该帧，已丢弃", "event", "poll_frame_dropped", "client_id", client.ID, "bytes", len(message.Payload))
+		return messages
+	}
+	client.counters.sent(len(message.Payload))
+	return append(messages, json.RawMessage(message.Payload))
+}
+
+// 通过 POST 发送一条消息，与从 WebSocket 读到的文本帧经过相同的处理；响应在之后的 GET 中取回
+func (s *Server) pollSend(w http.ResponseWriter, r *http.Request, client *Client) {
+	body := r.Body
+	if s.MaxMessageSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.MaxMessageSize)
+	}
+	message, err := io.ReadAll(body)
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "message too big", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 与 readPump 一样，处理中 panic 只关闭这个客户端
+	ok := func() bool {
+		client.poll.postMu.Lock()
+		defer client.poll.postMu.Unlock()
+		defer s.recoverClient(client, "pollSend")
+		return s.processFrame(client, websocket.TextMessage, message)
+	}()
+	client.poll.lastPoll.Store(time.Now().UnixNano())
+	if !ok {
+		// 持续超出消息速率或处理中 panic，客户端已被关闭
+		http.Error(w, "connection closed", http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// 长轮询客户端的生命周期，相当于 WebSocket 连接的 readPump：
+// 被关闭、服务器关闭或超过 PollIdleTimeout 没有请求时退出并注销，注销时会话被重新保存
+func (s *Server) pollPump(client *Client) {
+	defer s.writers.Done()
+	defer func() {
+		client.recordClose(websocket.CloseAbnormalClosure, "")
+		code, reason := client.CloseStatus()
+		s.Metrics.countDisconnect(code)
+
+		s.publishWill(client)
+		select {
+		case s.unregister <- client:
+		case <-s.done:
+		}
+		client.cancel()
+		if s.OnDisconnect != nil {
+			func() {
+				defer s.recoverClient(client, "OnDisconnect")
+				s.OnDisconnect(client, code, reason)
+			}()
+		}
+	}()
+
+	timeout := s.pollIdleTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-timer.C:
+			if client.poll.receiving.Load() {
+				timer.Reset(timeout)
+				continue
+			}
+			idle := time.Since(time.Unix(0, client.poll.lastPoll.Load()))
+			if idle >= timeout {
+				s.Logger.Info("长轮询超时没有请求，断开", "event", "poll_timeout", "client_id", client.ID, "timeout", timeout)
+				client.recordClose(websocket.CloseAbnormalClosure, "poll timeout")
+				return
+			}
+			timer.Reset(timeout - idle)
+		}
+	}
+}
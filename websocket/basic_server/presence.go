@@ -0,0 +1,209 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 聚合在线状态默认的推送间隔
+const defaultPresenceInterval = time.Second
+
+// 在线状态事件的内容
+type PresenceEvent struct {
+	Event       string `json:"event"` // join 或 leave
+	ClientID    string `json:"clientId"`
+	Subscribers int    `json:"subscribers"` // 事件发生后的订阅数
+}
+
+// 为频道开启在线状态事件：有人订阅/取消订阅（包括断开）时通知其余订阅者
+func (s *Server) EnablePresence(channel string) {
+	s.mu.Lock()
+	s.presenceChannels[channel] = true
+	s.mu.Unlock()
+}
+
+// 关闭频道的在线状态事件
+func (s *Server) DisablePresence(channel string) {
+	s.mu.Lock()
+	delete(s.presenceChannels, channel)
+	s.mu.Unlock()
+}
+
+// 向频道内除 client 以外的订阅者发送在线状态事件（调用方需持有 s.mu 和该频道分片的写锁）
+func (s *Server) notifyPresence(channel, event string, client *Client) {
+	// 聚合模式只记下频道有变化，由定时推送合并发送
+	if s.aggregatePresence[channel] {
+		s.presenceCounts.markDirty(channel)
+		return
+	}
+	if !s.presenceChannels[channel] {
+		return
+	}
+	subs := s.subscriptions.shard(channel).subs[channel]
+	if len(subs) == 0 || (len(subs) == 1 && subs[client]) {
+		return
+	}
+
+	response := Response{
+		Action:  "presence",
+		Channel: channel,
+		Code:    CodeSuccess,
+		Msg:     "success",
+		Data: PresenceEvent{
+			Event:       event,
+			ClientID:    client.ID,
+			Subscribers: len(subs),
+		},
+	}
+	data, ok := s.marshal(response)
+	if !ok {
+		return
+	}
+	for peer := range subs {
+		if peer == client {
+			continue
+		}
+		frame, ok := s.sharedFrame(peer, response, data)
+		if !ok {
+			continue
+		}
+		// 与其它响应一样，缓冲区满的客户端直接断开
+		if !s.enqueue(peer, frame) {
+			s.Logger.Warn("发送缓冲区已满，断开连接", "event", "slow_client", "client_id", peer.ID)
+			s.Metrics.SlowEvictions.Add(1)
+			peer.closeConn()
+		}
+	}
+}
+
+// 聚合在线状态的推送内容
+type PresenceCount struct {
+	Subscribers int `json:"subscribers"` // 当前订阅数
+	Delta       int `json:"delta"`       // 与上一次推送相比的变化
+}
+
+// 聚合在线状态的待推送频道和上一次推送的订阅数
+type presenceCounts struct {
+	mu    sync.Mutex
+	dirty map[string]bool
+	last  map[string]int
+}
+
+func (p *presenceCounts) markDirty(channel string) {
+	p.mu.Lock()
+	if p.dirty == nil {
+		p.dirty = make(map[string]bool)
+	}
+	p.dirty[channel] = true
+	p.mu.Unlock()
+}
+
+// 取出有变化的频道
+func (p *presenceCounts) takeDirty() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channels := make([]string, 0, len(p.dirty))
+	for channel := range p.dirty {
+		channels = append(channels, channel)
+	}
+	p.dirty = nil
+	return channels
+}
+
+// 记录本次的订阅数，返回与上一次相比的变化
+func (p *presenceCounts) update(channel string, count int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last == nil {
+		p.last = make(map[string]int)
+	}
+	delta := count - p.last[channel]
+	if count == 0 {
+		delete(p.last, channel)
+	} else {
+		p.last[channel] = count
+	}
+	return delta
+}
+
+// 为频道开启聚合在线状态：不再逐条通知 join/leave，而是每隔 PresenceInterval 向订阅者推送一次订阅数及其变化，
+// 期间的多次加入和离开合并为一次推送。优先于 EnablePresence
+func (s *Server) EnableAggregatePresence(channel string) {
+	s.mu.Lock()
+	s.aggregatePresence[channel] = true
+	s.presenceCounts.update(channel, s.subscriptions.count(channel))
+	s.mu.Unlock()
+}
+
+// 关闭频道的聚合在线状态
+func (s *Server) DisableAggregatePresence(channel string) {
+	s.mu.Lock()
+	delete(s.aggregatePresence, channel)
+	s.presenceCounts.update(channel, 0)
+	s.mu.Unlock()
+}
+
+func (s *Server) presenceInterval() time.Duration {
+	if s.PresenceInterval > 0 {
+		return s.PresenceInterval
+	}
+	return defaultPresenceInterval
+}
+
+// 定时推送聚合在线状态，事件循环退出时停止
+func (s *Server) runAggregatePresence() {
+	ticker := time.NewTicker(s.presenceInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, channel := range s.presenceCounts.takeDirty() {
+				s.pushPresenceCount(channel)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// 向频道的所有订阅者推送当前订阅数，订阅数与上一次推送相同时不发送
+func (s *Server) pushPresenceCount(channel string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.aggregatePresence[channel] {
+		return
+	}
+	sh := s.subscriptions.shard(channel)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	subs := sh.subs[channel]
+	delta := s.presenceCounts.update(channel, len(subs))
+	if delta == 0 || len(subs) == 0 {
+		return
+	}
+	response := Response{
+		Action:  "presence_count",
+		Channel: channel,
+		Code:    CodeSuccess,
+		Msg:     "success",
+		Data:    PresenceCount{Subscribers: len(subs), Delta: delta},
+	}
+	data, ok := s.marshal(response)
+	if !ok {
+		return
+	}
+	for peer := range subs {
+		frame, ok := s.sharedFrame(peer, response, data)
+		if !ok {
+			continue
+		}
+		if !s.enqueue(peer, frame) {
+			s.Logger.Warn("发送缓冲区已满，断开连接", "event", "slow_client", "client_id", peer.ID)
+			s.Metrics.SlowEvictions.Add(1)
+			peer.closeConn()
+		}
+	}
+}
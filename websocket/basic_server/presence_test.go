@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDisconnectSendsOneLeavePerChannel(t *testing.T) {
+	s, ts := NewTestServer(t)
+	for _, channel := range []string{"a", "b", "c"} {
+		s.EnablePresence(channel)
+	}
+	watcher := Dial(t, ts, "")
+	for _, channel := range []string{"a", "b", "c"} {
+		watcher.Subscribe(channel)
+	}
+	leaver := Dial(t, ts, "")
+	leaver.Subscribe("a")
+	leaver.Subscribe("b")
+	client := serverClient(s, leaver.ID)
+
+	leaver.Conn.Close()
+	waitFor(t, "leaver removed", func() bool { return serverClient(s, leaver.ID) == nil })
+	// 重复注销不应再产生 leave
+	s.unregister <- client
+
+	leaves := make(map[string]int)
+	for {
+		resp, err := watcher.NextMessage(300 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		if resp.Action != "presence" {
+			continue
+		}
+		event := resp.Data.(map[string]interface{})
+		if event["event"] == "leave" && event["clientId"] == leaver.ID {
+			leaves[resp.Channel]++
+		}
+	}
+	if leaves["a"] != 1 || leaves["b"] != 1 || len(leaves) != 2 {
+		t.Fatalf("leave 事件 = %v, want a 和 b 各一次", leaves)
+	}
+}
+
+func TestAggregatePresenceCoalescesJoins(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.PresenceInterval = 500 * time.Millisecond
+	})
+	s.EnableAggregatePresence("big")
+	watcher := Dial(t, ts, "")
+	watcher.Subscribe("big")
+	// 等到 watcher 自己的加入被推送，之后的加入都落在同一个周期内
+	if resp := watcher.Expect("presence_count"); !reflect.DeepEqual(resp.Data, map[string]interface{}{"subscribers": float64(1), "delta": float64(1)}) {
+		t.Fatalf("第一次推送 %v", resp.Data)
+	}
+
+	for i := 0; i < 20; i++ {
+		Dial(t, ts, "channels=big").Expect("subscribe")
+	}
+
+	var updates []interface{}
+	for {
+		resp, err := watcher.NextMessage(time.Second)
+		if err != nil {
+			break
+		}
+		switch resp.Action {
+		case "presence":
+			t.Fatalf("聚合模式下不应逐条推送 join: %+v", resp)
+		case "presence_count":
+			updates = append(updates, resp.Data)
+		}
+	}
+	want := []interface{}{map[string]interface{}{"subscribers": float64(21), "delta": float64(20)}}
+	if !reflect.DeepEqual(updates, want) {
+		t.Fatalf("推送 %v, want 一次合并的 %v", updates, want)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "sort"
+
+// 设置客户端的投递优先级：一次频道广播先发给优先级高的订阅者，同一优先级内顺序不变。
+// 默认都是 0，即原来的投递顺序；可以在 OnConnect 中根据 UserID 或属性设置，之后的广播生效
+func (c *Client) SetPriority(priority int) {
+	c.priority.Store(int32(priority))
+}
+
+// 客户端的投递优先级
+func (c *Client) Priority() int {
+	return int(c.priority.Load())
+}
+
+// 按优先级从高到低排列订阅者（稳定排序）。所有订阅者优先级相同时不排序，没有额外开销
+func sortClientsByPriority(clients []*Client) {
+	if len(clients) < 2 {
+		return
+	}
+	first := clients[0].Priority()
+	for _, client := range clients[1:] {
+		if client.Priority() != first {
+			sort.SliceStable(clients, func(i, j int) bool {
+				return clients[i].Priority() > clients[j].Priority()
+			})
+			return
+		}
+	}
+}
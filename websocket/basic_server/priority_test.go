@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestPriorityTiersEnqueueFirst(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.DeterministicFanout = true
+		// 拦截器按投递顺序对每个订阅者调用一次，用来记录入队顺序
+		s.OutboundInterceptor = func(client *Client, resp *Response) bool {
+			if resp.Action == "message" && resp.Channel == "feed" {
+				mu.Lock()
+				order = append(order, client.ID)
+				mu.Unlock()
+			}
+			return true
+		}
+	})
+
+	var makers, retail []string
+	for i := 0; i < 6; i++ {
+		c := Dial(t, ts, "channels=feed")
+		c.Expect("subscribe")
+		if i%2 == 1 {
+			serverClient(s, c.ID).SetPriority(10)
+			makers = append(makers, c.ID)
+		} else {
+			retail = append(retail, c.ID)
+		}
+	}
+	if delivered, _ := s.BroadcastToChannelSync("feed", "tick"); delivered != 6 {
+		t.Fatalf("投递 %d 个, want 6", delivered)
+	}
+
+	// 高优先级在前；同一优先级内保持原来的（按 ID 排序的）顺序
+	sort.Strings(makers)
+	sort.Strings(retail)
+	want := append(makers, retail...)
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("入队顺序 %v, want %v", order, want)
+	}
+}
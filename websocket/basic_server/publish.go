@@ -0,0 +1,39 @@
+package main
+
+// 处理客户端发布：广播到频道的其他订阅者。发布者必须已订阅该频道，
+// 并通过 CanPublish 和发布限流
+func (s *Server) handlePublish(client *Client, msg *Message) {
+	subscribed := client.subscribed(msg.Channel)
+
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: msg.RequestID,
+		Action:    "publish",
+		Channel:   msg.Channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+	switch {
+	case isPattern(msg.Channel):
+		response.Code = CodeBadRequest
+		response.Msg = "cannot publish to a pattern"
+	case !subscribed:
+		response.Code = CodeNotSubscribed
+		response.Msg = "not subscribed"
+	case !s.canPublish(client, msg.Channel):
+		response.Code = CodeForbidden
+		response.Msg = "publish not allowed"
+	case !s.allowPublish(client, msg.Channel):
+		response.Code = CodeRateLimited
+		response.Msg = "publish rate limited"
+	}
+	if response.Code != CodeSuccess {
+		s.Logger.Debug("发布被拒绝", "event", "publish_rejected", "client_id", client.ID, "channel", msg.Channel, "reason", response.Msg)
+		s.sendResponse(client, response)
+		return
+	}
+
+	// 发布者自己不会收到回显
+	s.BroadcastToChannelExcept(msg.Channel, msg.Data, client.ID)
+	s.sendResponse(client, response)
+}
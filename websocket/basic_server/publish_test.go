@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishExcludesSender(t *testing.T) {
+	_, ts := NewTestServer(t)
+	sender := Dial(t, ts, "")
+	a := Dial(t, ts, "")
+	b := Dial(t, ts, "")
+	for _, c := range []*TestClient{sender, a, b} {
+		c.Subscribe("chat")
+	}
+
+	if resp := sender.Publish("chat", "hello"); resp.Code != CodeSuccess {
+		t.Fatalf("发布失败: %d %s", resp.Code, resp.Msg)
+	}
+	for _, c := range []*TestClient{a, b} {
+		if msg := c.Expect("message"); msg.Data != "hello" {
+			t.Fatalf("收到 %v", msg.Data)
+		}
+	}
+	sender.ExpectNone(100 * time.Millisecond)
+}
+
+func TestBroadcastToChannelExcept(t *testing.T) {
+	s, ts := NewTestServer(t)
+	clients := []*TestClient{Dial(t, ts, ""), Dial(t, ts, ""), Dial(t, ts, "")}
+	for _, c := range clients {
+		c.Subscribe("chat")
+	}
+
+	s.BroadcastToChannelExcept("chat", "hi", clients[0].ID)
+	clients[1].Expect("message")
+	clients[2].Expect("message")
+	clients[0].ExpectNone(100 * time.Millisecond)
+}
+
+func TestChannelOrderUnderConcurrentPublishers(t *testing.T) {
+	const publishers, perPublisher = 4, 200
+	for _, workers := range []int{0, 4} {
+		t.Run(fmt.Sprintf("fanout workers %d", workers), func(t *testing.T) {
+			s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+				s.SendBufferSize = publishers * perPublisher
+				s.FanoutWorkers = workers
+			})
+			subs := []*TestClient{Dial(t, ts, ""), Dial(t, ts, ""), Dial(t, ts, "")}
+			for _, c := range subs {
+				c.Subscribe("room")
+			}
+
+			var wg sync.WaitGroup
+			for p := 0; p < publishers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for n := 0; n < perPublisher; n++ {
+						s.BroadcastToChannel("room", map[string]int{"p": p, "n": n})
+					}
+				}(p)
+			}
+			wg.Wait()
+
+			// 不同发布者之间可以交错，但每个发布者的序号在每个订阅者处都必须连续递增
+			for i, c := range subs {
+				next := make([]int, publishers)
+				for k := 0; k < publishers*perPublisher; k++ {
+					data, _ := c.Expect("message").Data.(map[string]interface{})
+					p, n := int(data["p"].(float64)), int(data["n"].(float64))
+					if n != next[p] {
+						t.Fatalf("订阅者 %d: 发布者 %d 收到序号 %d, want %d", i, p, n, next[p])
+					}
+					next[p]++
+				}
+			}
+		})
+	}
+}
+
+func TestBroadcastToChannelSyncCounts(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 2
+	})
+	if delivered, skipped := s.BroadcastToChannelSync("room", "nobody"); delivered != 0 || skipped != 0 {
+		t.Fatalf("没有订阅者: (%d, %d), want (0, 0)", delivered, skipped)
+	}
+
+	healthy := Dial(t, ts, "")
+	healthy.Subscribe("room")
+	stalled := Dial(t, ts, "")
+	stalled.Subscribe("room")
+	serverClient(s, stalled.ID).Pause()
+
+	// 卡住的订阅者缓冲区满之前两个都收到；满了之后它被断开，计为 skipped。
+	// 每条广播后等健康的订阅者读到，它的缓冲区不会跟着被填满
+	if delivered, skipped := s.BroadcastToChannelSync("room", 0); delivered != 2 || skipped != 0 {
+		t.Fatalf("第一条: (%d, %d), want (2, 0)", delivered, skipped)
+	}
+	healthy.Expect("message")
+	for i := 1; ; i++ {
+		if i > 4 {
+			t.Fatal("卡住的订阅者一直没有被跳过")
+		}
+		delivered, skipped := s.BroadcastToChannelSync("room", i)
+		healthy.Expect("message")
+		if skipped == 0 {
+			continue
+		}
+		if delivered != 1 || skipped != 1 {
+			t.Fatalf("缓冲区满后: (%d, %d), want (1, 1)", delivered, skipped)
+		}
+		break
+	}
+	stalled.ExpectClosed()
+	if delivered, skipped := s.BroadcastToChannelSync("room", "after"); delivered != 1 || skipped != 0 {
+		t.Fatalf("断开后: (%d, %d), want (1, 0)", delivered, skipped)
+	}
+}
+
+func TestOnUndelivered(t *testing.T) {
+	type undelivered struct {
+		clientID, channel string
+		data              interface{}
+	}
+	var mu sync.Mutex
+	var got []undelivered
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 2
+		s.OnUndelivered = func(clientID, channel string, data interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, undelivered{clientID, channel, data})
+		}
+	})
+	healthy := Dial(t, ts, "")
+	healthy.Subscribe("room")
+	stalled := Dial(t, ts, "")
+	stalled.Subscribe("room")
+	serverClient(s, stalled.ID).Pause()
+
+	// 卡住的订阅者缓冲区满后被断开，没有送达的那条交给 OnUndelivered；健康的订阅者每条都读到，不出现在其中
+	for i := 0; i < 5; i++ {
+		s.BroadcastToChannelSync("room", i)
+		healthy.Expect("message")
+	}
+	stalled.ExpectClosed()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("OnUndelivered 调用 %d 次: %+v", len(got), got)
+	}
+	if got[0].clientID != stalled.ID || got[0].channel != "room" {
+		t.Fatalf("OnUndelivered(%q, %q), want (%q, room)", got[0].clientID, got[0].channel, stalled.ID)
+	}
+	if n, ok := got[0].data.(int); !ok || n < 2 || n > 3 {
+		t.Fatalf("未送达的消息 %v, want 缓冲区满后的第一条", got[0].data)
+	}
+}
+
+func TestMessageFilter(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.Authenticator = func(r *http.Request) (string, error) { return r.URL.Query().Get("user"), nil }
+		s.MessageFilter = func(client *Client, channel string, data interface{}) (interface{}, bool) {
+			order := data.(map[string]interface{})
+			switch client.UserID {
+			case "admin":
+				return data, true
+			case "guest":
+				return nil, false
+			}
+			// 其他用户看到去掉价格的副本，原数据不能被修改
+			redacted := map[string]interface{}{}
+			for k, v := range order {
+				if k != "price" {
+					redacted[k] = v
+				}
+			}
+			return redacted, true
+		}
+	})
+	admin := Dial(t, ts, "user=admin&channels=orders")
+	user := Dial(t, ts, "user=bob&channels=orders")
+	guest := Dial(t, ts, "user=guest&channels=orders")
+	for _, c := range []*TestClient{admin, user, guest} {
+		c.Expect("subscribe")
+	}
+
+	s.BroadcastToChannel("orders", map[string]interface{}{"symbol": "BTC", "price": 100})
+	if msg := admin.Expect("message"); !reflect.DeepEqual(msg.Data, map[string]interface{}{"symbol": "BTC", "price": float64(100)}) {
+		t.Fatalf("admin 收到 %v", msg.Data)
+	}
+	if msg := user.Expect("message"); !reflect.DeepEqual(msg.Data, map[string]interface{}{"symbol": "BTC"}) {
+		t.Fatalf("普通用户收到 %v, want 去掉 price", msg.Data)
+	}
+	guest.ExpectNone(100 * time.Millisecond)
+}
+
+func TestOutboundInterceptor(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.OutboundInterceptor = func(client *Client, resp *Response) bool {
+			if resp.Data == "blocked" {
+				return false
+			}
+			// 去掉 ssn 字段；Data 由所有接收者共享，改写时复制一份
+			if data, ok := resp.Data.(map[string]interface{}); ok {
+				redacted := make(map[string]interface{}, len(data))
+				for k, v := range data {
+					if k != "ssn" {
+						redacted[k] = v
+					}
+				}
+				resp.Data = redacted
+			}
+			return true
+		}
+	})
+	a := Dial(t, ts, "channels=room")
+	b := Dial(t, ts, "channels=room")
+	for _, c := range []*TestClient{a, b} {
+		c.Expect("subscribe")
+	}
+
+	s.BroadcastToChannel("room", map[string]interface{}{"name": "alice", "ssn": "123-45-6789"})
+	for _, c := range []*TestClient{a, b} {
+		if msg := c.Expect("message"); !reflect.DeepEqual(msg.Data, map[string]interface{}{"name": "alice"}) {
+			t.Fatalf("收到 %v, want 去掉 ssn", msg.Data)
+		}
+	}
+
+	// 被拦截的消息不发送，私信返回 ErrMessageBlocked
+	if delivered, _ := s.BroadcastToChannelSync("room", "blocked"); delivered != 0 {
+		t.Fatalf("被拦截的广播投递了 %d 个订阅者", delivered)
+	}
+	if err := s.SendToClient(a.ID, "blocked"); err != ErrMessageBlocked {
+		t.Fatalf("SendToClient = %v, want ErrMessageBlocked", err)
+	}
+	a.ExpectNone(100 * time.Millisecond)
+	if n := s.Metrics.OutboundBlocked.Load(); n != 3 {
+		t.Fatalf("OutboundBlocked = %d, want 3", n)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 令牌桶限流器
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+}
+
+// 创建令牌桶，初始为满桶；burst 小于 1 时按 1 处理
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 尝试取一个令牌
+func (b *tokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 限流配置
+type rateConfig struct {
+	rate  float64
+	burst int
+}
+
+// 设置单个频道上每个发布者的发布速率，覆盖全局的 PublishRate/PublishBurst；rate 为 0 表示该频道不限制。
+// 已有的发布者在下一次发布时按新配置重建令牌桶
+func (s *Server) SetChannelPublishRate(channel string, rate float64, burst int) {
+	s.mu.Lock()
+	s.channelPublishRates[channel] = rateConfig{rate: rate, burst: burst}
+	s.mu.Unlock()
+}
+
+// 设置单个频道的广播速率，覆盖全局的 ChannelRate/ChannelBurst；rate 为 0 表示该频道不限制。
+// 该频道的令牌桶在下一条广播时按新配置重建
+func (s *Server) SetChannelRate(channel string, rate float64, burst int) {
+	s.mu.Lock()
+	s.channelRates[channel] = rateConfig{rate: rate, burst: burst}
+	s.mu.Unlock()
+}
+
+// 检查频道能否再广播一条消息。每个频道一个令牌桶，所有发布者（包括服务端的 Broadcast 调用）共享。
+// 只在事件循环中调用，channelBuckets 无需加锁
+func (s *Server) allowBroadcast(channel string) bool {
+	s.mu.RLock()
+	cfg, ok := s.channelRates[channel]
+	s.mu.RUnlock()
+	if !ok {
+		cfg = rateConfig{rate: s.ChannelRate, burst: s.ChannelBurst}
+	}
+	if cfg.rate <= 0 {
+		delete(s.channelBuckets, channel)
+		return true
+	}
+
+	bucket, ok := s.channelBuckets[channel]
+	if !ok || !bucket.matches(cfg) {
+		bucket = newTokenBucket(cfg.rate, cfg.burst)
+		s.channelBuckets[channel] = bucket
+	}
+	return bucket.Allow()
+}
+
+// 令牌桶是否按该配置创建
+func (b *tokenBucket) matches(cfg rateConfig) bool {
+	burst := cfg.burst
+	if burst < 1 {
+		burst = 1
+	}
+	return b.rate == cfg.rate && b.burst == float64(burst)
+}
+
+// 检查客户端能否再向频道发布一条消息。
+// 每个（客户端，频道）独立计数，一个发布者刷屏不会影响同频道的其他发布者。
+// 配置变化后令牌桶按新配置重建；退订或断开时随订阅一起删除
+func (s *Server) allowPublish(client *Client, channel string) bool {
+	s.mu.RLock()
+	cfg, ok := s.channelPublishRates[channel]
+	s.mu.RUnlock()
+	if !ok {
+		cfg = rateConfig{rate: s.PublishRate, burst: s.PublishBurst}
+	}
+
+	client.channelsMu.Lock()
+	defer client.channelsMu.Unlock()
+	if cfg.rate <= 0 {
+		delete(client.publishLimiters, channel)
+		return true
+	}
+	limiter, ok := client.publishLimiters[channel]
+	if !ok || !limiter.matches(cfg) {
+		limiter = newTokenBucket(cfg.rate, cfg.burst)
+		client.publishLimiters[channel] = limiter
+	}
+	return limiter.Allow()
+}
+
+// 入站消息限流检查，超限时返回 429 并累计连续超限次数
+func (s *Server) allowMessage(client *Client, msg *Message) bool {
+	if client.messageLimiter == nil || client.messageLimiter.Allow() {
+		client.throttled = 0
+		return true
+	}
+
+	client.throttled++
+	response := errorResponse(client, msg.Action, CodeRateLimited, "message rate limited")
+	response.RequestID = msg.RequestID
+	response.Channel = msg.Channel
+	s.sendResponse(client, response)
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMessageRateLimit(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MessageRate = 0.1
+		s.MessageBurst = 3
+	})
+	c := Dial(t, ts, "")
+	for i := 0; i < 5; i++ {
+		c.Send(Message{Action: "ping", RequestID: "p"})
+	}
+
+	pongs, limited := 0, 0
+	for i := 0; i < 5; i++ {
+		resp, err := c.NextMessage(testTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case resp.Action == "pong":
+			pongs++
+		case resp.Code == CodeRateLimited && resp.Action == "ping" && resp.RequestID == "p":
+			limited++
+		default:
+			t.Fatalf("意外的响应 %+v", resp)
+		}
+	}
+	if pongs != 3 || limited != 2 {
+		t.Fatalf("pong %d 条、429 %d 条, want 3 和 2", pongs, limited)
+	}
+}
+
+func TestMessageAbuseLimitDisconnects(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MessageRate = 0.1
+		s.MessageBurst = 1
+		s.MessageAbuseLimit = 3
+	})
+	c := Dial(t, ts, "")
+	for i := 0; i < 10; i++ {
+		if err := c.Conn.WriteJSON(Message{Action: "ping"}); err != nil {
+			break
+		}
+	}
+	code, reason := c.ExpectClosed()
+	if code != websocket.ClosePolicyViolation || reason != "message rate exceeded" {
+		t.Fatalf("关闭 %d %q", code, reason)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(0.001, 2)
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("突发容量内应放行")
+	}
+	if b.Allow() {
+		t.Fatal("令牌用完后应拒绝")
+	}
+}
+
+func TestChannelPublishRate(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.PublishRate = 0.001
+		s.PublishBurst = 1
+	})
+	a := Dial(t, ts, "channels=room")
+	a.Expect("subscribe")
+	b := Dial(t, ts, "channels=room")
+	b.Expect("subscribe")
+
+	// 每个发布者单独计数：a 超限不影响 b
+	if resp := a.Publish("room", 1); resp.Code != CodeSuccess {
+		t.Fatalf("第一次发布 %d", resp.Code)
+	}
+	if resp := a.Publish("room", 2); resp.Code != CodeRateLimited {
+		t.Fatalf("超限的发布 %d, want %d", resp.Code, CodeRateLimited)
+	}
+	if resp := b.Publish("room", 3); resp.Code != CodeSuccess {
+		t.Fatalf("另一个发布者 %d", resp.Code)
+	}
+
+	// 修改频道配置后已有的发布者按新配置重建令牌桶
+	s.SetChannelPublishRate("room", 0.001, 3)
+	for i := 0; i < 3; i++ {
+		if resp := a.Publish("room", i); resp.Code != CodeSuccess {
+			t.Fatalf("新配置下第 %d 次发布 %d", i, resp.Code)
+		}
+	}
+
+	// 退订后令牌桶随订阅删除
+	client := serverClient(s, a.ID)
+	a.Send(Message{Action: "unsubscribe", Channel: "room"})
+	a.Expect("unsubscribe")
+	client.channelsMu.Lock()
+	n := len(client.publishLimiters)
+	client.channelsMu.Unlock()
+	if n != 0 {
+		t.Fatalf("退订后仍有 %d 个发布令牌桶", n)
+	}
+}
+
+func TestChannelRateSheds(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.ChannelRate = 0.1
+		s.ChannelBurst = 3
+	})
+	s.SetChannelRate("slow", 0.1, 1)
+	s.SetChannelRate("free", 0, 0)
+	c := Dial(t, ts, "channels=room,slow,free")
+	for i := 0; i < 3; i++ {
+		c.Expect("subscribe")
+	}
+
+	// 全局配置：突发 3 条之后的广播被丢弃，同步调用得到空结果
+	var delivered int
+	for i := 0; i < 10; i++ {
+		n, _ := s.BroadcastToChannelSync("room", i)
+		delivered += n
+	}
+	if delivered != 3 {
+		t.Fatalf("room 投递 %d 条, want 3", delivered)
+	}
+	// 频道覆盖：slow 更严格，free 不限制
+	s.BroadcastToChannelSync("slow", "a")
+	s.BroadcastToChannelSync("slow", "b")
+	for i := 0; i < 10; i++ {
+		s.BroadcastToChannelSync("free", i)
+	}
+	// 紧急广播不受限制
+	s.BroadcastUrgent("room", "urgent")
+
+	counts := map[string]int{}
+	for {
+		msg, err := c.NextMessage(200 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		counts[msg.Channel]++
+	}
+	if counts["room"] != 4 || counts["slow"] != 1 || counts["free"] != 10 {
+		t.Fatalf("各频道收到 %v, want room 4（含紧急广播）、slow 1、free 10", counts)
+	}
+	if n := s.Metrics.ChannelRateDrops.Load(); n != 8 {
+		t.Fatalf("ChannelRateDrops = %d, want 8", n)
+	}
+}
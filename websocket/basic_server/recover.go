@@ -0,0 +1,34 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/gorilla/websocket"
+)
+
+// 恢复单个连接上的 panic（钩子或消息处理中的错误）：记录日志和堆栈、计数并调用 OnPanic，
+// 然后以 1011 只关闭这个连接，服务器和其它连接不受影响。必须直接用 defer 调用
+func (s *Server) recoverClient(client *Client, where string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	s.Metrics.ClientPanics.Add(1)
+	s.Logger.Error("连接处理中发生panic，已关闭该连接", "event", "client_panic", "client_id", client.ID, "where", where, "panic", r, "stack", string(debug.Stack()))
+	s.reportPanic(client, r)
+	s.closeClient(client, websocket.CloseInternalServerErr, "internal error")
+}
+
+// 调用 OnPanic；它自己再 panic 时只记录日志
+func (s *Server) reportPanic(client *Client, r interface{}) {
+	if s.OnPanic == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.Logger.Error("OnPanic 发生panic", "event", "client_panic", "client_id", client.ID, "panic", r)
+		}
+	}()
+	s.OnPanic(client, r)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandlerPanicClosesOnlyThatClient(t *testing.T) {
+	type report struct {
+		clientID  string
+		recovered interface{}
+	}
+	reports := make(chan report, 1)
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.OnMessage = func(client *Client, msg *Message) bool {
+			if msg.Action == "boom" {
+				_ = msg.Data.(map[string]interface{}) // 错误的类型断言
+			}
+			return true
+		}
+		s.OnPanic = func(client *Client, recovered interface{}) {
+			reports <- report{client.ID, recovered}
+		}
+	})
+	victim := Dial(t, ts, "")
+	other := Dial(t, ts, "")
+	other.Subscribe("room")
+
+	victim.Send(Message{Action: "boom", Data: "not a map"})
+	if code, reason := victim.ExpectClosed(); code != websocket.CloseInternalServerErr || reason != "internal error" {
+		t.Fatalf("关闭 (%d, %q), want (%d, internal error)", code, reason, websocket.CloseInternalServerErr)
+	}
+	if r := <-reports; r.clientID != victim.ID || r.recovered == nil {
+		t.Fatalf("OnPanic 收到 %+v", r)
+	}
+	if n := s.Metrics.ClientPanics.Load(); n != 1 {
+		t.Fatalf("ClientPanics = %d, want 1", n)
+	}
+
+	// 其它连接和服务器不受影响
+	s.BroadcastToChannel("room", "still here")
+	if msg := other.Expect("message"); msg.Data != "still here" {
+		t.Fatalf("收到 %v", msg.Data)
+	}
+	Dial(t, ts, "").Subscribe("room")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 集群排空标志的默认检查间隔
+const defaultDrainPollInterval = 2 * time.Second
+
+// 监视 Redis 中的集群排空标志：key 的值为 "1"/"true" 时进入排空模式，删除或改为其它值时退出。
+// 所有实例监视同一个 key，只需在 Redis 中设置一次就能让整个集群停止接受新连接，
+// 已有连接不受影响，便于滚动重启。ctx 取消时返回
+func (s *Server) WatchClusterDrain(ctx context.Context, rdb *redis.Client, key string, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultDrainPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		value, err := rdb.Get(ctx, key).Result()
+		switch {
+		case errors.Is(err, redis.Nil):
+			s.setClusterDrain(false)
+		case err != nil:
+			// 读取失败时保持当前状态，避免 Redis 抖动导致误切换
+			if ctx.Err() == nil {
+				s.Logger.Error("读取集群排空标志失败", "event", "cluster_drain_error", "error", err)
+			}
+		default:
+			s.setClusterDrain(isTruthy(value))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 记录集群排空标志。只影响 clusterDraining，标志撤销时不会解除本实例自己设置的排空（如正在关闭）
+func (s *Server) setClusterDrain(on bool) {
+	if s.clusterDraining.Swap(on) == on {
+		return
+	}
+	if on {
+		s.Logger.Info("收到集群排空信号，停止接受新连接", "event", "cluster_drain")
+	} else {
+		s.Logger.Info("集群排空信号已撤销", "event", "cluster_drain_cleared", "draining", s.Draining())
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestClusterDrainDoesNotOverrideLocalDraining(t *testing.T) {
+	s, _ := NewTestServer(t)
+
+	s.setClusterDrain(true)
+	if !s.Draining() {
+		t.Fatal("集群排空后 Draining() 应为 true")
+	}
+	s.setClusterDrain(false)
+	if s.Draining() {
+		t.Fatal("撤销集群排空后 Draining() 应为 false")
+	}
+
+	// 本实例自己进入排空（如 AnnounceShutdown）后，集群标志的变化不能把它恢复
+	s.SetDraining(true)
+	s.setClusterDrain(true)
+	s.setClusterDrain(false)
+	if !s.Draining() {
+		t.Fatal("撤销集群排空不应解除本实例的排空")
+	}
+	s.SetDraining(false)
+	s.setClusterDrain(true)
+	if !s.Draining() {
+		t.Fatal("解除本实例排空后集群排空仍应生效")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 可靠频道上单个客户端的投递进度
+type reliableState struct {
+	delivered uint64 // 最后放入发送队列的序号
+	acked     uint64 // 客户端确认处理到的序号
+	resync    bool   // 已落后，停止投递直到客户端重新订阅
+	notified  bool   // 已把 resync 通知放入发送队列
+}
+
+// 客户端在各可靠频道上的投递进度
+type reliableTracker struct {
+	mu       sync.Mutex
+	channels map[string]*reliableState
+}
+
+// 为频道开启可靠投递：客户端用 ack 确认处理到的 seq；落后超过 ReliableMaxLag 条未确认消息，
+// 或发送缓冲区已满时不会被断开，而是收到一条 resync 通知（code 409），之后该频道的消息暂停投递，
+// 直到客户端重新订阅（可带 since 从历史补齐）
+func (s *Server) EnableReliable(channel string) {
+	s.mu.Lock()
+	s.reliableChannels[channel] = true
+	s.mu.Unlock()
+}
+
+// 关闭频道的可靠投递
+func (s *Server) DisableReliable(channel string) {
+	s.mu.Lock()
+	delete(s.reliableChannels, channel)
+	s.mu.Unlock()
+}
+
+// 投递可靠频道的一帧，返回是否放入了发送队列。失败时客户端转入 resync 状态而不是被断开
+func (s *Server) sendReliable(client *Client, channel string, seq uint64, frame OutboundMessage) bool {
+	t := &client.reliable
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := t.channels[channel]
+	if st == nil {
+		// 第一次投递时以上一条为已确认，订阅前的消息不计入落后
+		st = &reliableState{delivered: seq - 1, acked: seq - 1}
+		t.channels[channel] = st
+	}
+
+	if !st.resync && s.ReliableMaxLag > 0 && st.delivered-st.acked >= s.ReliableMaxLag {
+		st.resync = true
+	}
+	if !st.resync {
+		if s.trySendFrame(client, frame) {
+			st.delivered = seq
+			return true
+		}
+		st.resync = true
+	}
+
+	// 缓冲区满时通知也可能放不进去，下一条消息到来时再试
+	if !st.notified {
+		response := Response{
+			ClientID: client.ID,
+			Action:   "resync",
+			Channel:  channel,
+			Code:     CodeResync,
+			Msg:      "resync required",
+			Data:     map[string]uint64{"delivered": st.delivered, "acked": st.acked},
+			SentAt:   time.Now().UnixMilli(),
+			Seq:      seq,
+		}
+		if data, ok := s.marshalFor(client, response); ok && s.trySend(client, data) {
+			st.notified = true
+			s.Logger.Info("客户端落后，要求重新同步", "event", "resync", "client_id", client.ID, "channel", channel, "seq", seq)
+		}
+	}
+	return false
+}
+
+// 订阅或取消订阅时清除投递进度，重新订阅即完成 resync
+func (c *Client) resetReliable(channel string) {
+	c.reliable.mu.Lock()
+	delete(c.reliable.channels, channel)
+	c.reliable.mu.Unlock()
+}
+
+// 处理客户端确认：seq 为已处理的最后一条消息。确认是累积的，旧的确认被忽略；
+// 超过已投递序号的确认说明客户端与服务器的序号不一致，返回 400
+func (s *Server) handleAck(client *Client, msg *Message) {
+	t := &client.reliable
+	t.mu.Lock()
+	st := t.channels[msg.Channel]
+	var delivered uint64
+	ok := true
+	if st != nil {
+		if msg.Seq > st.delivered {
+			ok = false
+			delivered = st.delivered
+		} else if msg.Seq > st.acked {
+			st.acked = msg.Seq
+		}
+	}
+	t.mu.Unlock()
+
+	if ok {
+		client.ackResumeSeq(msg.Channel, msg.Seq)
+	} else {
+		response := Response{
+			ClientID:  client.ID,
+			RequestID: msg.RequestID,
+			Action:    "ack",
+			Channel:   msg.Channel,
+			Code:      CodeBadRequest,
+			Msg:       "ack beyond delivered seq",
+			Seq:       delivered,
+		}
+		s.sendResponse(client, response)
+	}
+}
+
+// 客户端在可靠频道上的投递进度：已投递和已确认的序号，以及是否处于 resync 状态
+func (s *Server) ReliableProgress(clientID, channel string) (delivered, acked uint64, resync bool, err error) {
+	s.mu.RLock()
+	client := s.byID[clientID]
+	s.mu.RUnlock()
+	if client == nil {
+		return 0, 0, false, ErrClientNotFound
+	}
+
+	client.reliable.mu.Lock()
+	defer client.reliable.mu.Unlock()
+	if st := client.reliable.channels[channel]; st != nil {
+		return st.delivered, st.acked, st.resync, nil
+	}
+	return 0, 0, false, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+// 发一条 ping 并等到 pong，确保之前发出的消息都已处理
+func (c *TestClient) sync() {
+	c.t.Helper()
+	c.Send(Message{Action: "ping"})
+	c.Expect("pong")
+}
+
+func TestReliableInOrderAndAcks(t *testing.T) {
+	s, ts := NewTestServer(t)
+	s.EnableReliable("orders")
+	c := Dial(t, ts, "")
+	c.Subscribe("orders")
+
+	for i := 0; i < 5; i++ {
+		s.BroadcastToChannel("orders", i)
+	}
+	var last uint64
+	for i := 0; i < 5; i++ {
+		msg := c.Expect("message")
+		if last != 0 && msg.Seq != last+1 {
+			t.Fatalf("seq %d 之后收到 %d", last, msg.Seq)
+		}
+		last = msg.Seq
+	}
+
+	c.Send(Message{Action: "ack", Channel: "orders", Seq: last - 2})
+	// 旧的确认被忽略
+	c.Send(Message{Action: "ack", Channel: "orders", Seq: last - 4})
+	c.sync()
+	delivered, acked, resync, err := s.ReliableProgress(c.ID, "orders")
+	if err != nil || delivered != last || acked != last-2 || resync {
+		t.Fatalf("progress = %d %d %v %v, want %d %d false", delivered, acked, resync, err, last, last-2)
+	}
+
+	// 超过已投递序号的确认返回 400
+	c.Send(Message{Action: "ack", Channel: "orders", Seq: last + 10})
+	if resp := c.Expect("ack"); resp.Code != CodeBadRequest || resp.Seq != last {
+		t.Fatalf("越界确认的响应 %+v", resp)
+	}
+}
+
+func TestReliableResyncWhenBehind(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.ReliableMaxLag = 3
+	})
+	s.EnableReliable("orders")
+	c := Dial(t, ts, "")
+	c.Subscribe("orders")
+
+	for i := 0; i < 6; i++ {
+		s.BroadcastToChannel("orders", i)
+	}
+	for i := 0; i < 3; i++ {
+		c.Expect("message")
+	}
+	resp, err := c.NextMessage(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Action != "resync" || resp.Code != CodeResync {
+		t.Fatalf("落后超过 ReliableMaxLag 时应收到 resync，实际 %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	if lag := data["delivered"].(float64) - data["acked"].(float64); lag != 3 {
+		t.Fatalf("resync data = %v, 落后应为 3", data)
+	}
+	// resync 之后的消息暂停投递，也不再重复通知
+	c.Send(Message{Action: "ping"})
+	if resp, err := c.NextMessage(testTimeout); err != nil || resp.Action != "pong" {
+		t.Fatalf("resync 之后应只收到 pong，实际 %+v %v", resp, err)
+	}
+	if _, _, resync, _ := s.ReliableProgress(c.ID, "orders"); !resync {
+		t.Fatal("应处于 resync 状态")
+	}
+
+	// 重新订阅后恢复投递
+	c.Subscribe("orders")
+	s.BroadcastToChannel("orders", "again")
+	if msg := c.Expect("message"); msg.Data != "again" {
+		t.Fatalf("收到 %v", msg.Data)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+)
+
+// 为频道开启保留消息：频道保留最近一条广播，新订阅者在订阅确认之后立即收到它
+func (s *Server) EnableRetained(channel string) {
+	sh := s.subscriptions.shard(channel)
+	sh.mu.Lock()
+	sh.retain[channel] = true
+	sh.mu.Unlock()
+}
+
+// 关闭频道的保留消息并清除已保留的内容
+func (s *Server) DisableRetained(channel string) {
+	sh := s.subscriptions.shard(channel)
+	sh.mu.Lock()
+	delete(sh.retain, channel)
+	delete(sh.retained, channel)
+	sh.mu.Unlock()
+}
+
+// 直接设置频道的保留消息（不广播），并为频道开启保留；data 为 nil 时清除
+func (s *Server) SetRetained(channel string, data interface{}) {
+	sh := s.subscriptions.shard(channel)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.retain[channel] = true
+	if data == nil {
+		delete(sh.retained, channel)
+		return
+	}
+	sh.retained[channel] = Response{
+		Action:  "message",
+		Channel: channel,
+		Code:    CodeSuccess,
+		Msg:     "success",
+		Data:    data,
+		SentAt:  time.Now().UnixMilli(),
+	}
+}
+
+// 把保留消息发给新订阅者（调用方需持有该频道分片的写锁）
+func (s *Server) replayRetained(client *Client, channel string) {
+	sh := s.subscriptions.shard(channel)
+	response, ok := sh.retained[channel]
+	if !ok {
+		return
+	}
+	response.ClientID = client.ID
+	response.Retained = true
+	data, ok := s.marshalFor(client, response)
+	if !ok {
+		return
+	}
+	if !s.trySend(client, data) {
+		s.Logger.Warn("缓冲区已满，丢弃保留消息", "event", "retained_dropped", "client_id", client.ID, "channel", channel)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBroadcastToSample(t *testing.T) {
+	s, ts := NewTestServer(t)
+	var clients []*TestClient
+	for i := 0; i < 10; i++ {
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		clients = append(clients, c)
+	}
+
+	if n := s.BroadcastToSample("room", 0.3, "canary"); n != 3 {
+		t.Fatalf("BroadcastToSample(0.3) = %d, want 3", n)
+	}
+	received := 0
+	for _, c := range clients {
+		if resp, err := c.NextMessage(100 * time.Millisecond); err == nil && resp.Data == "canary" {
+			received++
+		}
+	}
+	if received != 3 {
+		t.Fatalf("%d 个客户端收到, want 3", received)
+	}
+
+	if n := s.BroadcastToSample("room", 0, "none"); n != 0 {
+		t.Fatalf("fraction 0: %d", n)
+	}
+	if n := s.BroadcastToSample("room", 2, "all"); n != 10 {
+		t.Fatalf("fraction > 1: %d, want 10", n)
+	}
+}
+
+func TestSampleSeedIsReproducible(t *testing.T) {
+	sample := func() []string {
+		s := NewServer(DefaultServerConfig())
+		s.SampleSeed = 42
+		var clients []*Client
+		// 乱序传入，固定种子时先按ID排序
+		for _, i := range []int{7, 2, 9, 0, 4, 1, 8, 3, 6, 5} {
+			clients = append(clients, &Client{ID: fmt.Sprintf("c%d", i)})
+		}
+		var ids []string
+		for _, client := range s.sampleClients(clients, 0.4) {
+			ids = append(ids, client.ID)
+		}
+		return ids
+	}
+	first, second := sample(), sample()
+	if len(first) != 4 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("相同种子的抽样不同: %v vs %v", first, second)
+	}
+}
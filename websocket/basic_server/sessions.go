@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 断开后保留的会话：恢复时重新订阅这些频道，并从历史中补发每个频道在断线期间错过的消息
+type session struct {
+	userID   string
+	channels map[string]uint64 // 频道 -> 已确认（未确认过则为订阅时）的最后一个序号
+	timer    *time.Timer       // 到期后回收
+}
+
+// 以令牌为键的短期会话存储
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// 生成新的会话令牌
+func newSessionToken() string {
+	return uuid.New().String()
+}
+
+// 客户端断开时保存会话，SessionTTL 后仍未被恢复则回收（调用方需持有 s.mu）
+func (s *Server) saveSession(client *Client) {
+	if s.SessionTTL <= 0 || client.sessionToken == "" {
+		return
+	}
+	token := client.sessionToken
+	sess := &session{userID: client.UserID, channels: client.resumePoints()}
+
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	sess.timer = time.AfterFunc(s.SessionTTL, func() {
+		s.sessions.mu.Lock()
+		if s.sessions.sessions[token] == sess {
+			delete(s.sessions.sessions, token)
+		}
+		s.sessions.mu.Unlock()
+	})
+	s.sessions.sessions[token] = sess
+}
+
+// 取出并删除会话，一个会话只能恢复一次。令牌不存在、已过期或属于其他用户时返回 nil
+func (s *Server) takeSession(token, userID string) *session {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+
+	sess := s.sessions.sessions[token]
+	if sess == nil || sess.userID != userID {
+		return nil
+	}
+	sess.timer.Stop()
+	delete(s.sessions.sessions, token)
+	return sess
+}
+
+// 恢复会话：重新订阅之前的频道，先发送一条 resume 汇总，再按频道补发序号之后的历史消息。
+// 恢复的频道在客户端重新确认之前沿用旧的确认序号，再次断线也不会丢掉未确认的消息。
+// 由事件循环在注册客户端之后调用，此时客户端已在 clients 中且不会被并发注销
+func (s *Server) restoreSession(client *Client, sess *session) {
+	var crossings []thresholdCrossing
+	defer func() { s.fireThresholds(crossings) }()
+
+	// 权限可能在断线期间变化，恢复的频道同样要经过订阅授权
+	channels := make([]string, 0, len(sess.channels))
+	for channel := range sess.channels {
+		if s.canSubscribe(client, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.clients[client] {
+		return
+	}
+	unlock := s.lockSubscriptions(channels)
+	defer unlock()
+
+	restored := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		added, err := s.addSubscription(client, channel, subscribeOptions{})
+		if err != nil {
+			s.Logger.Debug("恢复订阅失败", "event", "resume_rejected", "client_id", client.ID, "channel", channel, "error", err)
+			continue
+		}
+		crossings = append(crossings, added...)
+		client.setResumeSeq(channel, sess.channels[channel])
+		restored = append(restored, channel)
+	}
+
+	response := Response{
+		ClientID: client.ID,
+		Action:   "resume",
+		Code:     CodeSuccess,
+		Msg:      "success",
+		Data:     map[string][]string{"channels": restored},
+	}
+	s.sendResponse(client, response)
+
+	for _, channel := range restored {
+		if !isPattern(channel) {
+			s.replayAfter(client, channel, sess.channels[channel])
+		}
+	}
+	s.Logger.Info("会话已恢复", "event", "resume", "client_id", client.ID, "channels", len(restored))
+}
+
+// 补发频道中序号大于 seq 的历史消息（调用方需持有该频道分片的写锁）
+func (s *Server) replayAfter(client *Client, channel string, seq uint64) {
+	for _, entry := range s.historyAfter(channel, seq) {
+		entry.ClientID = client.ID
+		data, ok := s.marshalFor(client, entry)
+		if !ok {
+			continue
+		}
+		if !s.trySend(client, data) {
+			s.Logger.Warn("缓冲区已满，历史回放中断", "event", "history_replay_aborted", "client_id", client.ID, "channel", channel)
+			return
+		}
+	}
+}
+
+// 记录频道的恢复起点，恢复时补发它之后的消息
+func (c *Client) setResumeSeq(channel string, seq uint64) {
+	c.channelsMu.Lock()
+	c.resumeSeqs[channel] = seq
+	c.channelsMu.Unlock()
+}
+
+// 客户端确认处理到 seq，只前进不后退
+func (c *Client) ackResumeSeq(channel string, seq uint64) {
+	c.channelsMu.Lock()
+	if c.Channels[channel] && seq > c.resumeSeqs[channel] {
+		c.resumeSeqs[channel] = seq
+	}
+	c.channelsMu.Unlock()
+}
+
+// 当前订阅的频道及其恢复起点（副本）
+func (c *Client) resumePoints() map[string]uint64 {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+
+	points := make(map[string]uint64, len(c.Channels))
+	for channel := range c.Channels {
+		points[channel] = c.resumeSeqs[channel]
+	}
+	return points
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AnnounceShutdown 检查剩余连接数的间隔
+const announcePollInterval = 100 * time.Millisecond
+
+// 下线预告的内容
+type ReconnectHint struct {
+	Reason    string `json:"reason,omitempty"`
+	BackoffMs int64  `json:"backoffMs"`     // 建议在这段时间内断开并重新连接（毫秒），在 grace 内随机分布，避免同时重连
+	URL       string `json:"url,omitempty"` // 可选的备用地址
+}
+
+// 预告下线（如滚动部署）：进入排空模式拒绝新连接，向所有客户端发送 action 为 "reconnect" 的提示，
+// 在 grace 内等待客户端自行断开，到期后以 CloseGoingAway 关闭剩余连接。
+// 阻塞到所有连接断开或 grace 到期；不退出事件循环，之后仍应调用 Shutdown
+func (s *Server) AnnounceShutdown(reason, altEndpoint string, grace time.Duration) {
+	s.SetDraining(true)
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+
+	// 持有读锁期间客户端不会被注销，Send 不会被关闭
+	s.mu.RLock()
+	for client := range s.clients {
+		hint := ReconnectHint{Reason: reason, URL: altEndpoint}
+		if ms := grace.Milliseconds(); ms > 0 {
+			hint.BackoffMs = rand.Int63n(ms)
+		}
+		s.sendResponse(client, Response{
+			ClientID: client.ID,
+			Action:   "reconnect",
+			Code:     CodeSuccess,
+			Msg:      "success",
+			Data:     hint,
+			SentAt:   time.Now().UnixMilli(),
+		})
+	}
+	count := len(s.clients)
+	s.mu.RUnlock()
+	s.Logger.Info("预告下线", "event", "announce_shutdown", "connections", count, "grace", grace, "url", altEndpoint)
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	ticker := time.NewTicker(announcePollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.RLock()
+		remaining := make([]*Client, 0, len(s.clients))
+		for client := range s.clients {
+			remaining = append(remaining, client)
+		}
+		s.mu.RUnlock()
+		if len(remaining) == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			// 提示在 grace 开始时已入队；关闭帧直接写出，只有积压了整个 grace 的客户端才会来不及收到提示
+			for _, client := range remaining {
+				s.closeClient(client, websocket.CloseGoingAway, reason)
+			}
+			s.Logger.Info("预告期结束，关闭剩余连接", "event", "announce_shutdown_timeout", "connections", len(remaining))
+			return
+		}
+	}
+}
+
+// 优雅关闭：停止接受新连接，让每个客户端发完已排队的消息后收到 CloseNormalClosure 关闭帧，
+// 然后退出事件循环。ctx 到期时强制关闭剩余连接并返回 ctx.Err()。只能调用一次
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+	if s.Backplane != nil {
+		s.Backplane.Close()
+	}
+
+	// 由事件循环注销所有客户端，writePump 排空发送缓冲区后写出关闭帧
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, s.ShutdownReason)
+	select {
+	case s.shutdown <- message:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.writers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		s.cancelCtx()
+		s.Logger.Info("服务器已关闭", "event", "shutdown")
+		return nil
+	case <-ctx.Done():
+		s.cancelCtx()
+		s.mu.RLock()
+		for _, client := range s.closed {
+			client.closeConn()
+		}
+		s.mu.RUnlock()
+		s.Logger.Warn("关闭超时，强制断开剩余连接", "event", "shutdown_timeout")
+		return ctx.Err()
+	}
+}
+
+// 事件循环中执行关闭：注销所有客户端，然后通知 Run 退出
+func (s *Server) shutdownClients(message []byte) {
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	for _, client := range clients {
+		client.closeMessage = message
+		s.removeClient(client)
+	}
+
+	s.mu.Lock()
+	s.closed = clients
+	s.mu.Unlock()
+	close(s.done)
+	s.Logger.Info("正在关闭连接", "event", "shutdown", "connections", len(clients))
+}
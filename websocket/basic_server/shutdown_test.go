@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownSendsNormalClose(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.ShutdownReason = "maintenance"
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("room")
+	s.BroadcastToChannel("room", "last")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("Shutdown 返回后事件循环应已退出")
+	}
+
+	// 关闭前排队的消息先送达，然后是正常关闭帧
+	if msg := c.Expect("message"); msg.Data != "last" {
+		t.Fatalf("收到 %v", msg.Data)
+	}
+	code, reason := c.ExpectClosed()
+	if code != websocket.CloseNormalClosure || reason != "maintenance" {
+		t.Fatalf("关闭 %d %q, want 1000 maintenance", code, reason)
+	}
+
+	_, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("关闭后的新连接应返回 503，err=%v", err)
+	}
+}
+
+func TestUpgradeRefusedWhenNotRunning(t *testing.T) {
+	// 没有调用 Run 的服务器：拒绝升级而不是让连接挂在注册上
+	s := NewServer(DefaultServerConfig())
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	start := time.Now()
+	_, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("事件循环没有运行时应返回 503: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("拒绝用了 %v", elapsed)
+	}
+	if n := connectionCount(s); n != 0 {
+		t.Fatalf("连接数 %d, want 0", n)
+	}
+}
+
+func TestUpgradeRefusedAfterShutdown(t *testing.T) {
+	s, ts := NewTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("关闭后应返回 503: %v", err)
+	}
+}
+
+func TestAnnounceShutdown(t *testing.T) {
+	s, ts := NewTestServer(t)
+	polite := Dial(t, ts, "")
+	stubborn := Dial(t, ts, "")
+
+	const grace = 300 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.AnnounceShutdown("deploy", "wss://b.example.com/ws", grace)
+	}()
+
+	// 两个客户端都先收到重连提示，建议的退避在 grace 之内
+	for _, c := range []*TestClient{polite, stubborn} {
+		hint := c.Expect("reconnect")
+		data, _ := hint.Data.(map[string]interface{})
+		backoff, _ := data["backoffMs"].(float64)
+		if data["reason"] != "deploy" || data["url"] != "wss://b.example.com/ws" || backoff < 0 || backoff >= float64(grace.Milliseconds()) {
+			t.Fatalf("重连提示 %v", data)
+		}
+	}
+	// 排空期间拒绝新连接
+	if _, resp, err := dialRaw(ts, "", nil, websocket.DefaultDialer); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("预告下线后应拒绝新连接: %v", err)
+	}
+
+	// 自行断开的客户端不再收到关闭帧；没有断开的在 grace 到期后收到 CloseGoingAway
+	polite.Conn.Close()
+	if code, reason := stubborn.ExpectClosed(); code != websocket.CloseGoingAway || reason != "deploy" {
+		t.Fatalf("关闭 (%d, %q), want (%d, deploy)", code, reason, websocket.CloseGoingAway)
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("AnnounceShutdown 没有返回")
+	}
+}
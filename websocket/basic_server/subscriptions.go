@@ -0,0 +1,207 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// 订阅表的分片数
+const subscriptionShards = 32
+
+// 订阅表的一个分片，按频道名哈希分配。
+// 锁顺序：s.mu → 分片锁（多个分片按下标升序）→ Client.channelsMu
+type subscriptionShard struct {
+	mu      sync.RWMutex
+	subs    map[string]map[*Client]bool // 频道 -> 订阅者
+	pending map[string][]BroadcastMsg   // 无订阅者期间暂存的消息（EmptyChannelPersist）
+
+	retain   map[string]bool     // 开启了保留消息的频道
+	retained map[string]Response // 频道的保留消息
+}
+
+// 分片的订阅表，不同频道的订阅、取消订阅和广播互不阻塞
+type subscriptionRegistry struct {
+	shards [subscriptionShards]subscriptionShard
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	r := &subscriptionRegistry{}
+	for i := range r.shards {
+		r.shards[i].subs = make(map[string]map[*Client]bool)
+		r.shards[i].pending = make(map[string][]BroadcastMsg)
+		r.shards[i].retain = make(map[string]bool)
+		r.shards[i].retained = make(map[string]Response)
+	}
+	return r
+}
+
+func shardIndex(channel string) int {
+	h := fnv.New32a()
+	h.Write([]byte(channel))
+	return int(h.Sum32() % subscriptionShards)
+}
+
+// 频道所在的分片
+func (r *subscriptionRegistry) shard(channel string) *subscriptionShard {
+	return &r.shards[shardIndex(channel)]
+}
+
+// 锁住一组频道所在的全部分片（按下标升序，避免死锁），返回解锁函数
+func (r *subscriptionRegistry) lockChannels(channels []string) func() {
+	seen := make(map[int]bool, len(channels))
+	indexes := make([]int, 0, len(channels))
+	for _, channel := range channels {
+		if i := shardIndex(channel); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		r.shards[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			r.shards[i].mu.Unlock()
+		}
+	}
+}
+
+// 复制频道的订阅者，调用方在锁外投递
+func (r *subscriptionRegistry) snapshot(channel string) []*Client {
+	sh := r.shard(channel)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(sh.subs[channel]))
+	for client := range sh.subs[channel] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// 在分片读锁内依次访问频道的订阅者，fn 返回 false 时停止
+func (r *subscriptionRegistry) forEach(channel string, fn func(*Client) bool) {
+	sh := r.shard(channel)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	for client := range sh.subs[channel] {
+		if !fn(client) {
+			return
+		}
+	}
+}
+
+// 记录一条广播（历史、保留消息）并复制频道的订阅者。
+// 记录和快照在同一把分片锁内完成，与订阅（持有分片写锁时回放历史和保留消息）互斥：
+// 新订阅者要么从回放中收到这条消息，要么在实时投递中收到，不重不漏。Data 为 nil 的广播清除保留消息
+func (s *Server) recordAndSnapshot(channel string, response Response) []*Client {
+	sh := s.subscriptions.shard(channel)
+
+	// 未开启保留的频道只需要读锁
+	sh.mu.RLock()
+	retain := sh.retain[channel]
+	if retain {
+		sh.mu.RUnlock()
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		if response.Data == nil {
+			delete(sh.retained, channel)
+		} else {
+			sh.retained[channel] = response
+		}
+	} else {
+		defer sh.mu.RUnlock()
+	}
+	s.recordHistory(response)
+
+	clients := make([]*Client, 0, len(sh.subs[channel]))
+	for client := range sh.subs[channel] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// 频道的订阅数
+func (r *subscriptionRegistry) count(channel string) int {
+	sh := r.shard(channel)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return len(sh.subs[channel])
+}
+
+// 所有有订阅者的频道及其订阅数
+func (r *subscriptionRegistry) counts() map[string]int {
+	counts := make(map[string]int)
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.RLock()
+		for channel, subs := range sh.subs {
+			if len(subs) > 0 {
+				counts[channel] = len(subs)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return counts
+}
+
+// 客户端订阅的频道（副本）
+func (c *Client) channelList() []string {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+
+	channels := make([]string, 0, len(c.Channels))
+	for channel := range c.Channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// 把频道加入客户端的订阅列表；已订阅的频道不重复计数，未订阅且已达到 max 时返回 false（max 为 0 表示不限制）。
+// 检查和插入在同一把锁内完成
+func (c *Client) addChannel(channel string, max int) bool {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+
+	if max > 0 && !c.Channels[channel] && len(c.Channels) >= max {
+		return false
+	}
+	c.Channels[channel] = true
+	return true
+}
+
+// 加入通配订阅，同时检查频道总数和通配订阅数的上限（0 表示不限制），已订阅的模式直接返回 nil
+func (c *Client) addPattern(pattern string, maxChannels, maxPatterns int) error {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+
+	if c.Channels[pattern] {
+		return nil
+	}
+	if maxChannels > 0 && len(c.Channels) >= maxChannels {
+		return errTooManyChannels
+	}
+	if maxPatterns > 0 {
+		n := 0
+		for channel := range c.Channels {
+			if isPattern(channel) {
+				n++
+			}
+		}
+		if n >= maxPatterns {
+			return errTooManyPatterns
+		}
+	}
+	c.Channels[pattern] = true
+	return nil
+}
+
+// 客户端是否订阅了频道
+func (c *Client) subscribed(channel string) bool {
+	c.channelsMu.Lock()
+	defer c.channelsMu.Unlock()
+	return c.Channels[channel]
+}
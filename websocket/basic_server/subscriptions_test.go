@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChannelMembership(t *testing.T) {
+	s, ts := NewTestServer(t)
+	a := Dial(t, ts, "")
+	b := Dial(t, ts, "")
+	c := Dial(t, ts, "")
+	a.Subscribe("general")
+	b.Subscribe("general")
+	c.Subscribe("random")
+	// 模式订阅不算频道成员
+	c.Subscribe("news.*")
+
+	want := []string{a.ID, b.ID}
+	sort.Strings(want)
+	if got := s.ChannelSubscribers("general"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ChannelSubscribers = %v, want %v", got, want)
+	}
+	if n := s.ChannelCount("general"); n != 2 {
+		t.Fatalf("ChannelCount = %d, want 2", n)
+	}
+	if got := s.Channels(); !reflect.DeepEqual(got, []string{"general", "random"}) {
+		t.Fatalf("Channels = %v", got)
+	}
+
+	b.Send(Message{Action: "unsubscribe", Channel: "general"})
+	b.Expect("unsubscribe")
+	c.Conn.Close()
+	waitFor(t, "c removed", func() bool { return serverClient(s, c.ID) == nil })
+	if got := s.ChannelSubscribers("general"); !reflect.DeepEqual(got, []string{a.ID}) {
+		t.Fatalf("取消订阅后 ChannelSubscribers = %v", got)
+	}
+	if got := s.Channels(); !reflect.DeepEqual(got, []string{"general"}) {
+		t.Fatalf("断开后 Channels = %v", got)
+	}
+	if n := s.ChannelCount("random"); n != 0 {
+		t.Fatalf("ChannelCount(random) = %d", n)
+	}
+}
+
+// 在 500 个频道上各放 10 个订阅者（共 5000 个客户端）
+func fillRegistry(r *subscriptionRegistry) []string {
+	channels := make([]string, 500)
+	for i := range channels {
+		channels[i] = fmt.Sprintf("ch%d", i)
+		subs := make(map[*Client]bool)
+		for j := 0; j < 10; j++ {
+			subs[&Client{ID: fmt.Sprintf("c%d-%d", i, j)}] = true
+		}
+		r.shard(channels[i]).subs[channels[i]] = subs
+	}
+	return channels
+}
+
+func TestUnsubscribeManyListsLeftChannels(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+	c.Send(Message{Action: "subscribe", Channels: []string{"a", "b"}})
+	c.Expect("subscribe")
+
+	// 没有订阅过的 x 不出现在确认中
+	c.Send(Message{Action: "unsubscribe", Channels: []string{"a", "x", "b"}, RequestID: "u1"})
+	ack := c.Expect("unsubscribe")
+	if ack.RequestID != "u1" || !reflect.DeepEqual(ack.Data, map[string]interface{}{"channels": []interface{}{"a", "b"}}) {
+		t.Fatalf("退订确认 %+v", ack)
+	}
+	c.Send(Message{Action: "unsubscribe", Channels: []string{"a"}})
+	if ack := c.Expect("unsubscribe"); !reflect.DeepEqual(ack.Data, map[string]interface{}{"channels": []interface{}{}}) {
+		t.Fatalf("重复退订的确认 %+v", ack)
+	}
+}
+
+func TestSubscriptionRegistryShards(t *testing.T) {
+	r := newSubscriptionRegistry()
+	channels := fillRegistry(r)
+
+	counts := r.counts()
+	if len(counts) != 500 {
+		t.Fatalf("counts 有 %d 个频道, want 500", len(counts))
+	}
+	for _, channel := range channels {
+		if counts[channel] != 10 || r.count(channel) != 10 || len(r.snapshot(channel)) != 10 {
+			t.Fatalf("频道 %s 的订阅数不对", channel)
+		}
+	}
+
+	// 重复的频道和落在同一分片的频道只锁一次，解锁后分片可以再次加锁
+	unlock := r.lockChannels(append(channels, channels...))
+	unlock()
+	unlock = r.lockChannels(channels[:3])
+	unlock()
+}
+
+// 5000 个客户端分布在 500 个频道上，并发地取订阅者快照（约 1/10 的操作是订阅变更）。
+// single-lock 在每次操作外再加一把全局锁，模拟分片之前所有频道共用 s.mu 的情况
+func BenchmarkSubscriptionRegistry(b *testing.B) {
+	for _, sharded := range []bool{false, true} {
+		name := "single-lock"
+		if sharded {
+			name = "sharded"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := newSubscriptionRegistry()
+			channels := fillRegistry(r)
+			var global sync.RWMutex
+			var next atomic.Int64
+
+			b.RunParallel(func(pb *testing.PB) {
+				extra := &Client{ID: "extra"}
+				for pb.Next() {
+					n := next.Add(1)
+					channel := channels[n%int64(len(channels))]
+					sh := r.shard(channel)
+					if n%10 == 0 {
+						if !sharded {
+							global.Lock()
+						}
+						sh.mu.Lock()
+						if sh.subs[channel][extra] {
+							delete(sh.subs[channel], extra)
+						} else {
+							sh.subs[channel][extra] = true
+						}
+						sh.mu.Unlock()
+						if !sharded {
+							global.Unlock()
+						}
+						continue
+					}
+					if !sharded {
+						global.RLock()
+					}
+					r.snapshot(channel)
+					if !sharded {
+						global.RUnlock()
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMaxChannelsPerClient(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxChannelsPerClient = 3
+	})
+	c := Dial(t, ts, "")
+	c.Subscribe("a")
+	c.Subscribe("b")
+	// 重复订阅不占用名额
+	c.Subscribe("a")
+
+	// 一次订阅多个频道：上限以内的生效，其余拒绝，确认中只列出成功的
+	c.Send(Message{Action: "subscribe", Channels: []string{"c", "d", "e"}})
+	ack := c.Expect("subscribe")
+	if ack.Code != CodeSuccess || ack.Msg != "partially rejected: "+errTooManyChannels.Error() ||
+		!reflect.DeepEqual(ack.Data, map[string]interface{}{"channels": []interface{}{"c"}}) {
+		t.Fatalf("批量订阅确认 %+v", ack)
+	}
+
+	// 达到上限后单个订阅返回 403，订阅不生效
+	c.Send(Message{Action: "subscribe", Channel: "f"})
+	if ack := c.Expect("subscribe"); ack.Code != CodeForbidden || ack.Msg != errTooManyChannels.Error() {
+		t.Fatalf("超过上限的订阅: %d %s", ack.Code, ack.Msg)
+	}
+	if got := s.Channels(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("订阅的频道 %v, want [a b c]", got)
+	}
+
+	// 退订后名额释放
+	c.Send(Message{Action: "unsubscribe", Channel: "a"})
+	c.Expect("unsubscribe")
+	c.Subscribe("f")
+}
+
+func TestAutoSubscribeFromQuery(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.MaxChannelsPerClient = 2
+		s.CanSubscribe = func(client *Client, channel string) bool { return channel != "secret" }
+	})
+	c := Dial(t, ts, "channels=a,secret,b,c")
+
+	// 紧跟连接确认，每个频道按顺序一条订阅确认；CanSubscribe 和订阅数上限同样生效
+	want := []struct {
+		channel string
+		code    int
+	}{{"a", CodeSuccess}, {"secret", CodeForbidden}, {"b", CodeSuccess}, {"c", CodeForbidden}}
+	for _, w := range want {
+		ack := c.Expect("subscribe")
+		if ack.Channel != w.channel || ack.Code != w.code {
+			t.Fatalf("订阅确认 %s %d, want %s %d", ack.Channel, ack.Code, w.channel, w.code)
+		}
+	}
+
+	// 不需要再发订阅请求，立即收到这些频道的广播
+	s.BroadcastToChannel("a", "to a")
+	s.BroadcastToChannel("secret", "to secret")
+	s.BroadcastToChannel("b", "to b")
+	for _, data := range []string{"to a", "to b"} {
+		if msg := c.Expect("message"); msg.Data != data {
+			t.Fatalf("收到 %v, want %s", msg.Data, data)
+		}
+	}
+	c.ExpectNone(100 * time.Millisecond)
+}
+
+func TestSubscribeSnapshot(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 1024
+		s.SnapshotProvider = func(channel string, client *Client) (interface{}, bool) {
+			if channel != "doc" {
+				return nil, false
+			}
+			return "state", true
+		}
+	})
+
+	// 订阅期间频道上持续有广播，快照仍然紧跟订阅确认，早于任何更新
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.BroadcastToChannel("doc", "update")
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		c := Dial(t, ts, "")
+		c.Send(Message{Action: "subscribe", Channel: "doc"})
+		ack := c.Expect("subscribe")
+		if ack.Code != CodeSuccess {
+			t.Fatalf("订阅失败: %d %s", ack.Code, ack.Msg)
+		}
+		msg, err := c.NextMessage(testTimeout)
+		if err != nil || msg.Action != "snapshot" || msg.Channel != "doc" || msg.Data != "state" {
+			t.Fatalf("订阅确认之后收到 %+v, %v, want doc 的快照", msg, err)
+		}
+		c.Conn.Close()
+	}
+
+	// 没有快照的频道只有订阅确认
+	c := Dial(t, ts, "")
+	c.Subscribe("other")
+	c.ExpectNone(100 * time.Millisecond)
+}
+
+func TestForEachSubscriber(t *testing.T) {
+	s, ts := NewTestServer(t)
+	ids := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		c := Dial(t, ts, "")
+		c.Subscribe("room")
+		ids[c.ID] = true
+	}
+	Dial(t, ts, "").Subscribe("other")
+
+	seen := map[string]bool{}
+	s.ForEachSubscriber("room", func(client *Client) bool {
+		seen[client.ID] = true
+		return true
+	})
+	if !reflect.DeepEqual(seen, ids) {
+		t.Fatalf("遍历到 %v, want %v", seen, ids)
+	}
+
+	// 返回 false 时停止
+	visited := 0
+	s.ForEachSubscriber("room", func(*Client) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Fatalf("返回 false 后继续遍历: visited = %d", visited)
+	}
+
+	s.ForEachSubscriber("nobody", func(*Client) bool {
+		t.Fatal("没有订阅者的频道不应调用 fn")
+		return true
+	})
+}
+
+func TestBatchAcks(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.BatchAcks = true
+		s.MaxChannelsPerClient = 3
+		s.CanSubscribe = func(client *Client, channel string) bool { return channel != "secret" }
+	})
+	c := Dial(t, ts, "")
+
+	c.Send(Message{Action: "subscribe", Channels: []string{"a", "secret", "b", "c", "d"}, RequestID: "r1"})
+	ack := c.Expect("subscribe")
+	want := []ChannelResult{
+		{Channel: "a", Code: CodeSuccess, Msg: "success"},
+		{Channel: "secret", Code: CodeForbidden, Msg: "subscribe not allowed"},
+		{Channel: "b", Code: CodeSuccess, Msg: "success"},
+		{Channel: "c", Code: CodeSuccess, Msg: "success"},
+		{Channel: "d", Code: CodeForbidden, Msg: errTooManyChannels.Error()},
+	}
+	if ack.RequestID != "r1" || !reflect.DeepEqual(ack.Results, want) {
+		t.Fatalf("批量确认 %+v, want results %+v", ack, want)
+	}
+	// 所有频道只有这一条确认
+	c.ExpectNone(100 * time.Millisecond)
+	if got := s.Channels(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("订阅的频道 %v", got)
+	}
+
+	// ?channels= 自动订阅同样合并为一条，没有通过校验的频道排在最前面
+	auto := Dial(t, ts, "channels=x,bad!,y")
+	ack = auto.Expect("subscribe")
+	if len(ack.Results) != 3 || ack.Results[0].Channel != "bad!" || ack.Results[0].Code != CodeInvalidChannel ||
+		ack.Results[1].Channel != "x" || ack.Results[2].Channel != "y" {
+		t.Fatalf("自动订阅的批量确认 %+v", ack.Results)
+	}
+	auto.ExpectNone(100 * time.Millisecond)
+}
+
+func TestSubscribeAckPrecedesChannelMessages(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SendBufferSize = 4096
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.BroadcastToChannel("hot", "tick")
+			}
+		}
+	}()
+
+	// 广播不停地进行，订阅确认仍然总是先于该频道的第一条消息
+	for i := 0; i < 30; i++ {
+		c := Dial(t, ts, "")
+		c.Send(Message{Action: "subscribe", Channel: "hot"})
+		first, err := c.NextMessage(testTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Action != "subscribe" || first.Code != CodeSuccess {
+			t.Fatalf("第 %d 个客户端先收到 %+v", i, first)
+		}
+		c.Conn.Close()
+	}
+}
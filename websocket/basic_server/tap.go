@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// 每个监听者的消息缓冲，满了之后新消息被丢弃
+const tapBufferSize = 256
+
+// 频道监听者：频道 -> 监听通道。与订阅表分开维护，不计入订阅数和在线状态
+type tapHub struct {
+	mu   sync.Mutex
+	taps map[string]map[chan Response]bool
+}
+
+// 监听频道：返回的通道收到发往该频道的每一条广播（含抽样广播的完整内容），
+// 监听者不是订阅者，不出现在 ChannelSubscribers 中，也不受 Except 影响。
+// 只匹配精确的频道名。发送是非阻塞的，处理太慢时消息被丢弃（计入 Metrics.TapDrops）。
+// 不再需要时调用返回的 cancel，它会关闭通道，重复调用无副作用
+func (s *Server) Tap(channel string) (<-chan Response, func()) {
+	ch := make(chan Response, tapBufferSize)
+	s.taps.mu.Lock()
+	if s.taps.taps[channel] == nil {
+		s.taps.taps[channel] = make(map[chan Response]bool)
+	}
+	s.taps.taps[channel][ch] = true
+	s.taps.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.taps.mu.Lock()
+			defer s.taps.mu.Unlock()
+			delete(s.taps.taps[channel], ch)
+			if len(s.taps.taps[channel]) == 0 {
+				delete(s.taps.taps, channel)
+			}
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// 把广播交给频道的监听者。可能在持有 s.mu 时调用，只做非阻塞发送
+func (s *Server) notifyTaps(resp Response) {
+	s.taps.mu.Lock()
+	defer s.taps.mu.Unlock()
+	for ch := range s.taps.taps[resp.Channel] {
+		select {
+		case ch <- resp:
+		default:
+			s.Metrics.TapDrops.Add(1)
+		}
+	}
+}
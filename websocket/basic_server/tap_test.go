@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTapObservesWithoutSubscribing(t *testing.T) {
+	s, ts := NewTestServer(t)
+	messages, cancel := s.Tap("room")
+	sender := Dial(t, ts, "channels=room")
+	sender.Expect("subscribe")
+
+	// 监听者不是订阅者，发布者自己的消息（排除发送者）也能看到
+	if got := s.ChannelSubscribers("room"); len(got) != 1 || got[0] != sender.ID {
+		t.Fatalf("room 的订阅者 %v", got)
+	}
+	sender.Publish("room", "hello")
+	select {
+	case resp := <-messages:
+		if resp.Channel != "room" || resp.Data != "hello" {
+			t.Fatalf("监听到 %+v", resp)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("监听者没有收到消息")
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-messages; ok {
+		t.Fatal("cancel 之后通道应关闭")
+	}
+	s.BroadcastToChannelSync("room", "after cancel")
+}
+
+func TestSlowTapDrops(t *testing.T) {
+	s, _ := NewTestServer(t)
+	_, cancel := s.Tap("room")
+	defer cancel()
+
+	// 没有人读取的监听者缓冲满后丢弃，不阻塞广播
+	for i := 0; i < tapBufferSize+5; i++ {
+		s.BroadcastToChannelSync("room", i)
+	}
+	if n := s.Metrics.TapDrops.Load(); n != 5 {
+		t.Fatalf("TapDrops = %d, want 5", n)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 测试中等待一条消息的默认时间
+const testTimeout = 2 * time.Second
+
+// 等待事件循环处理完一批操作（如注销）时的轮询上限
+const settleTimeout = 2 * time.Second
+
+// 启动一个使用默认配置的测试服务器：事件循环已运行，/ws、/poll、/broadcast 挂在 httptest 服务器上，
+// 测试结束时优雅关闭
+func NewTestServer(t testing.TB) (*Server, *httptest.Server) {
+	t.Helper()
+	return newTestServer(t, DefaultServerConfig(), nil)
+}
+
+// 与 NewTestServer 相同，但可以指定配置，并在 Run 之前由 setup 设置服务器字段
+func newTestServer(t testing.TB, config ServerConfig, setup func(s *Server)) (*Server, *httptest.Server) {
+	t.Helper()
+	s := NewServer(config)
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if setup != nil {
+		setup(s)
+	}
+	go s.Run()
+	waitFor(t, "event loop running", s.running.Load)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", s.Handler())
+	mux.HandleFunc("/poll", s.HandlePoll)
+	mux.HandleFunc("/broadcast", s.HandleBroadcast)
+	ts := httptest.NewServer(mux)
+
+	t.Cleanup(func() {
+		// 测试本身可能已经关闭了服务器
+		if !s.closing.Load() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			s.Shutdown(ctx)
+		}
+		ts.Close()
+	})
+	return s, ts
+}
+
+// 测试用的 WebSocket 客户端。后台 goroutine 持续读取，消息按顺序放入 frames，
+// 这样等待超时不会像 SetReadDeadline 那样弄坏连接
+type TestClient struct {
+	t    testing.TB
+	Conn *websocket.Conn
+	ID   string // 连接确认中的客户端ID
+
+	frames chan testFrame
+	done   chan struct{} // 读取结束（连接已关闭）
+	err    error         // 读取结束的原因，done 关闭后可读
+}
+
+type testFrame struct {
+	messageType int
+	payload     []byte
+}
+
+// 连接测试服务器的 /ws 并读取连接确认，query 为附加的查询参数（如 "channels=a,b"）
+func Dial(t testing.TB, ts *httptest.Server, query string) *TestClient {
+	t.Helper()
+	c := DialHeader(t, ts, query, nil)
+	ack := c.Expect("connect")
+	c.ID = ack.ClientID
+	return c
+}
+
+// 带自定义握手头连接，不读取连接确认（用于子协议、压缩等需要检查握手结果的测试）
+func DialHeader(t testing.TB, ts *httptest.Server, query string, header http.Header) *TestClient {
+	t.Helper()
+	conn, resp, err := dialRaw(ts, query, header, websocket.DefaultDialer)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("连接失败: %v (status %d)", err, status)
+	}
+	return newTestClient(t, conn)
+}
+
+func dialRaw(ts *httptest.Server, query string, header http.Header, dialer *websocket.Dialer) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	if query != "" {
+		url += "?" + query
+	}
+	return dialer.Dial(url, header)
+}
+
+func newTestClient(t testing.TB, conn *websocket.Conn) *TestClient {
+	c := &TestClient{
+		t:      t,
+		Conn:   conn,
+		frames: make(chan testFrame, 1024),
+		done:   make(chan struct{}),
+	}
+	go c.readLoop()
+	t.Cleanup(func() { conn.Close() })
+	return c
+}
+
+func (c *TestClient) readLoop() {
+	defer close(c.done)
+	for {
+		messageType, payload, err := c.Conn.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.frames <- testFrame{messageType: messageType, payload: payload}
+	}
+}
+
+// 发送一条消息
+func (c *TestClient) Send(msg Message) {
+	c.t.Helper()
+	if err := c.Conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("发送失败: %v", err)
+	}
+}
+
+// 订阅频道并等待确认，确认不是 200 时测试失败
+func (c *TestClient) Subscribe(channel string) Response {
+	c.t.Helper()
+	c.Send(Message{Action: "subscribe", Channel: channel})
+	ack := c.Expect("subscribe")
+	if ack.Code != CodeSuccess {
+		c.t.Fatalf("订阅 %s 失败: %d %s", channel, ack.Code, ack.Msg)
+	}
+	return ack
+}
+
+// 向频道发布并返回发布确认
+func (c *TestClient) Publish(channel string, data interface{}) Response {
+	c.t.Helper()
+	c.Send(Message{Action: "publish", Channel: channel, Data: data})
+	return c.Expect("publish")
+}
+
+// 下一帧的原始内容，timeout 内没有收到或连接已关闭时返回错误
+func (c *TestClient) NextFrame(timeout time.Duration) (messageType int, payload []byte, err error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case f := <-c.frames:
+		return f.messageType, f.payload, nil
+	case <-c.done:
+		// 关闭前已经读到的帧仍然按顺序交出
+		select {
+		case f := <-c.frames:
+			return f.messageType, f.payload, nil
+		default:
+		}
+		return 0, nil, c.err
+	case <-timer.C:
+		return 0, nil, errors.New("timed out waiting for message")
+	}
+}
+
+// 下一条消息，解码为 Response
+func (c *TestClient) NextMessage(timeout time.Duration) (Response, error) {
+	_, payload, err := c.NextFrame(timeout)
+	if err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// 跳过其它消息，返回下一条 action 为 action 的消息；超时测试失败
+func (c *TestClient) Expect(action string) Response {
+	c.t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		resp, err := c.NextMessage(time.Until(deadline))
+		if err != nil {
+			c.t.Fatalf("等待 %s 消息: %v", action, err)
+		}
+		if resp.Action == action {
+			return resp
+		}
+	}
+}
+
+// 断言 d 内没有收到任何消息
+func (c *TestClient) ExpectNone(d time.Duration) {
+	c.t.Helper()
+	if resp, err := c.NextMessage(d); err == nil {
+		c.t.Fatalf("不应收到消息，实际收到 %+v", resp)
+	}
+}
+
+// 等待连接关闭，返回关闭帧中的关闭码和原因（没有关闭帧时为 1006）
+func (c *TestClient) ExpectClosed() (code int, reason string) {
+	c.t.Helper()
+	select {
+	case <-c.done:
+	case <-time.After(testTimeout):
+		c.t.Fatal("连接没有关闭")
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(c.err, &closeErr) {
+		return closeErr.Code, closeErr.Text
+	}
+	return websocket.CloseAbnormalClosure, ""
+}
+
+// 轮询直到 cond 为 true，超时测试失败
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 服务器当前的连接数
+func connectionCount(s *Server) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
+// 按ID取服务器端的客户端，不存在时为 nil
+func serverClient(s *Server, id string) *Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byID[id]
+}
@@ -0,0 +1,50 @@
+package main
+
+// 一次订阅数阈值穿越
+type thresholdCrossing struct {
+	channel string
+	count   int
+	rising  bool
+}
+
+// 为单个频道设置订阅数阈值，覆盖全局的 ChannelThresholds；传 nil 恢复使用全局配置
+func (s *Server) SetChannelThresholds(channel string, thresholds []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if thresholds == nil {
+		delete(s.channelThresholds, channel)
+		return
+	}
+	s.channelThresholds[channel] = append([]int(nil), thresholds...)
+}
+
+// 计算订阅数从 before 变为 after 时穿越的阈值（调用方需持有锁）。
+// 上升：before < t <= after；下降：after < t <= before
+func (s *Server) thresholdCrossings(channel string, before, after int) []thresholdCrossing {
+	if s.OnChannelThreshold == nil || before == after {
+		return nil
+	}
+
+	thresholds, ok := s.channelThresholds[channel]
+	if !ok {
+		thresholds = s.ChannelThresholds
+	}
+
+	var crossings []thresholdCrossing
+	for _, t := range thresholds {
+		if before < t && t <= after {
+			crossings = append(crossings, thresholdCrossing{channel: channel, count: after, rising: true})
+		} else if after < t && t <= before {
+			crossings = append(crossings, thresholdCrossing{channel: channel, count: after, rising: false})
+		}
+	}
+	return crossings
+}
+
+// 触发阈值回调，必须在释放锁之后调用
+func (s *Server) fireThresholds(crossings []thresholdCrossing) {
+	for _, c := range crossings {
+		s.OnChannelThreshold(c.channel, c.count, c.rising)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// 单个连接的流量计数器
+// Payload* 为应用层消息字节数（压缩前），Wire* 为实际读写底层连接的字节数（含帧头，启用压缩时为压缩后大小）
+type connCounters struct {
+	framesSent       atomic.Int64
+	framesReceived   atomic.Int64
+	payloadSent      atomic.Int64
+	payloadReceived  atomic.Int64
+	wireBytesSent    atomic.Int64
+	wireBytesReceive atomic.Int64
+}
+
+// 流量计数快照
+type TrafficStats struct {
+	FramesSent       int64 `json:"framesSent"`
+	FramesReceived   int64 `json:"framesReceived"`
+	PayloadSent      int64 `json:"payloadBytesSent"`
+	PayloadReceived  int64 `json:"payloadBytesReceived"`
+	WireBytesSent    int64 `json:"wireBytesSent"`
+	WireBytesReceive int64 `json:"wireBytesReceived"`
+}
+
+func (c *connCounters) snapshot() TrafficStats {
+	return TrafficStats{
+		FramesSent:       c.framesSent.Load(),
+		FramesReceived:   c.framesReceived.Load(),
+		PayloadSent:      c.payloadSent.Load(),
+		PayloadReceived:  c.payloadReceived.Load(),
+		WireBytesSent:    c.wireBytesSent.Load(),
+		WireBytesReceive: c.wireBytesReceive.Load(),
+	}
+}
+
+// 记录一次发送
+func (c *connCounters) sent(n int) {
+	c.framesSent.Add(1)
+	c.payloadSent.Add(int64(n))
+}
+
+// 记录一次接收
+func (c *connCounters) received(n int) {
+	c.framesReceived.Add(1)
+	c.payloadReceived.Add(int64(n))
+}
+
+// 统计底层读写字节数的连接
+type countingConn struct {
+	net.Conn
+	counters  *connCounters
+	handshake func(response []byte) // 第一次写入（握手响应）时调用一次
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counters.wireBytesReceive.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	// 升级后的第一次写入是 101 握手响应
+	if c.handshake != nil {
+		c.handshake(p)
+		c.handshake = nil
+	}
+	n, err := c.Conn.Write(p)
+	c.counters.wireBytesSent.Add(int64(n))
+	return n, err
+}
+
+// 包装 ResponseWriter，让升级时劫持到的连接带上字节计数。
+// 只有 Upgrader 设置了读写缓冲区大小时，gorilla 才会完全通过劫持的连接读写
+type countingResponseWriter struct {
+	http.ResponseWriter
+	counters *connCounters
+
+	// 收到写出的握手响应（可选），用于查看实际协商出的扩展
+	handshake func(response []byte)
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, counters: w.counters, handshake: w.handshake}, brw, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 消息没有携带 data
+var ErrMissingData = errors.New("missing data")
+
+// 把消息的 Data 解码为具体类型 T。JSON 解析后的 Data 是 map[string]interface{}、[]interface{} 等通用类型，
+// 这里经 json 往返一次转换；Data 已经是 T（如自定义解码器直接给出）时原样返回。
+// Data 与 T 不匹配时返回的错误注明 action 和原因，可以直接作为错误响应的 msg
+func DecodeData[T any](m *Message) (T, error) {
+	var v T
+	if m.Data == nil {
+		return v, ErrMissingData
+	}
+	if typed, ok := m.Data.(T); ok {
+		return typed, nil
+	}
+
+	raw, err := json.Marshal(m.Data)
+	if err != nil {
+		return v, fmt.Errorf("action %s: encode data: %w", m.Action, err)
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return v, fmt.Errorf("action %s: invalid data: %w", m.Action, err)
+	}
+	return v, nil
+}
+
+// 类型化的频道广播：与 BroadcastToChannel 相同的线上格式，但由编译器保证同一频道的数据类型一致，
+// 例如 BroadcastTyped(server, "ticks", Tick{Symbol: "BTC", Price: 1})
+func BroadcastTyped[T any](s *Server, channel string, data T) {
+	s.BroadcastToChannel(channel, data)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type testTick struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+}
+
+// 与线上一样，经 JSON 解析得到的消息，Data 为 map[string]interface{} 等通用类型
+func decodedMessage(t *testing.T, raw string) *Message {
+	t.Helper()
+	var m Message
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatal(err)
+	}
+	return &m
+}
+
+func TestDecodeData(t *testing.T) {
+	m := decodedMessage(t, `{"action":"tick","data":{"symbol":"BTC","price":1.5}}`)
+	tick, err := DecodeData[testTick](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick != (testTick{Symbol: "BTC", Price: 1.5}) {
+		t.Fatalf("DecodeData = %+v", tick)
+	}
+
+	// Data 已经是目标类型时原样返回
+	direct := &Message{Action: "tick", Data: testTick{Symbol: "ETH"}}
+	if tick, err := DecodeData[testTick](direct); err != nil || tick.Symbol != "ETH" {
+		t.Fatalf("DecodeData = %+v, %v", tick, err)
+	}
+}
+
+func TestDecodeDataMismatch(t *testing.T) {
+	m := decodedMessage(t, `{"action":"tick","data":{"symbol":"BTC","price":"high"}}`)
+	if _, err := DecodeData[testTick](m); err == nil {
+		t.Fatal("price 类型不符应返回错误")
+	}
+	m = decodedMessage(t, `{"action":"tick","data":"BTC"}`)
+	if _, err := DecodeData[testTick](m); err == nil {
+		t.Fatal("data 不是对象应返回错误")
+	}
+	m = decodedMessage(t, `{"action":"tick"}`)
+	if _, err := DecodeData[testTick](m); !errors.Is(err, ErrMissingData) {
+		t.Fatalf("缺少 data 返回 %v, want ErrMissingData", err)
+	}
+}
+
+func TestBroadcastTyped(t *testing.T) {
+	s, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+	c.Subscribe("ticks")
+
+	BroadcastTyped(s, "ticks", testTick{Symbol: "BTC", Price: 2})
+	msg := c.Expect("message")
+	tick, err := DecodeData[testTick](&Message{Action: msg.Action, Data: msg.Data})
+	if err != nil || tick != (testTick{Symbol: "BTC", Price: 2}) {
+		t.Fatalf("收到 %+v, %v", tick, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// 入站消息校验的默认限制
+const (
+	defaultMaxActionLength  = 64
+	defaultMaxChannelLength = 256
+	defaultMaxDataDepth     = 32
+)
+
+// 校验入站消息：原始帧必须是合法 UTF-8，Action/Channel 不超过长度限制，Data 嵌套不超过深度限制。
+// 深度限制防御体积很小但嵌套极深、下游处理代价很高的恶意负载
+func (s *Server) validateMessage(raw []byte, msg *Message) error {
+	if !utf8.Valid(raw) {
+		return fmt.Errorf("message is not valid UTF-8")
+	}
+
+	maxAction := s.MaxActionLength
+	if maxAction <= 0 {
+		maxAction = defaultMaxActionLength
+	}
+	if len(msg.Action) > maxAction {
+		return fmt.Errorf("action exceeds %d bytes", maxAction)
+	}
+
+	maxChannel := s.maxChannelLength()
+	if len(msg.Channel) > maxChannel {
+		return fmt.Errorf("channel exceeds %d bytes", maxChannel)
+	}
+	for _, channel := range msg.Channels {
+		if channel == "" {
+			return fmt.Errorf("empty channel name")
+		}
+		if len(channel) > maxChannel {
+			return fmt.Errorf("channel exceeds %d bytes", maxChannel)
+		}
+	}
+
+	maxDepth := s.MaxDataDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDataDepth
+	}
+	if exceedsDepth(msg.Data, maxDepth) {
+		return fmt.Errorf("data nesting exceeds depth %d", maxDepth)
+	}
+	return nil
+}
+
+func (s *Server) maxChannelLength() int {
+	if s.MaxChannelLength > 0 {
+		return s.MaxChannelLength
+	}
+	return defaultMaxChannelLength
+}
+
+// 规范化并校验一个客户端给出的频道名，返回规范化后的名字
+func (s *Server) checkChannel(name string) (string, error) {
+	if s.ChannelNormalizer != nil {
+		name = s.ChannelNormalizer(name)
+	}
+	if isPattern(name) {
+		if err := validatePattern(name); err != nil {
+			return name, err
+		}
+	}
+	if s.ChannelValidator != nil {
+		return name, s.ChannelValidator(name)
+	}
+	return name, s.defaultChannelValidator(name)
+}
+
+// 原地规范化消息中的 Channel 和 Channels 并逐个校验。
+// 规范化后为空的 Channel 留给后面的必填检查；出错时返回出错的频道名
+func (s *Server) checkMessageChannels(msg *Message) (string, error) {
+	if msg.Channel != "" {
+		channel, err := s.checkChannel(msg.Channel)
+		if err != nil && channel != "" {
+			return msg.Channel, err
+		}
+		msg.Channel = channel
+	}
+	for i, original := range msg.Channels {
+		channel, err := s.checkChannel(original)
+		if err != nil {
+			return original, err
+		}
+		msg.Channels[i] = channel
+	}
+	return "", nil
+}
+
+// 默认的频道名校验：非空、不超过 MaxChannelLength，只含 ASCII 字母、数字和 "-_.:@/*"
+func (s *Server) defaultChannelValidator(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty channel name")
+	}
+	if max := s.maxChannelLength(); len(name) > max {
+		return fmt.Errorf("channel exceeds %d bytes", max)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_.:@/*", r):
+		default:
+			return fmt.Errorf("channel contains invalid character %q", r)
+		}
+	}
+	return nil
+}
+
+// 规范化并校验 ?channels= 中的频道后订阅，未通过校验的频道收到 CodeInvalidChannel 的确认
+func (s *Server) autoSubscribeChannel(client *Client, name string) {
+	channel, err := s.checkChannel(name)
+	if err != nil {
+		response := errorResponse(client, "subscribe", CodeInvalidChannel, err.Error())
+		response.Channel = name
+		s.sendResponse(client, response)
+		return
+	}
+	s.handleSubscribe(client, channel, subscribeOptions{})
+}
+
+// 开启 BatchAcks 时自动订阅的全部频道合并为一条确认，没有通过校验的频道作为失败结果列在其中
+func (s *Server) autoSubscribeChannels(client *Client, names []string) {
+	channels := make([]string, 0, len(names))
+	var rejected []ChannelResult
+	for _, name := range names {
+		channel, err := s.checkChannel(name)
+		if err != nil {
+			rejected = append(rejected, ChannelResult{Channel: name, Code: CodeInvalidChannel, Msg: err.Error()})
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	s.subscribeMany(client, channels, subscribeOptions{}, rejected)
+}
+
+// 为 action 注册 Data 校验器，在消息分发给具体处理之前调用；返回错误时以 422 响应并丢弃该消息。
+// 传 nil 取消注册。应在启动前调用
+func (s *Server) SetActionValidator(action string, v func(data interface{}) error) {
+	if v == nil {
+		delete(s.actionValidators, action)
+		return
+	}
+	s.actionValidators[action] = v
+}
+
+// 判断 JSON 解码后的值嵌套是否超过 limit 层，超过后立即停止遍历
+func exceedsDepth(v interface{}, limit int) bool {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if limit <= 0 {
+			return true
+		}
+		for _, child := range value {
+			if exceedsDepth(child, limit-1) {
+				return true
+			}
+		}
+	case []interface{}:
+		if limit <= 0 {
+			return true
+		}
+		for _, child := range value {
+			if exceedsDepth(child, limit-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
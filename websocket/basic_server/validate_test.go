@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActionValidator(t *testing.T) {
+	_, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.SetActionValidator("publish", func(data interface{}) error {
+			order, ok := data.(map[string]interface{})
+			if !ok || order["symbol"] == nil {
+				return errors.New("symbol is required")
+			}
+			return nil
+		})
+	})
+	sender := Dial(t, ts, "")
+	sender.Subscribe("CHAT:lobby")
+	sender.Subscribe("orders")
+	receiver := Dial(t, ts, "")
+	receiver.Subscribe("orders")
+
+	sender.Send(Message{Action: "publish", Channel: "orders", Data: map[string]interface{}{"qty": 1}, RequestID: "r1"})
+	resp := sender.Expect("publish")
+	if resp.Code != CodeInvalidData || resp.Msg != "symbol is required" || resp.RequestID != "r1" {
+		t.Fatalf("校验失败的响应 %+v", resp)
+	}
+	receiver.ExpectNone(100 * time.Millisecond)
+
+	if resp := sender.Publish("orders", map[string]interface{}{"symbol": "ABC"}); resp.Code != CodeSuccess {
+		t.Fatalf("合法消息被拒绝: %+v", resp)
+	}
+	receiver.Expect("message")
+}
+
+func TestChannelValidation(t *testing.T) {
+	_, ts := NewTestServer(t)
+	c := Dial(t, ts, "")
+
+	tests := []struct {
+		action, channel string
+		code            int
+	}{
+		{"subscribe", "has space", CodeInvalidChannel},
+		{"subscribe", "bad!", CodeInvalidChannel},
+		{"subscribe", "频道", CodeInvalidChannel},
+		{"publish", "bad#name", CodeInvalidChannel},
+		{"subscribe", strings.Repeat("a", defaultMaxChannelLength+1), CodeBadRequest},
+	}
+	for _, tt := range tests {
+		c.Send(Message{Action: tt.action, Channel: tt.channel, Data: "x"})
+		if resp := c.Expect(tt.action); resp.Code != tt.code {
+			t.Errorf("%s %q: code %d, want %d", tt.action, tt.channel, resp.Code, tt.code)
+		}
+	}
+	c.Subscribe("chat:room-1/a_b.c@x")
+}
+
+func TestChannelNormalizerAndCustomValidator(t *testing.T) {
+	s, ts := newTestServer(t, DefaultServerConfig(), func(s *Server) {
+		s.ChannelNormalizer = func(name string) string { return strings.ToLower(strings.TrimSpace(name)) }
+		s.ChannelValidator = func(name string) error {
+			if !strings.HasPrefix(name, "chat:") {
+				return errors.New("channel must start with chat:")
+			}
+			return nil
+		}
+	})
+	c := Dial(t, ts, "")
+
+	// 规范化后的名字写入确认，订阅的也是规范化后的频道
+	if ack := c.Subscribe("  Chat:Lobby "); ack.Channel != "chat:lobby" {
+		t.Fatalf("确认中的频道 %q, want chat:lobby", ack.Channel)
+	}
+	if got := s.ChannelSubscribers("chat:lobby"); len(got) != 1 {
+		t.Fatalf("chat:lobby 的订阅者 %v", got)
+	}
+	sender := Dial(t, ts, "")
+	sender.Subscribe("CHAT:lobby")
+	if resp := sender.Publish("CHAT:LOBBY", "hi"); resp.Code != CodeSuccess {
+		t.Fatalf("发布失败: %d %s", resp.Code, resp.Msg)
+	}
+	if msg := c.Expect("message"); msg.Channel != "chat:lobby" || msg.Data != "hi" {
+		t.Fatalf("收到 %+v", msg)
+	}
+
+	c.Send(Message{Action: "subscribe", Channel: "lobby"})
+	if resp := c.Expect("subscribe"); resp.Code != CodeInvalidChannel || resp.Msg != "channel must start with chat:" {
+		t.Fatalf("自定义校验的响应 %+v", resp)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// 遗嘱消息：连接断开（包括异常断开）时由服务器代为发布
+type lastWill struct {
+	channel string
+	data    interface{}
+}
+
+// 处理 set_will：带 data 时登记遗嘱（覆盖之前的），不带 data 时清除。
+// 登记时按发布的规则检查 CanPublish，但不要求已订阅该频道
+func (s *Server) handleSetWill(client *Client, msg *Message) {
+	response := Response{
+		ClientID:  client.ID,
+		RequestID: msg.RequestID,
+		Action:    "set_will",
+		Channel:   msg.Channel,
+		Code:      CodeSuccess,
+		Msg:       "success",
+	}
+
+	if msg.Data == nil {
+		client.willMu.Lock()
+		client.will = nil
+		client.willMu.Unlock()
+		s.sendResponse(client, response)
+		return
+	}
+
+	switch {
+	case msg.Channel == "":
+		response.Code = CodeMissingChannel
+		response.Msg = "channel is required"
+	case isPattern(msg.Channel):
+		response.Code = CodeBadRequest
+		response.Msg = "cannot publish to a pattern"
+	case !s.canPublish(client, msg.Channel):
+		response.Code = CodeForbidden
+		response.Msg = "publish not allowed"
+	default:
+		client.willMu.Lock()
+		client.will = &lastWill{channel: msg.Channel, data: msg.Data}
+		client.willMu.Unlock()
+	}
+	s.sendResponse(client, response)
+}
+
+// 发布客户端的遗嘱（在 readPump 退出时、注销之前调用）。事件循环已退出时放弃
+func (s *Server) publishWill(client *Client) {
+	client.willMu.Lock()
+	will := client.will
+	client.will = nil
+	client.willMu.Unlock()
+	if will == nil {
+		return
+	}
+
+	s.Logger.Debug("发布遗嘱消息", "event", "will", "client_id", client.ID, "channel", will.channel)
+	select {
+	case s.broadcast <- BroadcastMsg{Channel: will.channel, Data: will.data, Except: client.ID}:
+	case <-s.done:
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLastWill(t *testing.T) {
+	_, ts := NewTestServer(t)
+	b := Dial(t, ts, "")
+	b.Subscribe("room")
+
+	setWill := func(c *TestClient, data interface{}) {
+		t.Helper()
+		c.Send(Message{Action: "set_will", Channel: "room", Data: data})
+		if resp := c.Expect("set_will"); resp.Code != CodeSuccess {
+			t.Fatalf("set_will: %d %s", resp.Code, resp.Msg)
+		}
+	}
+
+	// 异常断开（直接关闭 TCP 连接）
+	a := Dial(t, ts, "")
+	setWill(a, "a is gone")
+	a.Conn.Close()
+	if msg := b.Expect("message"); msg.Data != "a is gone" || msg.Channel != "room" {
+		t.Fatalf("收到 %+v", msg)
+	}
+
+	// 正常关闭（先发关闭帧）
+	c := Dial(t, ts, "")
+	setWill(c, "c left")
+	c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if msg := b.Expect("message"); msg.Data != "c left" {
+		t.Fatalf("收到 %+v", msg)
+	}
+
+	// 清除后断开不再发布
+	d := Dial(t, ts, "")
+	setWill(d, "never sent")
+	d.Send(Message{Action: "set_will"})
+	d.Expect("set_will")
+	d.Conn.Close()
+	b.ExpectNone(200 * time.Millisecond)
+}